@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// detectAnomalies watches for signs of ransomware at work, set by --detect-anomalies: bursts of file
+// events, many files changing extension, and changed files whose content looks encrypted. A suspect
+// backup raises an alert and never deletes files from the watch folder.
+var detectAnomalies bool
+
+// anomalyBurst is how many file events within anomalyWindow count as a burst, set by --anomaly-burst.
+var anomalyBurst = 1000
+
+const (
+	anomalyWindow     = time.Minute
+	anomalyMinFiles   = 20   // Extension changes, or scanned files, before a share is judged
+	entropySample     = 4096 // Bytes read from the start of each changed file
+	entropyMinSample  = 1024 // Smaller files say too little about their content
+	entropyEncrypted  = 7.5  // Bits per byte above which a sample looks encrypted or compressed
+	encryptedFraction = 0.5  // Share of scanned files that must look encrypted
+)
+
+// compressedExtensions are formats whose content looks random anyway, so they are not scanned.
+var compressedExtensions = map[string]bool{
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".mp3": true,
+	".mp4": true, ".mkv": true, ".mov": true, ".avi": true, ".pdf": true, ".docx": true, ".xlsx": true,
+	".pptx": true, ".odt": true, ".ods": true, ".jar": true, ".apk": true, ".gpg": true, ".age": true,
+}
+
+// anomalyDetector looks for bursts and extension changes in the file events of one watch folder.
+type anomalyDetector struct {
+	events  []time.Time          // Events within the window
+	removed map[string]fileMark  // Files removed or renamed within the window, by path
+	stems   map[string]fileMark  // The same, by path without extension
+	created map[string]fileMark  // Files created within the window, by path without extension
+	changes []time.Time          // Extension changes within the window
+	alerted map[string]time.Time // Last alert by kind, so each is raised once per window
+}
+
+// fileMark is a file that appeared or disappeared, to pair with its counterpart under another extension.
+type fileMark struct {
+	ext  string
+	time time.Time
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newAnomalyDetector returns a detector, or nil without --detect-anomalies.
+func newAnomalyDetector() *anomalyDetector {
+	if !detectAnomalies {
+		return nil
+	}
+	return &anomalyDetector{removed: make(map[string]fileMark), stems: make(map[string]fileMark),
+		created: make(map[string]fileMark), alerted: make(map[string]time.Time)}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// observe records a file event. It returns the kind and a description of an anomaly the event completes,
+// or "" if there is none or it was already reported within the window.
+func (d *anomalyDetector) observe(event fsnotify.Event) (kind, reason string) {
+	if d == nil {
+		return "", ""
+	}
+	now := time.Now()
+	cutoff := now.Add(-anomalyWindow)
+	d.events = append(dropBefore(d.events, cutoff), now)
+	d.changes = dropBefore(d.changes, cutoff)
+	for _, marks := range []map[string]fileMark{d.removed, d.stems, d.created} {
+		for key, mark := range marks {
+			if mark.time.Before(cutoff) {
+				delete(marks, key)
+			}
+		}
+	}
+
+	// "report.docx" replaced by "report.docx.locked" or "report.locked", in either order
+	path := event.Name
+	ext := strings.ToLower(filepath.Ext(path))
+	stem := strings.TrimSuffix(path, filepath.Ext(path))
+	switch {
+	case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		if c, ok := d.created[path]; ok && c.ext != "" {
+			delete(d.created, path)
+			d.changes = append(d.changes, now)
+		} else if c, ok := d.created[stem]; ok && c.ext != ext {
+			delete(d.created, stem)
+			d.changes = append(d.changes, now)
+		} else {
+			d.removed[path], d.stems[stem] = fileMark{ext, now}, fileMark{ext, now}
+		}
+	case event.Op&fsnotify.Create != 0:
+		if _, ok := d.removed[stem]; ok {
+			delete(d.removed, stem)
+			d.changes = append(d.changes, now)
+		} else if r, ok := d.stems[stem]; ok && r.ext != ext {
+			delete(d.stems, stem)
+			d.changes = append(d.changes, now)
+		} else {
+			d.created[stem] = fileMark{ext, now}
+		}
+	}
+
+	switch {
+	case len(d.events) >= anomalyBurst:
+		kind, reason = "burst", fmt.Sprintf("%d file events within %s", len(d.events), anomalyWindow)
+	case len(d.changes) >= anomalyMinFiles:
+		kind, reason = "extensions", fmt.Sprintf("%d files changed extension within %s", len(d.changes), anomalyWindow)
+	default:
+		return "", ""
+	}
+	if now.Sub(d.alerted[kind]) < anomalyWindow {
+		return "", ""
+	}
+	d.alerted[kind] = now
+	return kind, reason
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// dropBefore removes the times before cutoff from a list in ascending order.
+func dropBefore(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// runAnomaly collects the signs of an anomaly during one backup run. Its methods do nothing on a nil value.
+type runAnomaly struct {
+	mu        sync.Mutex
+	reason    string // Set by the monitor, or once the content scan is judged
+	scanned   int
+	encrypted int
+	judged    bool
+}
+
+type anomalyKey struct{}
+
+// ------------------------------------------------------------------------------------------------------------
+// withAnomaly returns a context for a backup run that scans changed files for encrypted content, starting
+// from an anomaly the monitor already saw, if any. Without --detect-anomalies it returns ctx unchanged.
+func withAnomaly(ctx context.Context, reason string) context.Context {
+	if !detectAnomalies || anomalyFrom(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, anomalyKey{}, &runAnomaly{reason: reason})
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// anomalyFrom returns the anomaly state of a backup run, or nil if anomalies are not detected.
+func anomalyFrom(ctx context.Context) *runAnomaly {
+	a, _ := ctx.Value(anomalyKey{}).(*runAnomaly)
+	return a
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// scan samples the start of a changed file and counts it if the content looks encrypted.
+func (a *runAnomaly) scan(path string) {
+	if a == nil || compressedExtensions[strings.ToLower(filepath.Ext(path))] {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	sample := make([]byte, entropySample)
+	n, _ := io.ReadFull(file, sample)
+	if n < entropyMinSample {
+		return
+	}
+
+	encrypted := entropy(sample[:n]) > entropyEncrypted
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.scanned++
+	if encrypted {
+		a.encrypted++
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// entropy returns the Shannon entropy of data in bits per byte, from 0 to 8.
+func entropy(data []byte) float64 {
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	var bits float64
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / float64(len(data))
+			bits -= p * math.Log2(p)
+		}
+	}
+	return bits
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// suspect returns why the run looks like it is backing up damaged files, or "" if it does not. The content
+// scan is judged, and raises an alert, the first time it is called.
+func (a *runAnomaly) suspect(watchFolder string) string {
+	if a == nil {
+		return ""
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.judged {
+		a.judged = true
+		if a.scanned >= anomalyMinFiles && float64(a.encrypted) >= float64(a.scanned)*encryptedFraction {
+			reason := fmt.Sprintf("%d of %d changed files look encrypted", a.encrypted, a.scanned)
+			anomalyDetected(watchFolder, "entropy", reason)
+			if a.reason == "" {
+				a.reason = reason
+			}
+		}
+	}
+	return a.reason
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// anomalyDetected raises the alert for an anomaly and counts it.
+func anomalyDetected(watchFolder, kind, reason string) {
+	slog.Warn("ALERT: possible ransomware activity, the backup is kept but will not be the base of later differential backups", "event", "anomaly_detected",
+		"path", watchFolder, "kind", kind, "reason", reason)
+	anomalies.WithLabelValues(watchFolder, kind).Inc()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Virus scanning of files before they are archived. clamdAddress is a clamd socket path or host:port, set
+// by --clamd; scanCommand is a shell command run for every file, set by --scan-command, that exits 1 if
+// the file is infected.
+var (
+	clamdAddress string
+	scanCommand  string
+	scanAction   = scanSkip
+)
+
+// What happens to an infected file, set by --scan-action.
+const (
+	scanSkip       = "skip"       // Leave it out of the backup
+	scanQuarantine = "quarantine" // Leave it out and move it to the --quarantine folder
+	scanAbort      = "abort"      // Fail the backup
+)
+
+// infectedReason starts the reason of skipped files a scanner flagged.
+const infectedReason = "infected: "
+
+// errInfected fails a backup that found an infected file with --scan-action abort.
+var errInfected = errors.New("infected file")
+
+const clamdTimeout = 5 * time.Minute // For one file, including the upload to clamd
+
+// ------------------------------------------------------------------------------------------------------------
+// skipInfected scans a file with clamd or the scan command, if either is set, and applies --scan-action if
+// it is infected: it lists the file as skipped and returns true, or returns errInfected. Files that cannot
+// be scanned are archived, with a warning.
+func skipInfected(ctx context.Context, skipped *[]skippedFile, path, relPath string, info os.FileInfo) (bool, error) {
+	if (clamdAddress == "" && scanCommand == "") || !info.Mode().IsRegular() {
+		return false, nil
+	}
+	signature, err := scanFile(ctx, path)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		slog.Warn("Failed to scan file for viruses, archiving it unscanned", "path", path, "error", err)
+		return false, nil
+	}
+	if signature == "" {
+		return false, nil
+	}
+
+	slog.Warn("ALERT: infected file found", "event", "file_infected", "path", path, "signature", signature, "action", scanAction)
+	if scanAction == scanAbort {
+		return false, annotate(stageRead, path, fmt.Errorf("%w: %s", errInfected, signature))
+	}
+	entry := skippedFile{Path: relPath, Size: info.Size(), Reason: infectedReason + signature}
+	if scanAction == scanQuarantine {
+		if dest, err := quarantine(path, relPath); err != nil {
+			slog.Warn("Failed to quarantine file", "path", path, "error", err)
+		} else {
+			log.Printf("Quarantined %s to %s\n", path, dest)
+			entry.Quarantined = dest
+		}
+	}
+	*skipped = append(*skipped, entry)
+	return true, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// scanFile returns the name of the virus found in a file, or "" if it is clean.
+func scanFile(ctx context.Context, path string) (string, error) {
+	if clamdAddress != "" {
+		return scanClamd(ctx, path)
+	}
+	return scanWithCommand(ctx, path)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// scanClamd streams a file to clamd with the INSTREAM command, so clamd needs no access to the file itself.
+func scanClamd(ctx context.Context, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	network := "tcp"
+	if strings.ContainsAny(clamdAddress, `/\`) {
+		network = "unix"
+	}
+	ctx, cancel := context.WithTimeout(ctx, clamdTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, clamdAddress)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	for {
+		n, err := file.Read(*buf)
+		if n > 0 {
+			if err := binary.Write(conn, binary.BigEndian, uint32(n)); err != nil {
+				return "", err
+			}
+			if _, err := conn.Write((*buf)[:n]); err != nil {
+				return "", err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := binary.Write(conn, binary.BigEndian, uint32(0)); err != nil {
+		return "", err
+	}
+
+	// "stream: OK", "stream: Eicar-Signature FOUND" or "... ERROR"
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && (err != io.EOF || reply == "") {
+		return "", err
+	}
+	result := strings.TrimSpace(strings.TrimPrefix(strings.TrimRight(reply, "\x00"), "stream:"))
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd: %s", result)
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// scanWithCommand runs the scan command with the file in FOLDERMON_FILE. Exit status 1 means infected, as
+// with clamscan, and the last line of output names the virus.
+func scanWithCommand(ctx context.Context, path string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", scanCommand)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", scanCommand)
+	}
+	cmd.Env = append(os.Environ(), "FOLDERMON_FILE="+path)
+	output, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return "", nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		if signature := strings.TrimSpace(lines[len(lines)-1]); signature != "" {
+			return signature, nil
+		}
+		return "unknown", nil
+	default:
+		return "", fmt.Errorf("scan command %q: %w: %s", scanCommand, err, bytes.TrimSpace(output))
+	}
+}
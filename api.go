@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// apiTokenEnv holds the control API token when it is not given with --api-token, keeping it out of the
+// process list.
+const apiTokenEnv = "FOLDERMON_API_TOKEN"
+
+// apiToken enables the control API on the --metrics-listen address. Every request must carry it as
+// "Authorization: Bearer <token>".
+var apiToken string
+
+// ------------------------------------------------------------------------------------------------------------
+// registerAPI adds the control API to mux:
+//
+//	POST /api/backup   back up now
+//	POST /api/pause    suspend archiving until resumed
+//	POST /api/resume   resume archiving
+//	GET  /api/history  backup runs recorded in the catalog, newest first
+//
+// Each acts on every watch, or only on the one named by the watch query parameter. Requests must carry
+// token, unless it is empty; only the control socket, protected by its file permissions, does without.
+func registerAPI(mux *http.ServeMux, token string) {
+	mux.HandleFunc("/api/backup", authorized(token, http.MethodPost, controlHandler(controlBackup)))
+	mux.HandleFunc("/api/pause", authorized(token, http.MethodPost, controlHandler(controlPause)))
+	mux.HandleFunc("/api/resume", authorized(token, http.MethodPost, controlHandler(controlResume)))
+	mux.HandleFunc("/api/history", authorized(token, http.MethodGet, handleHistory))
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// authorized wraps an API handler, rejecting requests with another method or without the token.
+func authorized(token, method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token != "" && (!ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			apiError(w, http.StatusUnauthorized, "missing or wrong API token")
+			return
+		}
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			apiError(w, http.StatusMethodNotAllowed, "use "+method)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// controlHandler returns a handler passing request to the selected monitors.
+func controlHandler(request string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		monitors := selectMonitors(r.URL.Query().Get("watch"))
+		if len(monitors) == 0 {
+			apiError(w, http.StatusNotFound, "no such watch")
+			return
+		}
+
+		type result struct {
+			Watch    string `json:"watch"`
+			Accepted bool   `json:"accepted"` // False if an earlier request is still waiting to be handled
+		}
+		var results []result
+		for _, mon := range monitors {
+			results = append(results, result{Watch: mon.watchFolder, Accepted: mon.send(request)})
+		}
+		apiReply(w, http.StatusAccepted, results)
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// handleHistory returns the backup runs recorded in the catalog of the selected watches.
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	monitors := selectMonitors(r.URL.Query().Get("watch"))
+	if len(monitors) == 0 {
+		apiError(w, http.StatusNotFound, "no such watch")
+		return
+	}
+
+	type history struct {
+		Watch   string          `json:"watch"`
+		Backup  string          `json:"backup"`
+		Backups []catalogBackup `json:"backups"`
+	}
+	var histories []history
+	for _, mon := range monitors {
+		h := history{Watch: mon.watchFolder, Backup: mon.backupFolder, Backups: []catalogBackup{}}
+		db, err := openExistingCatalog(mon.backupFolder)
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if db != nil {
+			backups, err := catalogHistory(db, mon.backupFolder)
+			db.Close()
+			if err != nil {
+				apiError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			h.Backups = append(h.Backups, backups...)
+		}
+		histories = append(histories, h)
+	}
+	apiReply(w, http.StatusOK, histories)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// selectMonitors returns the running monitors watching the given folder, or all of them if watch is empty.
+func selectMonitors(watch string) []*monitor {
+	running.Lock()
+	defer running.Unlock()
+
+	var monitors []*monitor
+	for mon := range running.monitors {
+		if watch == "" || filepath.Clean(watch) == filepath.Clean(mon.watchFolder) {
+			monitors = append(monitors, mon)
+		}
+	}
+	return monitors
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// apiReply writes v as the JSON response.
+func apiReply(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// apiError writes an error response.
+func apiError(w http.ResponseWriter, status int, message string) {
+	apiReply(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const archiveTimeLayout = "20060102_150405" // Timestamp embedded in archive names
+
+// dateFolders is the Go time layout of the subfolders archives are sorted into, e.g. "2006/01/02", set by
+// --date-folders. Empty keeps every archive directly in the backup folder.
+var dateFolders string
+
+// ------------------------------------------------------------------------------------------------------------
+// parseDateFolders returns the layout for a --date-folders value: day, month, year or a Go time layout
+// whose every level starts with a digit, so findArchives can tell date folders from others.
+func parseDateFolders(s string) (string, error) {
+	switch s {
+	case "", "day", "month", "year":
+		return map[string]string{"day": "2006/01/02", "month": "2006/01", "year": "2006"}[s], nil
+	}
+	for _, level := range strings.Split(time.Now().Format(s), "/") {
+		if level == "" || level[0] < '0' || level[0] > '9' {
+			return "", fmt.Errorf("invalid --date-folders layout %q, every folder level must start with a number", s)
+		}
+	}
+	return s, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// datedFolder returns the folder an archive created at t goes into.
+func datedFolder(backupFolder string, t time.Time) string {
+	if dateFolders == "" {
+		return backupFolder
+	}
+	return filepath.Join(backupFolder, filepath.FromSlash(t.Format(dateFolders)))
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// archiveFolder returns the folder an archive created at t goes into, creating it if needed.
+func archiveFolder(backupFolder string, t time.Time) (string, error) {
+	folder := datedFolder(backupFolder, t)
+	return folder, os.MkdirAll(folder, os.ModePerm)
+}
+
+// nameTemplate renders archive file names, set by --name-template.
+var nameTemplate = template.Must(template.New("name").Parse(defaultNameTemplate))
+
+const defaultNameTemplate = "backup_{{.Timestamp}}.zip"
+
+// archiveNameFields are the fields available to --name-template.
+type archiveNameFields struct {
+	Folder    string // Name of the watch folder
+	Timestamp string // Creation time, in archiveTimeLayout
+	Host      string // Host name of the machine
+}
+
+var hostName, _ = os.Hostname()
+
+// ------------------------------------------------------------------------------------------------------------
+// parseNameTemplate parses a --name-template. The names it renders must end in .zip, contain the timestamp,
+// so they are unique and can be dated, and stay within one folder.
+func parseNameTemplate(s string) (*template.Template, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --name-template: %w", err)
+	}
+	fields := archiveNameFields{Folder: "folder", Timestamp: time.Now().Format(archiveTimeLayout), Host: "host"}
+	var sample strings.Builder
+	if err := tmpl.Execute(&sample, fields); err != nil {
+		return nil, fmt.Errorf("invalid --name-template: %w", err)
+	}
+	name := sample.String()
+	switch {
+	case !strings.HasSuffix(name, ".zip"):
+		return nil, fmt.Errorf("invalid --name-template %q: names must end in .zip", s)
+	case !strings.Contains(name, fields.Timestamp):
+		return nil, fmt.Errorf("invalid --name-template %q: names must contain {{.Timestamp}}", s)
+	case strings.ContainsAny(name, `/\`):
+		return nil, fmt.Errorf("invalid --name-template %q: use --date-folders for subfolders", s)
+	}
+	return tmpl, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// archiveStem returns the name of an archive of watchFolder created at t, without the .zip extension. Split
+// archives add a suffix to it and timestamped copies use it as their folder name.
+func archiveStem(watchFolder string, t time.Time) string {
+	fields := archiveNameFields{Folder: filepath.Base(watchFolder), Timestamp: t.Format(archiveTimeLayout), Host: hostName}
+	var name strings.Builder
+	if err := nameTemplate.Execute(&name, fields); err != nil {
+		return "backup_" + fields.Timestamp // Not reached, the template was checked by parseNameTemplate
+	}
+	return strings.TrimSuffix(name.String(), ".zip")
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// archiveName returns the slash-separated path of an archive relative to the backup folder, as recorded in
+// the backup state.
+func archiveName(backupFolder, archivePath string) string {
+	name, err := filepath.Rel(backupFolder, archivePath)
+	if err != nil {
+		return filepath.Base(archivePath)
+	}
+	return filepath.ToSlash(name)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// baseReference turns the name of a base archive, relative to the backup folder, into the reference stored
+// in a manifest, which is relative to the folder of the archive building on it.
+func baseReference(backupFolder, archivePath, base string) string {
+	if base == "" {
+		return ""
+	}
+	ref, err := filepath.Rel(filepath.Dir(archivePath), filepath.Join(backupFolder, filepath.FromSlash(base)))
+	if err != nil {
+		return base
+	}
+	return filepath.ToSlash(ref)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// findArchives returns the paths of all backup archives in the backup folder and its date folders, oldest
+// first. Archives are the zip files whose name embeds a timestamp, whatever --name-template made of it.
+func findArchives(backupFolder string) ([]string, error) {
+	if _, err := os.Stat(backupFolder); err != nil {
+		return nil, err
+	}
+	var archives []string
+	err := filepath.WalkDir(backupFolder, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := entry.Name()
+		if entry.IsDir() {
+			if path != backupFolder && (name[0] < '0' || name[0] > '9') {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if _, ok := nameTime(name); ok && strings.HasSuffix(name, ".zip") {
+			archives = append(archives, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(archives, func(i, j int) bool {
+		ti, tj := archiveTime(archives[i]), archiveTime(archives[j])
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return naturalLess(filepath.Base(archives[i]), filepath.Base(archives[j]))
+	})
+	return archives, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// naturalLess orders names character by character, but runs of digits by their value, so the archives of one
+// second sort as backup_<timestamp>.zip, backup_<timestamp>_2.zip, backup_<timestamp>_10.zip.
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := digitPrefix(a), digitPrefix(b)
+		if da == 0 || db == 0 {
+			if a[0] != b[0] {
+				return a[0] < b[0]
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		na, nb := strings.TrimLeft(a[:da], "0"), strings.TrimLeft(b[:db], "0")
+		if len(na) != len(nb) {
+			return len(na) < len(nb)
+		}
+		if na != nb {
+			return na < nb
+		}
+		a, b = a[da:], b[db:]
+	}
+	return len(a) < len(b)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// digitPrefix returns the number of digits s starts with.
+func digitPrefix(s string) int {
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return n
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// latestArchive returns the path of the newest backup archive in the backup folder.
+func latestArchive(backupFolder string) (string, error) {
+	archives, err := findArchives(backupFolder)
+	if err != nil {
+		return "", err
+	}
+	if len(archives) == 0 {
+		return "", fmt.Errorf("no archives found in %s", backupFolder)
+	}
+	return archives[len(archives)-1], nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// archiveTime returns the creation time of an archive, parsed from its name when possible and falling back
+// to the file modification time.
+func archiveTime(archivePath string) time.Time {
+	if t, ok := nameTime(filepath.Base(archivePath)); ok {
+		return t
+	}
+	if info, err := os.Stat(archivePath); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// nameTime finds the first timestamp in archiveTimeLayout embedded in an archive name.
+func nameTime(name string) (time.Time, bool) {
+	for i := 0; i+len(archiveTimeLayout) <= len(name); i++ {
+		if name[i] < '0' || name[i] > '9' || name[i+8] != '_' {
+			continue
+		}
+		if t, err := time.ParseInLocation(archiveTimeLayout, name[i:i+len(archiveTimeLayout)], time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// createArchive creates the temporary file a new archive at destPath is written to, and returns the final
+// path with it. Names only change once a second, so when an archive of that name already exists or is still
+// being written, the first free name with a _1, _2, ... suffix is taken instead. The temporary file is
+// created exclusively, so runs starting together never share it; publishArchive makes sure the final name
+// is not taken meanwhile.
+func createArchive(destPath string) (string, *os.File, error) {
+	stem := strings.TrimSuffix(destPath, ".zip")
+	for seq := 0; ; seq++ {
+		if seq > 0 {
+			destPath = fmt.Sprintf("%s_%d.zip", stem, seq)
+		}
+		if _, err := os.Lstat(destPath); err == nil {
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return destPath, nil, err
+		}
+		file, err := os.OpenFile(destPath+".tmp", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		return destPath, file, err
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// publishArchive gives a complete archive, written to tmpPath, its final name and returns it. The temporary
+// file is hard-linked to destPath and then removed, which unlike a rename never replaces an archive another
+// run published under that name after it was chosen; if one did, the first free name with a numbered suffix
+// after base, the name asked of createArchive, is taken instead. On file systems without hard links it
+// falls back to renaming.
+func publishArchive(tmpPath, destPath, base string) (string, error) {
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	for seq := 0; ; seq++ {
+		if seq > 0 {
+			destPath = fmt.Sprintf("%s_%d%s", stem, seq, ext)
+		}
+		err := os.Link(tmpPath, destPath)
+		switch {
+		case err == nil:
+			return destPath, os.Remove(tmpPath)
+		case errors.Is(err, fs.ErrExist):
+			continue
+		}
+		if _, statErr := os.Lstat(destPath); statErr == nil {
+			continue
+		}
+		return destPath, os.Rename(tmpPath, destPath)
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// createCopyFolder creates the folder of a timestamped copy at dest, or like createArchive, at the first
+// free name with a numbered suffix, and returns its path.
+func createCopyFolder(dest string) (string, error) {
+	base := dest
+	for seq := 1; ; seq++ {
+		err := os.Mkdir(dest, os.ModePerm)
+		if !errors.Is(err, fs.ErrExist) {
+			return dest, err
+		}
+		dest = fmt.Sprintf("%s_%d", base, seq)
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// archiveExists reports whether the named archive is present in the backup folder.
+func archiveExists(backupFolder, name string) bool {
+	if name == "" {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(backupFolder, name))
+	return err == nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// archiveChain returns the archives needed to reconstruct the folder state captured by archivePath:
+// the full backup it ultimately builds on, followed by every archive up to and including archivePath.
+// Base archives are looked up relative to the archive building on them.
+func archiveChain(archivePath string) ([]string, error) {
+	chain := []string{archivePath}
+	for path := archivePath; ; {
+		reader, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		m, err := readManifest(&reader.Reader)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if m == nil || m.Base == "" {
+			return chain, nil
+		}
+
+		path = filepath.Join(filepath.Dir(path), filepath.FromSlash(m.Base))
+		for _, seen := range chain {
+			if seen == path {
+				return nil, fmt.Errorf("%s: archive chain loops back to %s", archivePath, m.Base)
+			}
+		}
+		chain = append([]string{path}, chain...)
+	}
+}
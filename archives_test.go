@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// TestPublishArchiveKeepsOthers has a run publish its archive under a name another run took after
+// createArchive chose it, and several runs publish under one name at once, and checks that every archive
+// survives under a name of its own.
+func TestPublishArchiveKeepsOthers(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "backup_20250615_020000.zip")
+	destPath, file, err := createArchive(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("late")
+	file.Close()
+	if err := os.WriteFile(base, []byte("early"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	published, err := publishArchive(destPath+".tmp", destPath, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published == base {
+		t.Fatalf("published over %s", base)
+	}
+	if data, _ := os.ReadFile(base); string(data) != "early" {
+		t.Errorf("%s holds %q, want %q", base, data, "early")
+	}
+
+	const runs = 8
+	var wg sync.WaitGroup
+	paths := make([]string, runs)
+	for i := 0; i < runs; i++ {
+		tmpPath := fmt.Sprintf("%s.%d.tmp", base, i)
+		if err := os.WriteFile(tmpPath, []byte(fmt.Sprint(i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path, err := publishArchive(tmpPath, base, base)
+			if err != nil {
+				t.Error(err)
+			}
+			paths[i] = path
+		}(i)
+	}
+	wg.Wait()
+	for i, path := range paths {
+		if data, _ := os.ReadFile(path); string(data) != fmt.Sprint(i) {
+			t.Errorf("%s holds %q, want %q", path, data, fmt.Sprint(i))
+		}
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// TestWriteSnapshotSameSecond writes two snapshots with the same timestamp and checks that both are kept.
+func TestWriteSnapshotSameSecond(t *testing.T) {
+	repo := t.TempDir()
+	created := time.Date(2025, 6, 15, 2, 0, 0, 0, time.Local)
+	first, err := writeSnapshot(repo, &snapshot{Created: created})
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := writeSnapshot(repo, &snapshot{Created: created})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatalf("both snapshots written to %s", first)
+	}
+	for _, path := range []string{first, second} {
+		if _, err := readSnapshot(path); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// TestFindArchivesSuffixOrder checks that archives of the same second sort by their numbered suffix, so the
+// latest one is the last written even past _9.
+func TestFindArchivesSuffixOrder(t *testing.T) {
+	backup := t.TempDir()
+	want := []string{"backup_20250615_020000.zip"}
+	for seq := 1; seq <= 11; seq++ {
+		want = append(want, fmt.Sprintf("backup_20250615_020000_%d.zip", seq))
+	}
+	for _, name := range want {
+		if err := os.WriteFile(filepath.Join(backup, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archives, err := findArchives(backup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, path := range archives {
+		if filepath.Base(path) != want[i] {
+			t.Fatalf("archive %d is %s, want %s", i, filepath.Base(path), want[i])
+		}
+	}
+	if latest, _ := latestArchive(backup); filepath.Base(latest) != want[len(want)-1] {
+		t.Errorf("latest archive is %s, want %s", filepath.Base(latest), want[len(want)-1])
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// blackout lists the windows in which backups are deferred, set by --blackout. A watch in the config file
+// can list its own.
+var blackout []blackoutWindow
+
+// blackoutWindow is a time of day range, on every day or only on some days of the week, in which triggered
+// backups wait until it ends. A range wrapping past midnight belongs to the day it starts on.
+type blackoutWindow struct {
+	hours *dailyWindow
+	days  [7]bool // Indexed by time.Weekday
+}
+
+// blackoutDays maps the day names accepted by --blackout to the days they cover.
+var blackoutDays = map[string][]time.Weekday{
+	"sun":      {time.Sunday},
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// parseBlackout parses a comma-separated list of blackout windows, each a time range optionally followed by
+// the days it applies to: a day, a range of days or weekdays/weekends, e.g. "08:00-18:00 mon-fri,22:00-02:00".
+func parseBlackout(s string) ([]blackoutWindow, error) {
+	var windows []blackoutWindow
+	for _, spec := range strings.Split(s, ",") {
+		fields := strings.Fields(spec)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("invalid blackout window %q, want e.g. 08:00-18:00 mon-fri", spec)
+		}
+		hours, err := parseDailyWindow(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		w := blackoutWindow{hours: hours}
+		if len(fields) == 1 {
+			w.days = [7]bool{true, true, true, true, true, true, true}
+		} else if err := w.setDays(strings.ToLower(fields[1])); err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	if coversWeek(windows) {
+		return nil, fmt.Errorf("windows %q cover the whole week, so no backup could ever run", s)
+	}
+	return windows, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// coversWeek reports whether every minute of the week falls within one of the windows. Windows start and
+// end on whole minutes, so checking each minute of a week without daylight saving changes is enough.
+func coversWeek(windows []blackoutWindow) bool {
+	if len(windows) == 0 {
+		return false
+	}
+	start := time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC) // A Sunday
+	for t := start; t.Before(start.AddDate(0, 0, 7)); t = t.Add(time.Minute) {
+		covered := false
+		for _, w := range windows {
+			if w.contains(t) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// setDays marks the days named by a day, a range such as mon-fri (which may wrap past Sunday), weekdays or
+// weekends.
+func (w *blackoutWindow) setDays(spec string) error {
+	if days, ok := blackoutDays[spec]; ok {
+		for _, day := range days {
+			w.days[day] = true
+		}
+		return nil
+	}
+	from, to, ok := strings.Cut(spec, "-")
+	first, ok1 := blackoutDays[from]
+	last, ok2 := blackoutDays[to]
+	if !ok || !ok1 || !ok2 || len(first) != 1 || len(last) != 1 {
+		return fmt.Errorf("invalid days %q, want e.g. mon-fri, sat, weekdays or weekends", spec)
+	}
+	for day := first[0]; ; day = (day + 1) % 7 {
+		w.days[day] = true
+		if day == last[0] {
+			return nil
+		}
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// contains reports whether t falls within the window.
+func (w blackoutWindow) contains(t time.Time) bool {
+	if !w.hours.contains(t) {
+		return false
+	}
+	day := t.Weekday()
+	if w.hours.from > w.hours.to && sinceMidnight(t) < w.hours.to {
+		day = (day + 6) % 7 // Past midnight in a window that started the day before
+	}
+	return w.days[day]
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// blackoutEnd returns when t leaves the blackout windows it falls within, which may follow each other, or
+// the zero time if it falls within none. Windows covering the whole week are rejected by parseBlackout, but
+// should the end still not be found within 8 days, it gives up there rather than search forever.
+func blackoutEnd(windows []blackoutWindow, t time.Time) time.Time {
+	end := t
+	for changed := true; changed && end.Before(t.AddDate(0, 0, 8)); {
+		changed = false
+		for _, w := range windows {
+			if w.contains(end) {
+				end, changed = nextTimeOfDay(end, w.hours.to), true
+			}
+		}
+	}
+	if end.Equal(t) {
+		return time.Time{}
+	}
+	return end
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// nextTimeOfDay returns the first time after t at the given time of day.
+func nextTimeOfDay(t time.Time, at time.Duration) time.Time {
+	year, month, day := t.Date()
+	next := time.Date(year, month, day, int(at/time.Hour), int(at%time.Hour/time.Minute), 0, 0, t.Location())
+	if !next.After(t) {
+		next = time.Date(year, month, day+1, int(at/time.Hour), int(at%time.Hour/time.Minute), 0, 0, t.Location())
+	}
+	return next
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+const browseTimeLayout = "2006-01-02_150405" // Names of the backup folders served by "foldermon browse"
+
+// ------------------------------------------------------------------------------------------------------------
+// runBrowse implements "foldermon browse <backupFolder> [--listen 127.0.0.1:8091] [--api-token <token>]",
+// serving the backup history as a read-only WebDAV share until interrupted. Every backup is a folder named
+// after its time, holding the complete folder state it restores to, so old versions can be browsed and
+// copied with normal file tools once the share is mounted. Files are extracted when opened. Without a
+// token, the share is only served on a loopback address.
+func runBrowse(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("browse", flag.ExitOnError)
+	listen := fs.String("listen", "127.0.0.1:8091", "address to serve the WebDAV share on")
+	token := fs.String("api-token", "", "require this token as the password, or as a bearer token (or set "+apiTokenEnv+")")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("usage: %s browse <backupFolder> [--listen 127.0.0.1:8091] [--api-token <token>]", os.Args[0])
+	}
+	if _, err := os.Stat(positional[0]); err != nil {
+		return err
+	}
+	if *token == "" {
+		*token = secret("api-token")
+	}
+	addr, err := net.ResolveTCPAddr("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	loopback := addr.IP != nil && addr.IP.IsLoopback()
+	if !loopback && *token == "" {
+		return usageError("--listen %s is reachable from other machines, so the share needs --api-token or %s", *listen, apiTokenEnv)
+	}
+
+	listener, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	history := &historyFS{backupFolder: positional[0], trees: make(map[string]*historyTree)}
+	dav := &webdav.Handler{FileSystem: history, LockSystem: webdav.NewMemLS()}
+	server := &http.Server{Handler: browseAccess(*token, loopback, readOnly(dav)), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	fmt.Printf("Serving the backups in %s read-only at http://%s/\n", positional[0], listener.Addr())
+	fmt.Println("Mount it with a WebDAV client, e.g. Finder's Connect to Server, Explorer's Map Network Drive or mount -t davfs, and press Ctrl+C to stop")
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// browseAccess lets only authorized requests through to the share: with a token, those carrying it as the
+// password of Basic authentication, which WebDAV clients support, or as a bearer token. On a loopback
+// address, the Host header must name the loopback too, so web pages cannot reach the share through DNS
+// rebinding.
+func browseAccess(token string, loopback bool, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if loopback && !loopbackHost(r.Host) {
+			http.Error(w, "the share is only served to localhost", http.StatusForbidden)
+			return
+		}
+		if token != "" {
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				_, given, ok = r.BasicAuth()
+			}
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="foldermon"`)
+				http.Error(w, "missing or wrong token", http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// loopbackHost reports whether the host of a Host header, with or without a port, is localhost or a loopback
+// address.
+func loopbackHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.Trim(hostport, "[]")
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// readOnly rejects the WebDAV methods that would change the share.
+func readOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND", "LOCK", "UNLOCK":
+			h.ServeHTTP(w, r)
+		default:
+			http.Error(w, "the backup history is read-only", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// historyFS is the read-only WebDAV file system of "foldermon browse". The backups are listed again
+// whenever the root is read, so new ones appear while it runs; their trees are built once, when first
+// opened, as archives never change.
+type historyFS struct {
+	backupFolder string
+
+	mu      sync.Mutex
+	backups map[string]string       // Folder names to the archive or snapshot they show
+	created map[string]time.Time    // Folder names to the time of their backup
+	trees   map[string]*historyTree // Archive or snapshot paths to their trees
+}
+
+// historyTree is the folder state a backup restores to.
+type historyTree struct {
+	files map[string]historyFile // Slash-separated paths to the files
+	dirs  map[string][]string    // Slash-separated folder paths, "" for the top, to the names in them
+}
+
+// historyFile is a file in a historyTree and where to extract it from.
+type historyFile struct {
+	archive  string        // Archive holding it, or snapshot listing its chunks
+	entry    string        // Name of its archive entry
+	snapshot *snapshotFile // Its snapshot record, for dedup snapshots
+	size     int64
+	modTime  time.Time
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// listBackups names a folder for every archive and snapshot in the backup folder, after its time.
+// Backups written in the same second, such as --split archives, are told apart by a counter.
+func (h *historyFS) listBackups() error {
+	archives, err := findArchives(h.backupFolder)
+	if err != nil {
+		return err
+	}
+	if isRepository(h.backupFolder) {
+		snapshots, err := findSnapshots(h.backupFolder)
+		if err != nil {
+			return err
+		}
+		archives = append(archives, snapshots...)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.backups, h.created = make(map[string]string), make(map[string]time.Time)
+	for _, archivePath := range archives {
+		created := archiveTime(archivePath)
+		name := created.Format(browseTimeLayout)
+		for i := 2; h.backups[name] != ""; i++ {
+			name = fmt.Sprintf("%s_%d", created.Format(browseTimeLayout), i)
+		}
+		h.backups[name], h.created[name] = archivePath, created
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// tree returns the tree of the backup shown as the named folder, building it on first use.
+func (h *historyFS) tree(name string) (*historyTree, time.Time, error) {
+	h.mu.Lock()
+	archivePath, ok := h.backups[name]
+	created := h.created[name]
+	tree := h.trees[archivePath]
+	h.mu.Unlock()
+	if !ok {
+		return nil, time.Time{}, os.ErrNotExist
+	}
+	if tree != nil {
+		return tree, created, nil
+	}
+
+	tree, err := buildHistoryTree(archivePath)
+	if err != nil {
+		log.Printf("Cannot read %s: %v\n", archivePath, err)
+		return nil, time.Time{}, err
+	}
+	h.mu.Lock()
+	h.trees[archivePath] = tree
+	h.mu.Unlock()
+	return tree, created, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// buildHistoryTree lists the files a backup restores, following the chain of incremental and differential
+// archives and leaving out the files they record as deleted. Symbolic links are left out too.
+func buildHistoryTree(archivePath string) (*historyTree, error) {
+	files := make(map[string]historyFile)
+	if isSnapshot(archivePath) {
+		snap, err := readSnapshot(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		for i := range snap.Files {
+			if file := &snap.Files[i]; file.Link == "" {
+				files[file.Path] = historyFile{archive: archivePath, snapshot: file, size: file.Size, modTime: file.ModTime}
+			}
+		}
+		return newHistoryTree(files), nil
+	}
+
+	chain, err := archiveChain(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range chain {
+		reader, err := zip.OpenReader(link)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range reader.File {
+			if file.FileInfo().IsDir() || file.Mode()&os.ModeSymlink != 0 || file.Name == manifestName {
+				continue
+			}
+			files[file.Name] = historyFile{archive: link, entry: file.Name, size: int64(file.UncompressedSize64), modTime: file.Modified}
+		}
+		m, err := readManifest(&reader.Reader)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", link, err)
+		}
+		if m != nil {
+			for _, t := range m.Deleted {
+				delete(files, t.Path)
+			}
+		}
+	}
+	return newHistoryTree(files), nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newHistoryTree indexes the folders of a set of files.
+func newHistoryTree(files map[string]historyFile) *historyTree {
+	tree := &historyTree{files: files, dirs: map[string][]string{"": nil}}
+	for name := range files {
+		for child := name; child != "."; {
+			parent := path.Dir(child)
+			if parent == "." {
+				parent = ""
+			}
+			_, known := tree.dirs[parent]
+			tree.dirs[parent] = append(tree.dirs[parent], path.Base(child))
+			if known {
+				break
+			}
+			child = path.Dir(child)
+		}
+	}
+	for _, names := range tree.dirs {
+		sort.Strings(names)
+	}
+	return tree
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// resolve splits a share path into the backup folder name and the path within the backup.
+func resolve(name string) (backup, rel string) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	backup, rel, _ = strings.Cut(name, "/")
+	return backup, rel
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// Stat describes a backup folder, or a file or folder in one.
+func (h *historyFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	backup, rel := resolve(name)
+	if backup == "" {
+		return historyInfo{name: "/", dir: true, modTime: time.Now()}, h.listBackups()
+	}
+	tree, created, err := h.tree(backup)
+	if errors.Is(err, os.ErrNotExist) {
+		// A backup written since the root was last listed
+		if err := h.listBackups(); err != nil {
+			return nil, err
+		}
+		tree, created, err = h.tree(backup)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rel == "" {
+		return historyInfo{name: backup, dir: true, modTime: created}, nil
+	}
+	if file, ok := tree.files[rel]; ok {
+		return historyInfo{name: path.Base(rel), size: file.size, modTime: file.modTime}, nil
+	}
+	if _, ok := tree.dirs[rel]; ok {
+		return historyInfo{name: path.Base(rel), dir: true, modTime: created}, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// OpenFile opens a folder for listing, or extracts a file to a temporary file removed again on Close.
+func (h *historyFS) OpenFile(ctx context.Context, name string, flags int, perm os.FileMode) (webdav.File, error) {
+	if flags&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, os.ErrPermission
+	}
+	info, err := h.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	backup, rel := resolve(name)
+	if !info.IsDir() {
+		tree, _, err := h.tree(backup)
+		if err != nil {
+			return nil, err
+		}
+		return openHistoryFile(ctx, tree.files[rel], info.Name())
+	}
+
+	var entries []os.FileInfo
+	if backup == "" {
+		h.mu.Lock()
+		for folder, created := range h.created {
+			entries = append(entries, historyInfo{name: folder, dir: true, modTime: created})
+		}
+		h.mu.Unlock()
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	} else {
+		tree, _, err := h.tree(backup)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range tree.dirs[rel] {
+			if entry, err := h.Stat(ctx, path.Join(backup, rel, child)); err == nil {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return &historyDir{info: info, entries: entries}, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// Mkdir, RemoveAll and Rename fail, as the backup history is read-only.
+func (h *historyFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+func (h *historyFS) RemoveAll(ctx context.Context, name string) error { return os.ErrPermission }
+func (h *historyFS) Rename(ctx context.Context, oldName, newName string) error {
+	return os.ErrPermission
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// openHistoryFile extracts a file of a backup under its own name into a temporary folder and opens it.
+func openHistoryFile(ctx context.Context, file historyFile, name string) (webdav.File, error) {
+	dir, err := os.MkdirTemp("", "foldermon-browse-")
+	if err != nil {
+		return nil, err
+	}
+	target := filepath.Join(dir, name)
+	if file.snapshot != nil {
+		err = extractSnapshotFile(ctx, filepath.Dir(filepath.Dir(file.archive)), *file.snapshot, target)
+	} else {
+		err = extractEntry(ctx, file.archive, file.entry, target)
+	}
+	var f *os.File
+	if err == nil {
+		f, err = os.Open(target)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &historyOpenFile{File: f, dir: dir}, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// extractEntry writes the named entry of an archive to target.
+func extractEntry(ctx context.Context, archivePath, name, target string) error {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		if file.Name == name {
+			return extractFile(ctx, file, target)
+		}
+	}
+	return fmt.Errorf("%s not found in %s", name, archivePath)
+}
+
+// historyOpenFile is an extracted file being served.
+type historyOpenFile struct {
+	*os.File
+	dir string // Temporary folder it was extracted to
+}
+
+func (f *historyOpenFile) Write(p []byte) (int, error) { return 0, os.ErrPermission }
+
+func (f *historyOpenFile) Close() error {
+	err := f.File.Close()
+	os.RemoveAll(f.dir)
+	return err
+}
+
+// historyDir is a folder of the share opened for listing.
+type historyDir struct {
+	info    os.FileInfo
+	entries []os.FileInfo
+	pos     int
+}
+
+func (d *historyDir) Close() error                                 { return nil }
+func (d *historyDir) Read(p []byte) (int, error)                   { return 0, os.ErrInvalid }
+func (d *historyDir) Seek(offset int64, whence int) (int64, error) { return 0, os.ErrInvalid }
+func (d *historyDir) Write(p []byte) (int, error)                  { return 0, os.ErrPermission }
+func (d *historyDir) Stat() (os.FileInfo, error)                   { return d.info, nil }
+
+func (d *historyDir) Readdir(count int) ([]os.FileInfo, error) {
+	rest := d.entries[d.pos:]
+	if count <= 0 {
+		d.pos = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	rest = rest[:min(count, len(rest))]
+	d.pos += len(rest)
+	return rest, nil
+}
+
+// historyInfo describes a file or folder of the share.
+type historyInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i historyInfo) Name() string       { return i.name }
+func (i historyInfo) Size() int64        { return i.size }
+func (i historyInfo) ModTime() time.Time { return i.modTime }
+func (i historyInfo) IsDir() bool        { return i.dir }
+func (i historyInfo) Sys() any           { return nil }
+
+func (i historyInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// ContentType guesses the type of a file from its extension, so listing a folder does not extract every
+// file in it to sniff its contents.
+func (i historyInfo) ContentType(ctx context.Context) (string, error) {
+	if t := mime.TypeByExtension(path.Ext(i.name)); t != "" {
+		return t, nil
+	}
+	return "application/octet-stream", nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"io"
+	"sync"
+)
+
+// copyBufferSize is the size of the buffers file data is streamed through, set by --buffer-size. Files are
+// never read into memory whole, so each copy in progress holds one buffer.
+var copyBufferSize = 256 << 10
+
+// copyBuffers recycles the buffers of copyData.
+var copyBuffers = sync.Pool{New: func() any {
+	buf := make([]byte, copyBufferSize)
+	return &buf
+}}
+
+// ------------------------------------------------------------------------------------------------------------
+// copyData copies src to dst through a buffer of copyBufferSize, like io.CopyBuffer. ReadFrom and WriteTo,
+// which *os.File implements, are hidden, as io.CopyBuffer would use them instead of the buffer.
+func copyData(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(buf)
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
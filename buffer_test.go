@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// chunkRecorder records the largest write it is given and fails the test if io.Copy goes around the buffer
+// through ReadFrom, as it does for *os.File.
+type chunkRecorder struct {
+	t       *testing.T
+	largest int
+}
+
+func (w *chunkRecorder) Write(p []byte) (int, error) {
+	w.largest = max(w.largest, len(p))
+	return len(p), nil
+}
+
+func (w *chunkRecorder) ReadFrom(r io.Reader) (int64, error) {
+	w.t.Error("copyData used ReadFrom instead of its buffer")
+	return io.Copy(struct{ io.Writer }{w}, r)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// TestCopyDataBufferSize checks that files are copied in chunks of --buffer-size, also when the source and
+// destination are files.
+func TestCopyDataBufferSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, make([]byte, 3*copyBufferSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	dst := &chunkRecorder{t: t}
+	n, err := copyData(dst, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(3*copyBufferSize) || dst.largest != copyBufferSize {
+		t.Errorf("copied %d bytes in chunks of up to %d, want %d in chunks of %d", n, dst.largest, 3*copyBufferSize, copyBufferSize)
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// TestBackupMemoryCeiling archives a 2 GiB file, sparse so it takes no disk space, and checks that the heap
+// stays below the 30 MiB the README promises.
+func TestBackupMemoryCeiling(t *testing.T) {
+	if testing.Short() {
+		t.Skip("archives 2 GiB")
+	}
+	const ceiling = 30 << 20
+	watch, backup := t.TempDir(), t.TempDir()
+	file, err := os.Create(filepath.Join(watch, "large.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Truncate(2 << 30); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	baseline := stats.HeapAlloc
+
+	var peak atomic.Uint64
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc > peak.Load() {
+				peak.Store(stats.HeapAlloc)
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	archive, err := zipAndMove(context.Background(), watch, backup, nil)
+	close(done)
+	<-sampled
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive == "" {
+		t.Fatal("no archive written")
+	}
+	if grown := int64(peak.Load()) - int64(baseline); grown > ceiling {
+		t.Errorf("heap grew by %d MiB while archiving 2 GiB, want under %d MiB", grown>>20, ceiling>>20)
+	}
+}
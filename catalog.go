@@ -0,0 +1,270 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const catalogFileName = "foldermon.db" // Default catalog location inside the backup folder
+
+// catalogFile overrides the catalog location, set by --catalog.
+var catalogFile string
+
+// catalogSchema creates the catalog tables. Every backup run gets a row in backups, every file stored by a
+// successful backup a row in files, and every file it records as deleted a row in deletions. File events
+// seen by the monitor go to events, pointing at the backup that captured them once there is one.
+const catalogSchema = `
+CREATE TABLE IF NOT EXISTS backups (
+	id          INTEGER PRIMARY KEY,
+	archive     TEXT NOT NULL,
+	destination TEXT NOT NULL,
+	created     TIMESTAMP NOT NULL,
+	type        TEXT NOT NULL,
+	size        INTEGER NOT NULL,
+	status      TEXT NOT NULL,
+	error       TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS files (
+	backup_id INTEGER NOT NULL REFERENCES backups(id) ON DELETE CASCADE,
+	path      TEXT NOT NULL,
+	size      INTEGER NOT NULL,
+	mtime     TIMESTAMP NOT NULL,
+	sha256    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS files_backup ON files(backup_id);
+CREATE INDEX IF NOT EXISTS files_path ON files(path);
+CREATE TABLE IF NOT EXISTS deletions (
+	backup_id INTEGER NOT NULL REFERENCES backups(id) ON DELETE CASCADE,
+	path      TEXT NOT NULL,
+	deleted   TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS deletions_path ON deletions(path);
+CREATE TABLE IF NOT EXISTS events (
+	id          INTEGER PRIMARY KEY,
+	destination TEXT NOT NULL,
+	watch       TEXT NOT NULL,
+	time        TIMESTAMP NOT NULL,
+	op          TEXT NOT NULL,
+	path        TEXT NOT NULL,
+	backup_id   INTEGER REFERENCES backups(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS events_destination ON events(destination, time);
+`
+
+// catalogBackup is a row of the backups table.
+type catalogBackup struct {
+	ID          int64     `json:"id"`
+	Archive     string    `json:"archive"`
+	Destination string    `json:"destination"`
+	Created     time.Time `json:"created"`
+	Type        string    `json:"type"`
+	Size        int64     `json:"size"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	Files       int       `json:"files"`
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// catalogPath returns the location of the catalog for a backup folder.
+func catalogPath(backupFolder string) string {
+	if catalogFile != "" {
+		return catalogFile
+	}
+	return filepath.Join(backupFolder, catalogFileName)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// catalogDestination returns the form of a backup folder path stored in the catalog, so the same folder
+// matches regardless of how it was spelled on the command line.
+func catalogDestination(backupFolder string) string {
+	if abs, err := filepath.Abs(backupFolder); err == nil {
+		return abs
+	}
+	return filepath.Clean(backupFolder)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// openCatalog opens the catalog of a backup folder, creating it if needed.
+func openCatalog(backupFolder string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", catalogPath(backupFolder)+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(catalogSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// openExistingCatalog opens the catalog of a backup folder for reading. It returns nil without error if no
+// catalog has been created yet, so callers can fall back to scanning archives.
+func openExistingCatalog(backupFolder string) (*sql.DB, error) {
+	if _, err := os.Stat(catalogPath(backupFolder)); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return openCatalog(backupFolder)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// catalogArchive records a successfully written archive or snapshot, the files it contains and the
+// deletions it records, and assigns it the file events recorded before it started.
+func catalogArchive(backupFolder, archivePath string, m *manifest) error {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return err
+	}
+
+	db, err := openCatalog(backupFolder)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO backups (archive, destination, created, type, size, status, error)
+		VALUES (?, ?, ?, ?, ?, 'success', '')`,
+		filepath.Base(archivePath), catalogDestination(backupFolder), m.Created, m.Type, info.Size())
+	if err != nil {
+		return err
+	}
+	backupID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	insert, err := tx.Prepare(`INSERT INTO files (backup_id, path, size, mtime, sha256) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, file := range m.Files {
+		if _, err := insert.Exec(backupID, file.Path, file.Size, file.ModTime, file.SHA256); err != nil {
+			return err
+		}
+	}
+	for _, t := range m.Deleted {
+		if _, err := tx.Exec(`INSERT INTO deletions (backup_id, path, deleted) VALUES (?, ?, ?)`, backupID, t.Path, t.Time); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE events SET backup_id = ? WHERE backup_id IS NULL AND destination = ? AND time <= ?`,
+		backupID, catalogDestination(backupFolder), m.Created); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// catalogFailure records a backup run that did not produce an archive.
+func catalogFailure(backupFolder, status string, runErr error) error {
+	db, err := openCatalog(backupFolder)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`INSERT INTO backups (archive, destination, created, type, size, status, error)
+		VALUES ('', ?, ?, '', 0, ?, ?)`, catalogDestination(backupFolder), time.Now(), status, runErr.Error())
+	return err
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// catalogBackups returns the successful backups recorded for a backup folder, oldest first, with their
+// file counts.
+func catalogBackups(db *sql.DB, backupFolder string) ([]catalogBackup, error) {
+	return queryBackups(db, `b.status = 'success' AND b.destination = ? ORDER BY b.created, b.id`, catalogDestination(backupFolder))
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// catalogHistory returns every backup run recorded for a backup folder, failed ones included, newest first.
+func catalogHistory(db *sql.DB, backupFolder string) ([]catalogBackup, error) {
+	return queryBackups(db, `b.destination = ? ORDER BY b.created DESC, b.id DESC`, catalogDestination(backupFolder))
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// queryBackups returns the rows of the backups table matching the where clause, with their file counts.
+func queryBackups(db *sql.DB, where string, args ...any) ([]catalogBackup, error) {
+	rows, err := db.Query(`SELECT b.id, b.archive, b.destination, b.created, b.type, b.size, b.status, b.error,
+			(SELECT COUNT(*) FROM files f WHERE f.backup_id = b.id)
+		FROM backups b WHERE `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var backups []catalogBackup
+	for rows.Next() {
+		var b catalogBackup
+		if err := rows.Scan(&b.ID, &b.Archive, &b.Destination, &b.Created, &b.Type, &b.Size, &b.Status, &b.Error, &b.Files); err != nil {
+			return nil, err
+		}
+		backups = append(backups, b)
+	}
+	return backups, rows.Err()
+}
+
+// catalogEntry is a row of the files table.
+type catalogEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modified"`
+	SHA256  string    `json:"sha256"`
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// catalogFiles returns the files recorded for a backup, ordered by path.
+func catalogFiles(db *sql.DB, backupID int64) ([]catalogEntry, error) {
+	rows, err := db.Query(`SELECT path, size, mtime, sha256 FROM files WHERE backup_id = ? ORDER BY path`, backupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []catalogEntry
+	for rows.Next() {
+		var f catalogEntry
+		if err := rows.Scan(&f.Path, &f.Size, &f.ModTime, &f.SHA256); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// catalogDeletion is a row of the deletions table.
+type catalogDeletion struct {
+	Path    string    `json:"path"`
+	Deleted time.Time `json:"deleted"`
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// catalogDeletions returns the deletions recorded by a backup, ordered by path.
+func catalogDeletions(db *sql.DB, backupID int64) ([]catalogDeletion, error) {
+	rows, err := db.Query(`SELECT path, deleted FROM deletions WHERE backup_id = ? ORDER BY path`, backupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deletions []catalogDeletion
+	for rows.Next() {
+		var d catalogDeletion
+		if err := rows.Scan(&d.Path, &d.Deleted); err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d)
+	}
+	return deletions, rows.Err()
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const catalogDumpVersion = 1 // Format version of catalog exports, raised when a change breaks older imports
+
+// catalogDump is the portable form of the catalog records of a backup folder, written by "foldermon catalog
+// export". Backup IDs are only meaningful within the dump; import assigns new ones.
+type catalogDump struct {
+	Version     int                 `json:"version"`
+	Destination string              `json:"destination"` // Backup folder the records were exported from
+	Exported    time.Time           `json:"exported"`
+	Backups     []catalogDumpBackup `json:"backups"` // Oldest first, failed runs included
+	Events      []catalogDumpEvent  `json:"events"`
+}
+
+// catalogDumpBackup is a backup run in a catalog export, with the files it stored and the deletions it
+// recorded.
+type catalogDumpBackup struct {
+	catalogBackup
+	Contents  []catalogEntry    `json:"contents"`
+	Deletions []catalogDeletion `json:"deletions"`
+}
+
+// catalogDumpEvent is a file event in a catalog export.
+type catalogDumpEvent struct {
+	Time     time.Time `json:"time"`
+	Watch    string    `json:"watch"`
+	Op       string    `json:"op"`
+	Path     string    `json:"path"`
+	BackupID *int64    `json:"backup_id"` // ID of the backup that captured it, null until there was one
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// runCatalog implements "foldermon catalog export|import|rebuild", so the backup history survives the loss
+// of the host or its catalog. Export writes the catalog records of a backup folder as JSON; import loads
+// such a file into the catalog of a backup folder, wherever it is now; rebuild recreates the catalog from
+// the archives and snapshots in the backup folder, without the file events only the catalog had.
+func runCatalog(ctx context.Context, args []string) error {
+	usage := usageError("usage: %s catalog export <backupFolder> [--out file], %s catalog import <backupFolder> <file> [--force], or %s catalog rebuild <backupFolder> [--force]", os.Args[0], os.Args[0], os.Args[0])
+	if len(args) == 0 {
+		return usage
+	}
+
+	fs := flag.NewFlagSet("catalog "+args[0], flag.ExitOnError)
+	fs.StringVar(&catalogFile, "catalog", "", "path of the SQLite backup catalog (default <backupFolder>/"+catalogFileName+")")
+	out := fs.String("out", "-", "file to export to, - for standard output")
+	force := fs.Bool("force", false, "replace the records of the backup folder already in the catalog")
+	positional, err := parseArgs(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "export" && len(positional) == 1:
+		return exportCatalog(positional[0], *out)
+	case args[0] == "import" && len(positional) == 2:
+		return importCatalog(positional[0], positional[1], *force)
+	case args[0] == "rebuild" && len(positional) == 1:
+		return rebuildCatalog(ctx, positional[0], *force)
+	default:
+		return usage
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// exportCatalog writes the catalog records of a backup folder to file as a catalogDump.
+func exportCatalog(backupFolder, file string) error {
+	db, err := openExistingCatalog(backupFolder)
+	if err != nil {
+		return err
+	}
+	if db == nil {
+		return fmt.Errorf("no catalog found at %s, recreate it with \"%s catalog rebuild %s\"", catalogPath(backupFolder), os.Args[0], backupFolder)
+	}
+	defer db.Close()
+
+	backups, err := queryBackups(db, `b.destination = ? ORDER BY b.created, b.id`, catalogDestination(backupFolder))
+	if err != nil {
+		return err
+	}
+	dump := catalogDump{Version: catalogDumpVersion, Destination: catalogDestination(backupFolder), Exported: time.Now(), Backups: []catalogDumpBackup{}}
+	for _, b := range backups {
+		entry := catalogDumpBackup{catalogBackup: b}
+		if entry.Contents, err = catalogFiles(db, b.ID); err != nil {
+			return err
+		}
+		if entry.Deletions, err = catalogDeletions(db, b.ID); err != nil {
+			return err
+		}
+		dump.Backups = append(dump.Backups, entry)
+	}
+	if dump.Events, err = catalogEvents(db, backupFolder); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if file != "-" {
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(dump); err != nil {
+		return err
+	}
+	if file != "-" {
+		fmt.Printf("Exported %d backups and %d file events to %s\n", len(dump.Backups), len(dump.Events), file)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// catalogEvents returns the file events recorded for a backup folder, oldest first.
+func catalogEvents(db *sql.DB, backupFolder string) ([]catalogDumpEvent, error) {
+	rows, err := db.Query(`SELECT time, watch, op, path, backup_id FROM events WHERE destination = ? ORDER BY time, id`,
+		catalogDestination(backupFolder))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []catalogDumpEvent{}
+	for rows.Next() {
+		var e catalogDumpEvent
+		var backupID sql.NullInt64
+		if err := rows.Scan(&e.Time, &e.Watch, &e.Op, &e.Path, &backupID); err != nil {
+			return nil, err
+		}
+		if backupID.Valid {
+			e.BackupID = &backupID.Int64
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// importCatalog loads a catalogDump into the catalog of a backup folder. The records are filed under the
+// backup folder as given, so a dump taken on another machine or before the folder moved still matches it.
+func importCatalog(backupFolder, file string, force bool) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var dump catalogDump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	if dump.Version < 1 || dump.Version > catalogDumpVersion {
+		return fmt.Errorf("%s: unsupported catalog export version %d", file, dump.Version)
+	}
+
+	db, err := openCatalog(backupFolder)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := clearCatalog(tx, backupFolder, force); err != nil {
+		return err
+	}
+
+	destination := catalogDestination(backupFolder)
+	ids := make(map[int64]int64) // Backup IDs in the dump to the IDs assigned here
+	for _, b := range dump.Backups {
+		result, err := tx.Exec(`INSERT INTO backups (archive, destination, created, type, size, status, error)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`, b.Archive, destination, b.Created, b.Type, b.Size, b.Status, b.Error)
+		if err != nil {
+			return err
+		}
+		if ids[b.ID], err = result.LastInsertId(); err != nil {
+			return err
+		}
+		for _, f := range b.Contents {
+			if _, err := tx.Exec(`INSERT INTO files (backup_id, path, size, mtime, sha256) VALUES (?, ?, ?, ?, ?)`,
+				ids[b.ID], f.Path, f.Size, f.ModTime, f.SHA256); err != nil {
+				return err
+			}
+		}
+		for _, d := range b.Deletions {
+			if _, err := tx.Exec(`INSERT INTO deletions (backup_id, path, deleted) VALUES (?, ?, ?)`, ids[b.ID], d.Path, d.Deleted); err != nil {
+				return err
+			}
+		}
+	}
+	for _, e := range dump.Events {
+		var backupID sql.NullInt64
+		if e.BackupID != nil {
+			backupID.Int64, backupID.Valid = ids[*e.BackupID]
+		}
+		if _, err := tx.Exec(`INSERT INTO events (destination, watch, time, op, path, backup_id) VALUES (?, ?, ?, ?, ?, ?)`,
+			destination, e.Watch, e.Time, e.Op, e.Path, backupID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d backups and %d file events into %s\n", len(dump.Backups), len(dump.Events), catalogPath(backupFolder))
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// clearCatalog makes room for the records of a backup folder about to be imported or rebuilt. Existing
+// records are only removed with force.
+func clearCatalog(tx *sql.Tx, backupFolder string, force bool) error {
+	destination := catalogDestination(backupFolder)
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM backups WHERE destination = ?`, destination).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 && !force {
+		return fmt.Errorf("the catalog already has %d backups of %s, use --force to replace them", count, backupFolder)
+	}
+	if _, err := tx.Exec(`DELETE FROM events WHERE destination = ?`, destination); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM backups WHERE destination = ?`, destination)
+	return err
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// rebuildCatalog recreates the catalog records of a backup folder from its archives, and its snapshots if
+// it is a dedup repository, reading their manifests. Archives written without a manifest are recorded as
+// full backups identified by zip CRCs. Unreadable archives are reported and left out.
+func rebuildCatalog(ctx context.Context, backupFolder string, force bool) error {
+	db, err := openCatalog(backupFolder)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := clearCatalog(tx, backupFolder, force); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	archives, err := findArchives(backupFolder)
+	if err != nil {
+		return err
+	}
+	if isRepository(backupFolder) {
+		snapshots, err := findSnapshots(backupFolder)
+		if err != nil {
+			return err
+		}
+		archives = append(archives, snapshots...)
+	}
+
+	rebuilt := 0
+	for _, archivePath := range archives {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		m, err := backupManifest(archivePath)
+		if err == nil {
+			err = catalogArchive(backupFolder, archivePath, m)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", archivePath, err)
+			continue
+		}
+		rebuilt++
+	}
+	fmt.Printf("Recorded %d of %d backups in %s\n", rebuilt, len(archives), catalogPath(backupFolder))
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// backupManifest returns the manifest of an archive or snapshot, making one up from the zip directory for
+// archives written without a manifest.
+func backupManifest(path string) (*manifest, error) {
+	if isSnapshot(path) {
+		snap, err := readSnapshot(path)
+		if err != nil {
+			return nil, err
+		}
+		m := &manifest{Created: snap.Created, Type: archiveFull, Skipped: snap.Skipped}
+		for _, file := range snap.Files {
+			m.Files = append(m.Files, file.manifestEntry)
+		}
+		return m, nil
+	}
+
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	m, err := readManifest(&reader.Reader)
+	if err != nil || m != nil {
+		return m, err
+	}
+	m = &manifest{Created: archiveTime(path), Type: archiveFull}
+	for _, file := range reader.File {
+		if !file.FileInfo().IsDir() {
+			m.Files = append(m.Files, manifestEntry{Path: file.Name, Size: int64(file.UncompressedSize64), ModTime: file.Modified,
+				SHA256: fmt.Sprintf("crc32:%08x", file.CRC32)})
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// defaultChatTemplate is the message posted for a backup outcome unless --chat-template sets another.
+const defaultChatTemplate = "{{.Title}} on {{.Host}}: {{.Summary}}"
+
+// chatNotice is what --chat-template is executed with.
+type chatNotice struct {
+	Event   string
+	Title   string
+	Summary string
+	Host    string
+	Watch   string
+	Failed  bool
+	Attrs   map[string]string
+}
+
+// chatHandler is the slog sink posting backup outcomes to a chat webhook.
+type chatHandler struct {
+	*webhook
+	all   bool // Also post successful backups and reports
+	attrs []slog.Attr
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newChatHandler returns the sink for --chat-url. The message format is chosen by the URL: Discord and
+// Microsoft Teams have their own, anything else gets Slack's, which most chat servers accept.
+func newChatHandler(rawURL, on, text string) (*chatHandler, error) {
+	if on != notifyFailures && on != notifyAll {
+		return nil, fmt.Errorf("unknown --chat-on value %q (want %s or %s)", on, notifyFailures, notifyAll)
+	}
+	tmpl, err := template.New("chat").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("--chat-template: %v", err)
+	}
+	hook, err := newWebhook(rawURL, "")
+	if err != nil {
+		return nil, fmt.Errorf("--chat-url: %v", err)
+	}
+	u, _ := url.Parse(rawURL)
+	host := strings.ToLower(u.Hostname())
+	discord := host == "discord.com" || host == "discordapp.com" || strings.HasSuffix(host, ".discord.com")
+	teams := strings.HasSuffix(host, ".webhook.office.com") || strings.HasSuffix(host, ".logic.azure.com")
+
+	hook.encode = func(n backupNotice) (any, error) {
+		title, summary := n.summary()
+		hostname, _ := os.Hostname()
+		var b strings.Builder
+		err := tmpl.Execute(&b, chatNotice{
+			Event:   n.Event,
+			Title:   title,
+			Summary: summary,
+			Host:    hostname,
+			Watch:   n.watch(),
+			Failed:  n.failed(),
+			Attrs:   n.Attrs,
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case discord:
+			return map[string]string{"content": b.String()}, nil
+		case teams:
+			color := "2EB886"
+			if n.failed() {
+				color = "D70000"
+			}
+			return map[string]string{
+				"@type":      "MessageCard",
+				"@context":   "https://schema.org/extensions",
+				"summary":    title,
+				"themeColor": color,
+				"text":       b.String(),
+			}, nil
+		default:
+			return map[string]string{"text": b.String()}, nil
+		}
+	}
+	return &chatHandler{webhook: hook, all: on == notifyAll}, nil
+}
+
+func (h *chatHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (h *chatHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *chatHandler) WithGroup(name string) slog.Handler { return h }
+
+// ------------------------------------------------------------------------------------------------------------
+// Handle queues backup outcomes for posting, only failures unless --chat-on all.
+func (h *chatHandler) Handle(_ context.Context, r slog.Record) error {
+	n, ok := noticeFromRecord(r, h.attrs)
+	if !ok || !n.outcome() || (!h.all && !n.failed()) {
+		return nil
+	}
+	h.enqueue(n)
+	return nil
+}
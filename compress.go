@@ -0,0 +1,168 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/sha256"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// compressWorkers is how many files are compressed at once, set by --workers.
+var compressWorkers = 1
+
+// parallelMaxSize is the largest file compressed on a worker. Workers hold the compressed data in memory
+// until it is written, so larger files are compressed by the archive writer itself.
+const parallelMaxSize = 32 << 20
+
+// zipDeflateLevel is the level archive/zip deflates with, so workers produce the same archives.
+const zipDeflateLevel = 5
+
+// compressJob is a file being compressed on a worker.
+type compressJob struct {
+	path, relPath string
+	info          os.FileInfo
+	done          chan struct{}
+	data          bytes.Buffer // Deflated contents
+	crc           uint32
+	size          int64
+	sum           []byte // SHA-256 of the contents
+	openErr       bool   // err came from opening the file
+	err           error
+}
+
+// archiveWriter adds files to an archive, compressing up to workers of them at once. Entries are written by
+// the caller's goroutine, in the order the files were added.
+type archiveWriter struct {
+	ctx       context.Context
+	zipWriter *zip.Writer
+	m         *manifest
+	workers   int           // Files compressed at once, by --workers or the watch's profile
+	slots     chan struct{} // Bounds the compressions running at once
+	queue     []*compressJob
+	previous  map[string]fileState // Files as of the previous backup; only the others are scanned for anomalies
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newArchiveWriter returns an archiveWriter adding files to zipWriter and their entries to m.
+func newArchiveWriter(ctx context.Context, zipWriter *zip.Writer, m *manifest) *archiveWriter {
+	workers := max(profileFrom(ctx).workerCount(), 1)
+	return &archiveWriter{ctx: ctx, zipWriter: zipWriter, m: m, workers: workers, slots: make(chan struct{}, workers)}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// add archives a file, handing it to a worker if the workers setting allows and it is small enough. Otherwise, and
+// for links, the files queued before it are written first and then it is added directly.
+func (a *archiveWriter) add(path, relPath string, info os.FileInfo) error {
+	if skip, err := skipInfected(a.ctx, &a.m.Skipped, path, relPath, info); skip || err != nil {
+		return err
+	}
+	if info.Mode().IsRegular() && changedSince(a.previous, relPath, info) {
+		anomalyFrom(a.ctx).scan(path)
+	}
+	if a.workers <= 1 || !info.Mode().IsRegular() || info.Size() > parallelMaxSize {
+		if err := a.flush(); err != nil {
+			return err
+		}
+		return addToZip(a.ctx, a.zipWriter, a.m, path, relPath, info)
+	}
+	// Keep the workers busy while the head of the queue is written, with at most two files per worker queued
+	for len(a.queue) >= 2*a.workers {
+		if err := a.writeNext(); err != nil {
+			return err
+		}
+	}
+	job := &compressJob{path: path, relPath: relPath, info: info, done: make(chan struct{})}
+	a.queue = append(a.queue, job)
+	go func() {
+		defer close(job.done)
+		select {
+		case a.slots <- struct{}{}:
+			defer func() { <-a.slots }()
+			job.compress(a.ctx)
+		case <-a.ctx.Done():
+			job.err = a.ctx.Err()
+		}
+	}()
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// flush writes every queued file to the archive.
+func (a *archiveWriter) flush() error {
+	for len(a.queue) > 0 {
+		if err := a.writeNext(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// discard waits for the queued compressions to end without writing them, after the archive failed.
+func (a *archiveWriter) discard() {
+	for _, job := range a.queue {
+		<-job.done
+	}
+	a.queue = nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// writeNext waits for the file at the head of the queue and writes its compressed data as a raw entry.
+func (a *archiveWriter) writeNext() error {
+	job := a.queue[0]
+	a.queue = a.queue[1:]
+	<-job.done
+	if job.err != nil {
+		if job.openErr && skipUnreadable(&a.m.Skipped, job.path, job.relPath, job.info, job.err) {
+			return nil
+		}
+		if job.openErr {
+			return annotate(stageRead, job.path, job.err)
+		}
+		return annotate(stageCompress, job.path, job.err)
+	}
+
+	header, owner, err := zipHeader(job.relPath, job.info)
+	if err != nil {
+		return annotate(stageCompress, job.path, err)
+	}
+	header.CRC32, header.CompressedSize64, header.UncompressedSize64 = job.crc, uint64(job.data.Len()), uint64(job.size)
+	zipEntry, err := a.zipWriter.CreateRaw(header)
+	if err == nil {
+		_, err = job.data.WriteTo(zipEntry)
+	}
+	if err != nil {
+		return annotate(stageCompress, job.path, err)
+	}
+	addedToZip(a.m, job.path, job.relPath, job.info, job.size, job.sum, owner)
+	progressFrom(a.ctx).fileDone(job.size)
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// compress deflates the file into job.data the way archive/zip would, computing the CRC-32 for the entry
+// and the SHA-256 for the manifest on the way.
+func (job *compressJob) compress(ctx context.Context) {
+	file, err := os.Open(job.path)
+	if err != nil {
+		job.err, job.openErr = err, true
+		return
+	}
+	defer file.Close()
+
+	deflater, err := flate.NewWriter(&job.data, zipDeflateLevel)
+	if err != nil {
+		job.err = err
+		return
+	}
+	crc, hash := crc32.NewIEEE(), sha256.New()
+	job.size, job.err = copyData(io.MultiWriter(deflater, crc, hash), contextReader{ctx, file})
+	if closeErr := deflater.Close(); job.err == nil {
+		job.err = closeErr
+	}
+	job.crc, job.sum = crc.Sum32(), hash.Sum(nil)
+}
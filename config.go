@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var configFile string
+
+// defaultTriggers are the event types that start a backup for watches that do not list their own, set by
+// --triggers.
+var defaultTriggers = fsnotify.Create
+
+// triggerOps maps the event type names accepted by --triggers and the config file to fsnotify ops.
+var triggerOps = map[string]fsnotify.Op{
+	"create": fsnotify.Create,
+	"write":  fsnotify.Write,
+	"rename": fsnotify.Rename,
+	"remove": fsnotify.Remove,
+	"chmod":  fsnotify.Chmod,
+}
+
+// config is the file given with --config. It lists the folders to watch, each with its own backup folder,
+// patterns of files to leave out of backups, the event types that start a backup, and the processors
+// finished archives go through, in order (see processorConfig). Top-level exclude patterns apply to every
+// watch, and top-level triggers to every watch without its own:
+//
+//	{
+//	  "exclude": ["*.tmp", "~$*"],
+//	  "triggers": ["create"],
+//	  "watches": [
+//	    {"watch": "/srv/scans", "backup": "/mnt/nas/scans", "exclude": ["thumbs"]},
+//	    {"watch": "/srv/docs", "backup": "/mnt/nas/docs", "triggers": ["create", "write"]},
+//	    {"watch": "/mnt/share/in", "backup": "/mnt/nas/in", "poll": "30s"},
+//	    {"watch": "/srv/logs", "backup": "/mnt/nas/logs", "triggers": ["write"], "min_interval": "15m"},
+//	    {"watch": "/srv/db", "backup": "/mnt/nas/db", "mode": "incremental", "pre_backup": "db-dump", "processors": []},
+//	    {"watch": "/srv/media", "backup": "/mnt/nas/media", "workers": 4}
+//	  ],
+//	  "processors": [{"type": "checksum"}, {"type": "copy", "to": "/mnt/offsite"}]
+//	}
+//
+// Patterns follow the search syntax: without a "/" they match file and folder names, with one the path
+// relative to the watch folder. Excluding a folder excludes everything in it. The file is read again on
+// SIGHUP.
+type config struct {
+	Exclude    []string          `json:"exclude"`
+	Triggers   []string          `json:"triggers"`
+	Watches    []watchConfig     `json:"watches"`
+	Processors []processorConfig `json:"processors"`
+}
+
+// watchConfig is a watch folder and the backup folder its archives go to, with the settings it overrides
+// (see watchProfile).
+type watchConfig struct {
+	Watch       string            `json:"watch"`
+	Backup      string            `json:"backup"`
+	Exclude     []string          `json:"exclude"`
+	Triggers    []string          `json:"triggers"`
+	Poll        string            `json:"poll"`         // Scan interval, to poll this watch instead of using --watcher
+	MinInterval string            `json:"min_interval"` // Shortest time between backups of this watch, instead of --min-interval
+	Blackout    string            `json:"blackout"`     // Windows in which backups are deferred, instead of --blackout
+	Mode        string            `json:"mode"`         // full, incremental, differential or split, instead of the flags
+	PreBackup   *string           `json:"pre_backup"`   // Instead of --pre-backup, "" for none
+	PostBackup  *string           `json:"post_backup"`  // Instead of --post-backup, "" for none
+	Processors  []processorConfig `json:"processors"`   // Instead of the top-level processors, [] for none
+	Workers     int               `json:"workers"`      // Files compressed at once, instead of --workers
+}
+
+// watchSettings are the settings of a watch that a config reload can change without restarting it.
+type watchSettings struct {
+	exclude     []string
+	profile     *watchProfile
+	triggers    fsnotify.Op
+	minInterval time.Duration
+	blackout    []blackoutWindow
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// loadConfig reads and validates a config file.
+func loadConfig(file string) (*config, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	if len(cfg.Watches) == 0 {
+		return nil, fmt.Errorf("%s: no watches configured", file)
+	}
+	for _, w := range cfg.Watches {
+		if w.Watch == "" || w.Backup == "" {
+			return nil, fmt.Errorf("%s: every watch needs a watch and a backup folder", file)
+		}
+	}
+	if err := cfg.absFolders(); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	// The state, queue and catalog of a backup folder belong to one watch
+	backups := make(map[string]string)
+	for _, w := range cfg.Watches {
+		if other, ok := backups[w.Backup]; ok {
+			return nil, fmt.Errorf("%s: %s and %s both back up to %s", file, other, w.Watch, w.Backup)
+		}
+		backups[w.Backup] = w.Watch
+	}
+	for _, w := range cfg.Watches {
+		if _, err := parseTriggers(w.Triggers); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", file, w.Watch, err)
+		}
+		if w.Poll != "" {
+			if interval, err := time.ParseDuration(w.Poll); err != nil || interval <= 0 {
+				return nil, fmt.Errorf("%s: %s: poll must be a positive duration such as \"30s\", got %q", file, w.Watch, w.Poll)
+			}
+		}
+		if w.MinInterval != "" {
+			if interval, err := time.ParseDuration(w.MinInterval); err != nil || interval < 0 {
+				return nil, fmt.Errorf("%s: %s: min_interval must be a duration such as \"5m\", got %q", file, w.Watch, w.MinInterval)
+			}
+		}
+		if _, err := parseBlackout(w.Blackout); err != nil {
+			return nil, fmt.Errorf("%s: %s: blackout: %w", file, w.Watch, err)
+		}
+		if _, err := newProfile(w); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", file, w.Watch, err)
+		}
+	}
+	if _, err := parseTriggers(cfg.Triggers); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	for _, pattern := range cfg.allExcludes() {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s: bad exclude pattern %q: %w", file, pattern, err)
+		}
+	}
+	return cfg, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// configFromArgs returns the config file given with --config, or a config for the single
+// "<watchFolder> <backupFolder>" pair on the command line.
+func configFromArgs(args []string) (*config, error) {
+	if configFile == "" {
+		watchFolder, backupFolder, err := getFoldersFromArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		cfg := &config{Watches: []watchConfig{{Watch: watchFolder, Backup: backupFolder}}}
+		return cfg, cfg.absFolders()
+	}
+	if len(args) > 0 {
+		return nil, fmt.Errorf("folders are taken from %s, remove them from the command line", configFile)
+	}
+	return loadConfig(configFile)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// absFolders makes the folders of every watch absolute. Windows only lifts the MAX_PATH limit for absolute
+// paths, so deep trees below a relative folder would otherwise fail.
+func (c *config) absFolders() error {
+	for i := range c.Watches {
+		w := &c.Watches[i]
+		var err error
+		if w.Watch, err = filepath.Abs(w.Watch); err != nil {
+			return err
+		}
+		if w.Backup, err = filepath.Abs(w.Backup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// key identifies a watch across config reloads.
+func (w watchConfig) key() string {
+	return filepath.Clean(w.Watch) + " -> " + filepath.Clean(w.Backup)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// excludes returns the exclude patterns that apply to a watch.
+func (c *config) excludes(w watchConfig) []string {
+	patterns := append(append([]string(nil), c.Exclude...), w.Exclude...)
+	if rel, ok := w.backupWithin(); ok {
+		patterns = append(patterns, "/"+escapePattern(rel))
+	}
+	return patterns
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// backupWithin returns the slash-separated path of the backup folder relative to the watch folder if it
+// is inside it. Such a backup folder is excluded, or every backup would archive the previous ones and
+// trigger the next.
+func (w watchConfig) backupWithin() (string, bool) {
+	if !pathWithin(w.Watch, w.Backup) {
+		return "", false
+	}
+	rel, err := filepath.Rel(w.Watch, w.Backup)
+	if err != nil || rel == "." {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// escapePattern quotes the characters path.Match treats specially, so a path matches only itself.
+func escapePattern(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// settings returns the reloadable settings of a watch. Its trigger names, blackout windows and profile
+// must have been validated. Flags given on the command line or through the environment win over the file.
+func (c *config) settings(w watchConfig) watchSettings {
+	triggers := defaultTriggers
+	switch {
+	case explicit("triggers"):
+	case len(w.Triggers) > 0:
+		triggers, _ = parseTriggers(w.Triggers)
+	case len(c.Triggers) > 0:
+		triggers, _ = parseTriggers(c.Triggers)
+	}
+	interval := minInterval
+	if w.MinInterval != "" && !explicit("min-interval") {
+		interval, _ = time.ParseDuration(w.MinInterval)
+	}
+	windows := blackout
+	if w.Blackout != "" && !explicit("blackout") {
+		windows, _ = parseBlackout(w.Blackout)
+	}
+	profile, _ := newProfile(w)
+	return watchSettings{exclude: c.excludes(w), profile: profile, triggers: triggers, minInterval: interval, blackout: windows}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// parseTriggers converts event type names into the fsnotify ops they stand for.
+func parseTriggers(names []string) (fsnotify.Op, error) {
+	var ops fsnotify.Op
+	for _, name := range names {
+		op, ok := triggerOps[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("unknown trigger %q (want create, write, rename, remove or chmod)", name)
+		}
+		ops |= op
+	}
+	return ops, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// allExcludes returns every exclude pattern in the config.
+func (c *config) allExcludes() []string {
+	patterns := append([]string(nil), c.Exclude...)
+	for _, w := range c.Watches {
+		patterns = append(patterns, w.Exclude...)
+	}
+	return patterns
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// excluded reports whether a slash-separated path relative to the watch folder matches an exclude pattern.
+func excluded(patterns []string, relPath string) bool {
+	if relPath == "." {
+		return false
+	}
+	for _, pattern := range patterns {
+		if matchPattern(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultControlSocket is where --daemon listens for 'foldermon ctl' unless --control-socket says otherwise.
+const defaultControlSocket = "foldermon.sock"
+
+// controlSocket is the Unix socket the monitor serves /status and the control API on, set by
+// --control-socket.
+var controlSocket string
+
+// ------------------------------------------------------------------------------------------------------------
+// serveControl serves /status, /events and the control API on a Unix socket at path in the background until ctx
+// ends. The socket is only accessible to its owner, so requests need no API token.
+func serveControl(ctx context.Context, path string) error {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another foldermon", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", handleStatus)
+	mux.HandleFunc("/events", handleEvents)
+	registerAPI(mux, "")
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Control socket failed", "error", err)
+		}
+	}()
+	slog.Info("Listening on control socket", "path", path)
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// runCtl implements "foldermon ctl status|backup|pause|resume", talking to a running monitor through its
+// control socket.
+func runCtl(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socket := fs.String("socket", defaultControlSocket, "control socket of the running monitor, see --control-socket")
+	watch := fs.String("watch", "", "only act on this watch folder")
+	output := outputFlag(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("usage: %s ctl [--socket %s] [--watch <watchFolder>] [--output json] status|backup|pause|resume", os.Args[0], defaultControlSocket)
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	client := controlClient(*socket)
+	query := ""
+	if *watch != "" {
+		query = "?watch=" + url.QueryEscape(*watch)
+	}
+
+	switch command := positional[0]; command {
+	case "status":
+		var report statusReport
+		if err := ctlRequest(ctx, client, http.MethodGet, "/status", &report); err != nil {
+			return err
+		}
+		if *output == outputJSON {
+			return printJSON(report)
+		}
+		printStatus(report)
+		return nil
+
+	case controlBackup, controlPause, controlResume:
+		var results []struct {
+			Watch    string `json:"watch"`
+			Accepted bool   `json:"accepted"`
+		}
+		if err := ctlRequest(ctx, client, http.MethodPost, "/api/"+command+query, &results); err != nil {
+			return err
+		}
+		if *output == outputJSON {
+			return printJSON(results)
+		}
+		for _, r := range results {
+			if r.Accepted {
+				fmt.Printf("%s: %s requested\n", r.Watch, command)
+			} else {
+				fmt.Printf("%s: busy with an earlier request, try again\n", r.Watch)
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown ctl command %q", command)
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// controlClient returns an HTTP client that talks to a monitor through its control socket.
+func controlClient(socket string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// ctlRequest sends a request over the control socket and decodes the JSON reply into v.
+func ctlRequest(ctx context.Context, client *http.Client, method, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, "http://foldermon"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("cannot reach foldermon: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("foldermon: %s", apiErr.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// printStatus prints a status report as a table of watches.
+func printStatus(report statusReport) {
+	fmt.Printf("foldermon is running (pid %d, up %s, %d backups queued)\n", report.PID,
+		(time.Duration(report.Uptime) * time.Second).String(), report.QueueDepth)
+	if report.LastError != "" {
+		fmt.Printf("Last error: %s\n", report.LastError)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "WATCH\tBACKUP\tSTATE\tLAST BACKUP\tRESULT\tOK/FAILED\tTODAY\tFREE")
+	for _, s := range report.Watches {
+		var state []string
+		if s.BackingUp && s.Progress != nil && s.Progress.BytesTotal > 0 {
+			state = append(state, fmt.Sprintf("backing up %d%%", s.Progress.BytesDone*100/s.Progress.BytesTotal))
+		} else if s.BackingUp {
+			state = append(state, "backing up")
+		}
+		if s.Queued {
+			state = append(state, "queued")
+		}
+		if s.Paused {
+			state = append(state, "paused")
+		}
+		if s.WatcherFallback != "" {
+			state = append(state, "polling (out of watches)")
+		}
+		if len(state) == 0 {
+			state = append(state, "idle")
+		}
+		last := "-"
+		if s.LastBackup != nil {
+			last = s.LastBackup.Local().Format(time.DateTime)
+		}
+		result := s.LastResult
+		if result == "" {
+			result = "-"
+		}
+		free := "-"
+		if s.FreeBytes != nil {
+			free = formatSize(*s.FreeBytes)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d/%d\t%s\t%s\n", s.Watch, s.Backup, strings.Join(state, ", "), last, result,
+			s.Successes, s.Failures, formatSize(s.BytesToday), free)
+	}
+	w.Flush()
+}
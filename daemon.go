@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPidFile = "foldermon.pid"
+	daemonEnv      = "FOLDERMON_DAEMON_CHILD" // Set in the environment of the background process started by --daemon
+	stopTimeout    = 30 * time.Second         // How long stop waits for the monitor to finish its backup and exit
+)
+
+var pidFile string
+
+// ------------------------------------------------------------------------------------------------------------
+// daemonize starts foldermon again with the same arguments as a background process detached from the
+// terminal, records its PID and returns. The background process sees daemonEnv and runs the monitor.
+func daemonize() error {
+	if pid, err := readPidFile(pidFile); err == nil && processAlive(pid) {
+		return fmt.Errorf("foldermon is already running (pid %d, %s)", pid, pidFile)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1")
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := writePidFile(pidFile, cmd.Process.Pid); err != nil {
+		return err
+	}
+	fmt.Printf("foldermon started in the background (pid %d)\n", cmd.Process.Pid)
+	return cmd.Process.Release()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// claimPidFile writes the PID of this process to path, refusing if it names another running foldermon.
+func claimPidFile(path string) error {
+	if pid, err := readPidFile(path); err == nil && pid != os.Getpid() && processAlive(pid) {
+		return fmt.Errorf("foldermon is already running (pid %d, %s)", pid, path)
+	}
+	return writePidFile(path, os.Getpid())
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// writePidFile records pid in path.
+func writePidFile(path string, pid int) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(pid)+"\n"), 0644)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// readPidFile returns the PID recorded in path.
+func readPidFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("%s: bad pid: %w", path, err)
+	}
+	return pid, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// runStatus reports on the running foldermon: its watches, their last backups, run counts, bytes archived
+// today and queue depth, taken from its control socket. Without a control socket it only reports whether
+// the foldermon recorded in the PID file is running.
+func runStatus(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	path := fs.String("pid-file", defaultPidFile, "PID file written by --daemon or --pid-file")
+	socket := fs.String("socket", defaultControlSocket, "control socket of the running monitor, see --control-socket")
+	output := outputFlag(fs)
+	asJSON := fs.Bool("json", false, "same as --output json")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	*asJSON = *asJSON || *output == outputJSON
+
+	var report statusReport
+	err := ctlRequest(ctx, controlClient(*socket), http.MethodGet, "/status", &report)
+	switch {
+	case err == nil && *asJSON:
+		return printJSON(report)
+	case err == nil:
+		printStatus(report)
+		return nil
+	case *asJSON:
+		return err
+	}
+
+	pid, err := readPidFile(*path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("foldermon is not running (no %s)", *path)
+	}
+	if err != nil {
+		return err
+	}
+	if !processAlive(pid) {
+		return fmt.Errorf("foldermon is not running (stale pid %d in %s)", pid, *path)
+	}
+	fmt.Printf("foldermon is running (pid %d), start it with --control-socket for details\n", pid)
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// runStop asks the foldermon recorded in the PID file to shut down and waits for it to exit.
+func runStop(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("stop", flag.ExitOnError)
+	path := fs.String("pid-file", defaultPidFile, "PID file written by --daemon or --pid-file")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	pid, err := readPidFile(*path)
+	if err != nil {
+		return err
+	}
+	if !processAlive(pid) {
+		os.Remove(*path)
+		fmt.Printf("foldermon is not running, removed stale %s\n", *path)
+		return nil
+	}
+	if err := stopProcess(pid); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(stopTimeout)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("foldermon (pid %d) did not exit within %s", pid, stopTimeout)
+		}
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	os.Remove(*path)
+	fmt.Printf("Stopped foldermon (pid %d)\n", pid)
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Process creation flags, see CreateProcess.
+const (
+	createNewProcessGroup = 0x00000200
+	detachedProcess       = 0x00000008
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// detachedProcAttr starts the background process without a console.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: createNewProcessGroup | detachedProcess}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// processAlive reports whether a process with the given PID is running.
+func processAlive(pid int) bool {
+	const stillActive = 259
+	handle, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(handle)
+	var code uint32
+	return syscall.GetExitCodeProcess(handle, &code) == nil && code == stillActive
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// stopProcess terminates the process. Windows cannot deliver SIGTERM to a detached process, so a backup
+// in progress is cut short and its partial archive left behind.
+func stopProcess(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Kill()
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// A dedup repository stores every file as a list of content-defined chunks. Chunks are named by their
+// SHA-256 sum, so data shared between files or between backups is stored once, and a backup (snapshot)
+// is only a list of chunk references:
+//
+//	<repo>/chunks/ab/ab12...ef   zlib-compressed chunk data
+//	<repo>/snapshots/snapshot_20250615_020000.json
+const (
+	repoChunksDir    = "chunks"
+	repoSnapshotsDir = "snapshots"
+
+	// Chunk boundaries are placed where the rolling gear hash matches chunkMask, giving chunks of about
+	// 1 MiB that stay aligned with the content when data is inserted or removed.
+	minChunkSize = 256 << 10
+	maxChunkSize = 4 << 20
+	chunkMask    = 1<<20 - 1
+)
+
+// gearTable maps each byte value to a pseudo-random 64-bit value for the rolling hash. It is derived
+// deterministically so chunk boundaries never change between versions.
+var gearTable = func() (table [256]uint64) {
+	for i := range table {
+		sum := sha256.Sum256([]byte{byte(i)})
+		table[i] = binary.LittleEndian.Uint64(sum[:8])
+	}
+	return table
+}()
+
+// snapshot is a single backup stored in a dedup repository.
+type snapshot struct {
+	Created time.Time      `json:"created"`
+	Files   []snapshotFile `json:"files"`
+	Skipped []skippedFile  `json:"skipped,omitempty"`
+}
+
+// snapshotFile describes a file in a snapshot and the chunks its content is made of, in order.
+type snapshotFile struct {
+	manifestEntry
+	Chunks []string `json:"chunks"`
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// backupToRepository stores the contents of the watch folder as a new snapshot in the dedup repository
+// kept in backupFolder and returns the snapshot path. Only chunks not already present in the repository are
+// written. It holds a lock on the repository throughout, so gc cannot remove chunks it reuses.
+func backupToRepository(ctx context.Context, watchFolder, backupFolder string, exclude []string) (string, error) {
+	if err := archiveSlots.acquire(ctx); err != nil {
+		return "", err
+	}
+	defer archiveSlots.release()
+	unlock, err := lockRepository(backupFolder)
+	if err != nil {
+		return "", annotate(stagePrepare, backupFolder, err)
+	}
+	defer unlock()
+
+	snap := &snapshot{Created: time.Now()}
+	var newChunks, reusedChunks int
+	var newBytes int64
+
+	if err := walkSlots.acquire(ctx); err != nil {
+		return "", err
+	}
+	_, walkSpan := tracer.Start(ctx, "walk")
+	deferral := newFileDeferral(ctx, watchFolder)
+	err = walkFolder(watchFolder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return annotate(stageWalk, path, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if path == filepath.Join(watchFolder, pauseFileName) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(watchFolder, path)
+		if err != nil {
+			return annotate(stageWalk, path, err)
+		}
+		if excluded(exclude, filepath.ToSlash(relPath)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || deferral.skip(path, info) || skipOversized(&snap.Skipped, path, filepath.ToSlash(relPath), info) {
+			return nil
+		}
+		if skip, err := skipInfected(ctx, &snap.Skipped, path, filepath.ToSlash(relPath), info); skip || err != nil {
+			return err
+		}
+
+		entry := snapshotFile{manifestEntry: manifestEntry{
+			Path:    filepath.ToSlash(relPath),
+			ModTime: info.ModTime(),
+			Mode:    info.Mode(),
+			Owner:   ownerOf(info),
+		}}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if entry.Link, err = os.Readlink(path); err != nil {
+				return annotate(stageRead, path, err)
+			}
+			sum := sha256.Sum256([]byte(entry.Link))
+			entry.Size, entry.SHA256 = int64(len(entry.Link)), hex.EncodeToString(sum[:])
+			snap.Files = append(snap.Files, entry)
+			return nil
+		}
+
+		recordAttrs(path, &entry.manifestEntry)
+		file, err := os.Open(path)
+		if err != nil {
+			if skipUnreadable(&snap.Skipped, path, entry.Path, info, err) {
+				return nil
+			}
+			return annotate(stageRead, path, err)
+		}
+		defer file.Close()
+
+		fileHash := sha256.New()
+		reader := bufio.NewReader(io.TeeReader(contextReader{ctx, file}, fileHash))
+		buf := make([]byte, 0, maxChunkSize)
+		for {
+			chunk, err := nextChunk(reader, buf)
+			if len(chunk) > 0 {
+				id, written, err := storeChunk(backupFolder, chunk)
+				if err != nil {
+					return annotate(stageCompress, path, err)
+				}
+				if written {
+					newChunks++
+					newBytes += int64(len(chunk))
+				} else {
+					reusedChunks++
+				}
+				entry.Chunks = append(entry.Chunks, id)
+				entry.Size += int64(len(chunk))
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return annotate(stageRead, path, err)
+			}
+		}
+		entry.SHA256 = hex.EncodeToString(fileHash.Sum(nil))
+		snap.Files = append(snap.Files, entry)
+
+		slog.Debug("Added to repository", "event", "file_added", "path", path, "bytes", entry.Size)
+		return nil
+	})
+	walkSlots.release()
+	walkSpan.SetAttributes(attribute.Int("foldermon.files", len(snap.Files)), attribute.Int("foldermon.new_chunks", newChunks),
+		attribute.Int("foldermon.reused_chunks", reusedChunks))
+	endSpan(walkSpan, err)
+	if err != nil {
+		slog.Error("Error storing snapshot", "error", err)
+		return "", annotate(stageCompress, watchFolder, err)
+	}
+
+	_, snapshotSpan := tracer.Start(ctx, "snapshot")
+	snapshotPath, err := writeSnapshot(backupFolder, snap)
+	endSpan(snapshotSpan, err)
+	if err != nil {
+		slog.Error("Failed to write snapshot", "error", err)
+		return "", annotate(stageManifest, filepath.Join(backupFolder, repoSnapshotsDir), err)
+	}
+	m := &manifest{Created: snap.Created, Type: archiveFull, Skipped: snap.Skipped}
+	for _, file := range snap.Files {
+		m.Files = append(m.Files, file.manifestEntry)
+	}
+	archiveFinished(watchFolder, snapshotPath, m, newBytes, "new_chunks", newChunks, "reused_chunks", reusedChunks)
+	_, catalogSpan := tracer.Start(ctx, "catalog")
+	err = catalogArchive(backupFolder, snapshotPath, m)
+	endSpan(catalogSpan, err)
+	if err != nil {
+		slog.Warn("Failed to update catalog", "error", err)
+	}
+	return snapshotPath, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// nextChunk reads the next content-defined chunk from r into buf. It returns io.EOF together with the
+// final (possibly empty) chunk once the input is exhausted.
+func nextChunk(r *bufio.Reader, buf []byte) ([]byte, error) {
+	buf = buf[:0]
+	var hash uint64
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return buf, err
+		}
+		buf = append(buf, b)
+		hash = hash<<1 + gearTable[b]
+		if len(buf) >= maxChunkSize || (len(buf) >= minChunkSize && hash&chunkMask == 0) {
+			return buf, nil
+		}
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// chunkPath returns the location of a chunk in the repository.
+func chunkPath(repo, id string) string {
+	return filepath.Join(repo, repoChunksDir, id[:2], id)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// storeChunk writes a chunk to the repository unless it is already present. It returns the chunk id and
+// whether the chunk was newly written.
+func storeChunk(repo string, chunk []byte) (string, bool, error) {
+	sum := sha256.Sum256(chunk)
+	id := hex.EncodeToString(sum[:])
+	path := chunkPath(repo, id)
+	if _, err := os.Stat(path); err == nil {
+		return id, false, nil
+	}
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(chunk)
+	if err := zw.Close(); err != nil {
+		return "", false, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return "", false, err
+	}
+	if err := os.WriteFile(path+".tmp", compressed.Bytes(), 0644); err != nil {
+		return "", false, err
+	}
+	return id, true, os.Rename(path+".tmp", path)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// readChunk loads a chunk from the repository and checks that its content matches its id.
+func readChunk(repo, id string) ([]byte, error) {
+	if len(id) < 2 {
+		return nil, fmt.Errorf("invalid chunk id %q", id)
+	}
+	file, err := os.Open(chunkPath(repo, id))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	zr, err := zlib.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %v", id, err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %s: %v", id, err)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != id {
+		return nil, fmt.Errorf("chunk %s is corrupt", id)
+	}
+	return data, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// writeSnapshot stores a snapshot in the repository and returns its path. A snapshot taken in the same
+// second as another is given a numbered suffix rather than replacing it.
+func writeSnapshot(repo string, snap *snapshot) (string, error) {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(repo, repoSnapshotsDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dir, "snapshot_*.tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	path := filepath.Join(dir, fmt.Sprintf("snapshot_%s.json", snap.Created.Format(archiveTimeLayout)))
+	path, err = publishArchive(tmp.Name(), path, path)
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return path, err
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// readSnapshot loads a snapshot file.
+func readSnapshot(path string) (*snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return &snap, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// isSnapshot reports whether path names a snapshot in a dedup repository rather than a zip archive.
+func isSnapshot(path string) bool {
+	return strings.HasSuffix(path, ".json") && filepath.Base(filepath.Dir(path)) == repoSnapshotsDir
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// isRepository reports whether folder contains a dedup repository.
+func isRepository(folder string) bool {
+	info, err := os.Stat(filepath.Join(folder, repoSnapshotsDir))
+	return err == nil && info.IsDir()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// findSnapshots returns the paths of all snapshots in the repository, oldest first.
+func findSnapshots(repo string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(repo, repoSnapshotsDir, "snapshot_*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(paths, func(i, j int) bool { return naturalLess(filepath.Base(paths[i]), filepath.Base(paths[j])) })
+	return paths, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// restoreSnapshot reassembles every file of a snapshot below targetDir from the repository chunks.
+// Unless force is set, it refuses to run if any file would be overwritten.
+func restoreSnapshot(ctx context.Context, snapshotPath, targetDir string, force bool) error {
+	snap, err := readSnapshot(snapshotPath)
+	if err != nil {
+		return err
+	}
+	repo := filepath.Dir(filepath.Dir(snapshotPath))
+
+	targets := make([]string, len(snap.Files))
+	for i, file := range snap.Files {
+		if targets[i], err = restorePath(targetDir, file.Path); err != nil {
+			return err
+		}
+		if force {
+			continue
+		}
+		if _, err := os.Lstat(targets[i]); err == nil {
+			return fmt.Errorf("refusing to overwrite %s (use --force)", targets[i])
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	for i, file := range snap.Files {
+		if err := checkLinkParents(targetDir, targets[i]); err != nil {
+			return err
+		}
+		if err := extractSnapshotFile(ctx, repo, file, targets[i]); err != nil {
+			return err
+		}
+		if err := applyAttrs(targets[i], file.manifestEntry); err != nil {
+			fmt.Printf("Warning: could not restore extended attributes of %s: %v\n", targets[i], err)
+		}
+		fmt.Printf("Restored: %s\n", targets[i])
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// extractSnapshotFile reassembles a single snapshot file from its chunks at target, creating parent
+// directories as needed.
+func extractSnapshotFile(ctx context.Context, repo string, file snapshotFile, target string) error {
+	if file.Link != "" {
+		return restoreLink(file.Link, target)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	mode := file.Mode.Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	dst, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	for _, id := range file.Chunks {
+		if err := ctx.Err(); err != nil {
+			dst.Close()
+			return err
+		}
+		data, err := readChunk(repo, id)
+		if err == nil {
+			_, err = dst.Write(data)
+		}
+		if err != nil {
+			dst.Close()
+			return fmt.Errorf("%s: %v", file.Path, err)
+		}
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return restoreMetadata(target, file.Mode, file.ModTime, file.Owner)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// skipOpenFiles leaves files that another process still holds open for writing out of backups, set by
+// --skip-open. Detection is best-effort, see openWriters.
+var skipOpenFiles bool
+
+// minFileAge leaves files modified more recently than this out of backups, set by --min-age, as a guard
+// against capturing slow uploads midway.
+var minFileAge time.Duration
+
+// maxFileSize leaves files larger than this out of backups altogether, set by --max-file-size (0 = no
+// limit).
+var maxFileSize int64
+
+// openRetryDelay is how long the monitor waits before backing up files deferred because they were open.
+const openRetryDelay = 30 * time.Second
+
+// deferredFiles collects the files a backup run left for the next one.
+type deferredFiles struct {
+	mu    sync.Mutex
+	paths []string
+	wait  time.Duration // Until every deferred file is expected to be ready
+}
+
+type deferredKey struct{}
+
+// ------------------------------------------------------------------------------------------------------------
+// withDeferred returns a context for a backup run that collects the files it defers.
+func withDeferred(ctx context.Context) (context.Context, *deferredFiles) {
+	d := &deferredFiles{}
+	return context.WithValue(ctx, deferredKey{}, d), d
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// pending returns the number of files deferred so far and how long until they should all be ready.
+func (d *deferredFiles) pending() (int, time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.paths), d.wait
+}
+
+// fileDeferral decides which files a walk leaves for the next backup. Create one per walk, so the open
+// files are only looked up once.
+type fileDeferral struct {
+	ctx  context.Context
+	busy func(path string) bool // nil without --skip-open or where open files cannot be detected
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newFileDeferral prepares the checks for a walk of root.
+func newFileDeferral(ctx context.Context, root string) *fileDeferral {
+	d := &fileDeferral{ctx: ctx}
+	if skipOpenFiles {
+		d.busy = openWriters(root)
+	}
+	return d
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// skip reports whether a file should be left out of this backup and picked up by the next one, recording
+// it in the run's deferred files.
+func (d *fileDeferral) skip(path string, info os.FileInfo) bool {
+	var reason string
+	var wait time.Duration
+	switch {
+	case minFileAge > 0 && time.Since(info.ModTime()) < minFileAge:
+		reason, wait = "modified too recently", minFileAge-time.Since(info.ModTime())
+	case d.busy != nil && d.busy(path):
+		reason, wait = "open for writing", openRetryDelay
+	default:
+		return false
+	}
+
+	slog.Info("Deferred file to the next backup", "event", "file_deferred", "path", path, "reason", reason)
+	if files, ok := d.ctx.Value(deferredKey{}).(*deferredFiles); ok {
+		files.mu.Lock()
+		files.paths = append(files.paths, path)
+		files.wait = max(files.wait, wait)
+		files.mu.Unlock()
+	}
+	return true
+}
+
+// skippedFile is a file left out of a backup for good, listed in the manifest so the gap is never silent.
+type skippedFile struct {
+	Path        string `json:"path"` // Slash-separated, relative to the watch folder
+	Size        int64  `json:"size"`
+	Reason      string `json:"reason"`
+	Quarantined string `json:"quarantined,omitempty"` // Where --quarantine moved an unreadable file
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// skipOversized reports whether a file exceeds --max-file-size, adding it to skipped if so.
+func skipOversized(skipped *[]skippedFile, path, relPath string, info os.FileInfo) bool {
+	if maxFileSize <= 0 || info.Size() <= maxFileSize {
+		return false
+	}
+	slog.Warn("Skipped file larger than --max-file-size", "event", "file_skipped", "path", path, "bytes", info.Size())
+	*skipped = append(*skipped, skippedFile{Path: relPath, Size: info.Size(), Reason: "larger than --max-file-size"})
+	return true
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// parseSize parses a byte count such as "500M" or "4GiB". K, M, G and T are powers of 1024.
+func parseSize(s string) (int64, error) {
+	number := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	shift := 0
+	if i := strings.IndexAny(number, "KMGT"); i >= 0 && i == len(number)-1 {
+		shift = 10 * (strings.IndexByte("KMGT", number[i]) + 1)
+		number = number[:i]
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 || n > (1<<63-1)>>shift {
+		return 0, fmt.Errorf("invalid size %q, want e.g. 500M or 4G", s)
+	}
+	return n << shift, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// formatSize formats a byte count the way parseSize reads it, e.g. 1.5G.
+func formatSize(n int64) string {
+	size, unit := float64(n), 0
+	for size >= 1024 && unit < 4 {
+		size, unit = size/1024, unit+1
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%.1f%c", size, "KMGT"[unit-1])
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// runDiff implements "foldermon diff <archiveA> <archiveB> [--output json]", listing files added, removed and modified
+// between two backups. Incremental and differential archives are compared by the complete folder state
+// they restore to, and dedup snapshots are accepted as well.
+func runDiff(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	output := outputFlag(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return usageError("usage: %s diff <archiveA> <archiveB> [--output json]", os.Args[0])
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	before, err := backupContents(positional[0])
+	if err != nil {
+		return err
+	}
+	after, err := backupContents(positional[1])
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for path := range before {
+		paths = append(paths, path)
+	}
+	for path := range after {
+		if _, ok := before[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	changes := []fileChange{}
+	for _, path := range paths {
+		oldSum, inBefore := before[path]
+		newSum, inAfter := after[path]
+		switch {
+		case !inBefore:
+			changes = append(changes, fileChange{Change: changeAdded, Path: path})
+		case !inAfter:
+			changes = append(changes, fileChange{Change: changeDeleted, Path: path})
+		case oldSum != newSum:
+			changes = append(changes, fileChange{Change: changeModified, Path: path})
+		}
+	}
+	if *output == outputJSON {
+		return printJSON(changes)
+	}
+	for _, c := range changes {
+		fmt.Printf("%s  %s\n", strings.ToUpper(c.Change[:1]), c.Path)
+	}
+	if len(changes) == 0 {
+		fmt.Println("No differences")
+	}
+	return nil
+}
+
+// fileChange is a difference between two backups, as printed by "foldermon diff --output json".
+type fileChange struct {
+	Change string `json:"change"` // changeAdded, changeDeleted or changeModified
+	Path   string `json:"path"`
+}
+
+// Kinds of fileChange.
+const (
+	changeAdded    = "added"
+	changeDeleted  = "deleted"
+	changeModified = "modified"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// backupContents returns the checksum of every file in the folder state captured by a backup, keyed by
+// relative path. Checksums are SHA-256 sums from the manifest or snapshot, or zip CRCs for archives
+// written without a manifest.
+func backupContents(path string) (map[string]string, error) {
+	contents := make(map[string]string)
+	if isSnapshot(path) {
+		snap, err := readSnapshot(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range snap.Files {
+			contents[file.Path] = file.SHA256
+		}
+		return contents, nil
+	}
+
+	chain, err := archiveChain(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, archivePath := range chain {
+		reader, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return nil, err
+		}
+		m, err := readManifest(&reader.Reader)
+		if err != nil {
+			reader.Close()
+			return nil, fmt.Errorf("%s: %v", archivePath, err)
+		}
+
+		if m != nil {
+			for _, entry := range m.Files {
+				contents[entry.Path] = entry.SHA256
+			}
+			for _, t := range m.Deleted {
+				delete(contents, t.Path)
+			}
+		} else {
+			for _, file := range reader.File {
+				if !file.FileInfo().IsDir() {
+					contents[file.Name] = fmt.Sprintf("crc32:%08x", file.CRC32)
+				}
+			}
+		}
+		reader.Close()
+	}
+	return contents, nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Windows error codes for a full disk.
+const (
+	errorHandleDiskFull syscall.Errno = 39
+	errorDiskFull       syscall.Errno = 112
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// isDiskFull reports whether err was caused by a full disk.
+func isDiskFull(err error) bool {
+	return errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull)
+}
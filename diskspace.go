@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// What to do when the backup folder looks too full for the next archive, set by --disk-check.
+const (
+	diskCheckWarn   = "warn"   // Log a disk_space_low alert and try anyway
+	diskCheckRefuse = "refuse" // Fail the backup before writing anything
+	diskCheckOff    = "off"
+)
+
+var (
+	diskCheck    = diskCheckWarn
+	minFreeSpace int64 // Space to leave free in the backup folder besides the archive, set by --min-free
+)
+
+// errInsufficientSpace fails a backup refused by --disk-check refuse.
+var errInsufficientSpace = errors.New("not enough free space in the backup folder")
+
+// ------------------------------------------------------------------------------------------------------------
+// checkFreeSpace compares the free space in the backup folder, where archives are also written under their
+// temporary name, with an estimate of the next archive, so a full disk is noticed before the archive is
+// half written rather than after.
+func checkFreeSpace(watchFolder, backupFolder, mode string) error {
+	if diskCheck == diskCheckOff {
+		return nil
+	}
+	free, err := freeSpace(backupFolder)
+	if err != nil {
+		slog.Debug("Cannot determine free space", "path", backupFolder, "error", err)
+		return nil
+	}
+	freeBytes.WithLabelValues(watchFolder).Set(float64(free))
+
+	needed := minFreeSpace + estimateArchiveSize(watchFolder, backupFolder, mode)
+	if free >= needed {
+		return nil
+	}
+	slog.Warn("ALERT: not enough free space for the next backup", "event", "disk_space_low", "path", watchFolder,
+		"backup", backupFolder, "free", free, "needed", needed)
+	if diskCheck == diskCheckRefuse {
+		return annotate(stagePrepare, backupFolder, fmt.Errorf("%w: %d bytes free, about %d needed", errInsufficientSpace, free, needed))
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// estimateArchiveSize guesses the size of the next archive from the last one, or from the size of the
+// files in the watch folder before the first backup, as compression rarely makes files larger. Dedup
+// snapshots only store new chunks, which cannot be known in advance.
+func estimateArchiveSize(watchFolder, backupFolder, mode string) int64 {
+	if dedup {
+		return 0
+	}
+	if mode != modeSplit {
+		if state, err := loadState(backupFolder); err == nil && state.LastArchive != "" {
+			if size := fileSize(filepath.Join(backupFolder, filepath.FromSlash(state.LastArchive))); size > 0 {
+				return size
+			}
+		}
+	}
+	var total int64
+	walkFolder(watchFolder, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
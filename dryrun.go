@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// planBackup logs what a backup of the watch folder would do with the current flags: the archive, snapshot
+// or copy it would create, the files it would store, the deletions it would record and the files it would
+// remove afterwards. Nothing is written or removed.
+func planBackup(ctx context.Context, watchFolder, backupFolder string, exclude []string) error {
+	state, err := loadState(backupFolder)
+	if err != nil {
+		return annotate(stagePrepare, filepath.Join(backupFolder, stateFileName), err)
+	}
+	now := time.Now()
+	timestamp := now.Format(archiveTimeLayout)
+	folder, stem := datedFolder(backupFolder, now), archiveStem(watchFolder, now)
+	mode := profileFrom(ctx).archiveMode()
+
+	var files []string
+	current := make(map[string]fileState)
+	switch {
+	case copyMode != copyOff:
+		return planCopy(ctx, watchFolder, backupFolder, exclude, state)
+
+	case dedup:
+		log.Printf("Dry run: would create snapshot %s\n", filepath.Join(backupFolder, repoSnapshotsDir, fmt.Sprintf("snapshot_%s.json", timestamp)))
+		files, err = planFiles(ctx, watchFolder, watchFolder, exclude, nil, current)
+
+	case mode == modeSplit:
+		entries, err := os.ReadDir(watchFolder)
+		if err != nil {
+			return annotate(stageWalk, watchFolder, err)
+		}
+		var loose []string
+		for _, entry := range entries {
+			if excluded(exclude, entry.Name()) {
+				continue
+			}
+			if !entry.IsDir() {
+				if entry.Name() != pauseFileName {
+					loose = append(loose, entry.Name())
+				}
+				continue
+			}
+			log.Printf("Dry run: would create %s\n", filepath.Join(folder, fmt.Sprintf("%s_%s.zip", stem, entry.Name())))
+			subset, err := planFiles(ctx, watchFolder, filepath.Join(watchFolder, entry.Name()), exclude, nil, current)
+			if err != nil {
+				return err
+			}
+			for _, f := range subset {
+				log.Printf("Dry run: would archive %s\n", f)
+			}
+		}
+		if len(loose) > 0 {
+			log.Printf("Dry run: would create %s\n", filepath.Join(folder, stem+".zip"))
+			for _, f := range loose {
+				log.Printf("Dry run: would archive %s\n", f)
+			}
+		}
+		return nil
+
+	default:
+		m, compareTo := nextArchive(backupFolder, state, mode)
+		files, err = planFiles(ctx, watchFolder, watchFolder, exclude, compareTo, current)
+		if err != nil {
+			return err
+		}
+		previous := compareTo
+		if m.Type == archiveFull {
+			previous = state.Files
+		}
+		deleted := deletionsSince(state.PendingDeletions, previous, current)
+		if m.Type != archiveFull && len(files) == 0 && len(deleted) == 0 {
+			log.Println("Dry run: no changes since the last backup, nothing would be archived")
+			return nil
+		}
+		if m.Base != "" {
+			log.Printf("Dry run: would create %s (%s, based on %s)\n", filepath.Join(folder, stem+".zip"), m.Type, m.Base)
+		} else {
+			log.Printf("Dry run: would create %s (%s)\n", filepath.Join(folder, stem+".zip"), m.Type)
+		}
+		for _, t := range deleted {
+			log.Printf("Dry run: would record deletion of %s\n", t.Path)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		log.Printf("Dry run: would archive %s\n", f)
+	}
+	if deleteAfterZip {
+		for _, f := range files {
+			log.Printf("Dry run: would delete %s\n", filepath.Join(watchFolder, filepath.FromSlash(f)))
+		}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// planFiles walks root, the watch folder or a folder in it, and returns the paths relative to the watch
+// folder of the files a backup would store: all files not excluded, or with compareTo set, those changed
+// since. current is filled with the state of every file seen.
+func planFiles(ctx context.Context, watchFolder, root string, exclude []string, compareTo, current map[string]fileState) ([]string, error) {
+	if err := walkSlots.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer walkSlots.release()
+
+	var files []string
+	deferral := newFileDeferral(ctx, root)
+	err := walkFolder(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return annotate(stageWalk, path, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if path == filepath.Join(watchFolder, pauseFileName) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(watchFolder, path)
+		if err != nil {
+			return annotate(stageWalk, path, err)
+		}
+		relPath = filepath.ToSlash(relPath)
+		if excluded(exclude, relPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		var skipped []skippedFile
+		if info.IsDir() || deferral.skip(path, info) || skipOversized(&skipped, path, relPath, info) {
+			return nil
+		}
+
+		current[relPath] = fileState{Size: info.Size(), ModTime: info.ModTime()}
+		if compareTo == nil || changedSince(compareTo, relPath, info) {
+			files = append(files, relPath)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// planCopy logs what --copy would do: the folder it would create or update, the files it would copy or, with
+// --copy snapshot, hard-link from the previous snapshot, the deletions it would record and the files it
+// would remove from the mirror.
+func planCopy(ctx context.Context, watchFolder, backupFolder string, exclude []string, state *backupState) error {
+	now := time.Now()
+	dest := filepath.Join(backupFolder, mirrorFolderName)
+	if copyMode == copySync {
+		dest = syncTo
+	}
+	var compareTo map[string]fileState
+	var prevFiles map[string]manifestEntry
+	incremental := false
+	if copyMode == copyTimestamped || copyMode == copySnapshot {
+		dest = filepath.Join(datedFolder(backupFolder, now), archiveStem(watchFolder, now))
+		switch {
+		case !archiveExists(backupFolder, state.LastArchive):
+		case copyMode == copyTimestamped:
+			compareTo, incremental = state.Files, true
+		default:
+			prevFiles, _ = snapshotFiles(filepath.Join(backupFolder, state.LastArchive))
+		}
+	}
+
+	current := make(map[string]fileState)
+	files, err := planFiles(ctx, watchFolder, watchFolder, exclude, compareTo, current)
+	if err != nil {
+		return err
+	}
+	var copies, links []string
+	for _, f := range files {
+		cur := current[f]
+		switch copyMode {
+		case copyMirror, copySync:
+			copied, err := os.Lstat(filepath.Join(dest, filepath.FromSlash(f)))
+			if err == nil && copied.Size() == cur.Size && copied.ModTime().Equal(cur.ModTime) {
+				continue
+			}
+		case copySnapshot:
+			if entry, ok := prevFiles[f]; ok && entry.Size == cur.Size && entry.ModTime.Equal(cur.ModTime) {
+				links = append(links, f)
+				continue
+			}
+		}
+		copies = append(copies, f)
+	}
+	var deleted []tombstone
+	if copyMode == copyTimestamped || copyMode == copySnapshot {
+		deleted = deletionsSince(state.PendingDeletions, state.Files, current)
+	}
+	var removals []string
+	if copyMode == copyMirror || copyMode == copySync && syncDelete {
+		if removals, err = planPrune(watchFolder, dest, exclude); err != nil {
+			return err
+		}
+	}
+
+	if len(copies) == 0 && len(deleted) == 0 && len(removals) == 0 && (incremental || prevFiles != nil || copyMode == copyMirror || copyMode == copySync) {
+		log.Println("Dry run: no changes since the last backup, nothing would be copied")
+		return nil
+	}
+	switch {
+	case copyMode == copyMirror || copyMode == copySync:
+		log.Printf("Dry run: would update %s\n", dest)
+	case incremental:
+		log.Printf("Dry run: would create %s (%s copy, based on %s)\n", dest, archiveIncremental, state.LastArchive)
+	case prevFiles != nil:
+		log.Printf("Dry run: would create %s (%s, linking unchanged files from %s)\n", dest, copyMode, state.LastArchive)
+	default:
+		log.Printf("Dry run: would create %s (%s)\n", dest, copyMode)
+	}
+	for _, t := range deleted {
+		log.Printf("Dry run: would record deletion of %s\n", t.Path)
+	}
+	for _, f := range copies {
+		log.Printf("Dry run: would copy %s\n", f)
+	}
+	for _, f := range links {
+		log.Printf("Dry run: would hard-link %s\n", f)
+	}
+	for _, path := range removals {
+		log.Printf("Dry run: would remove %s\n", path)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// planPrune returns the files in mirror that pruneMirror would remove: those no longer in the watch folder,
+// or excluded from it.
+func planPrune(watchFolder, mirror string, exclude []string) ([]string, error) {
+	var removals []string
+	err := filepath.Walk(mirror, func(path string, info os.FileInfo, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(mirror, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		source := filepath.Join(watchFolder, filepath.FromSlash(relPath))
+		if _, err := os.Lstat(source); err == nil && !excluded(exclude, relPath) && relPath != pauseFileName {
+			return nil
+		}
+		removals = append(removals, path)
+		return nil
+	})
+	return removals, err
+}
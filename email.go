@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// smtpPasswordEnv holds the password for --smtp-user, keeping it out of the process list.
+const smtpPasswordEnv = "FOLDERMON_SMTP_PASSWORD"
+
+// emailDigest is the --email-on value mailing one summary of every backup outcome a day. The others are
+// notifyFailures and notifyAll.
+const emailDigest = "digest"
+
+// emailSettings configures the SMTP notifications, set by the --smtp-* and --email-* flags.
+type emailSettings struct {
+	Server   string // host:port; STARTTLS is used when the server offers it
+	User     string
+	Password string
+	From     string
+	To       []string
+}
+
+// emailer sends backup outcomes by email, either each on its own or as a daily digest.
+type emailer struct {
+	emailSettings
+	mode string // notifyFailures, notifyAll or emailDigest
+
+	mu     sync.Mutex
+	digest []backupNotice // Outcomes not yet sent in a digest
+}
+
+// emailHandler is the slog sink feeding an emailer.
+type emailHandler struct {
+	*emailer
+	attrs []slog.Attr
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newEmailer checks the email settings. In digest mode it sends a digest every day at midnight until ctx
+// ends; call flushDigest before exiting to send the rest.
+func newEmailer(ctx context.Context, settings emailSettings, mode string) (*emailer, error) {
+	if settings.Server == "" {
+		return nil, fmt.Errorf("--email-to needs --smtp-server")
+	}
+	if _, _, err := net.SplitHostPort(settings.Server); err != nil {
+		return nil, fmt.Errorf("--smtp-server: %w", err)
+	}
+	if settings.From == "" {
+		host, _ := os.Hostname()
+		settings.From = "foldermon@" + host
+	}
+
+	e := &emailer{emailSettings: settings, mode: mode}
+	switch mode {
+	case notifyFailures, notifyAll:
+	case emailDigest:
+		go func() {
+			for {
+				now := time.Now()
+				midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+				select {
+				case <-time.After(midnight.Sub(now)):
+					e.flushDigest()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	default:
+		return nil, fmt.Errorf("unknown --email-on value %q (want %s, %s or %s)", mode, notifyFailures, notifyAll, emailDigest)
+	}
+	return e, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// handler returns the slog sink passing backup outcomes to the emailer.
+func (e *emailer) handler() slog.Handler {
+	return &emailHandler{emailer: e}
+}
+
+func (h *emailHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (h *emailHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *emailHandler) WithGroup(name string) slog.Handler { return h }
+
+// ------------------------------------------------------------------------------------------------------------
+// Handle mails a backup outcome, or keeps it for the digest, and mails every report. It does not wait for
+// the mail to be sent.
+func (h *emailHandler) Handle(_ context.Context, r slog.Record) error {
+	n, ok := noticeFromRecord(r, h.attrs)
+	if !ok || !n.outcome() {
+		return nil
+	}
+	switch {
+	case n.Event == "backup_report":
+		title, line := n.summary()
+		h.sendLater(title, line+"\n\nThe full report is in "+n.Attrs["report"]+".\n")
+	case h.mode == emailDigest:
+		h.mu.Lock()
+		h.digest = append(h.digest, n)
+		h.mu.Unlock()
+	case h.mode == notifyAll || n.failed():
+		title, _ := n.summary()
+		h.sendLater(fmt.Sprintf("%s: %s", title, n.watch()), noticeDetails(n))
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// flushDigest sends the outcomes collected since the last digest, if there are any.
+func (e *emailer) flushDigest() {
+	e.mu.Lock()
+	notices := e.digest
+	e.digest = nil
+	e.mu.Unlock()
+	if len(notices) == 0 {
+		return
+	}
+
+	failed := 0
+	var body strings.Builder
+	for _, n := range notices {
+		if n.failed() {
+			failed++
+		}
+		title, line := n.summary()
+		fmt.Fprintf(&body, "%s  %s  %s\n", n.Time.Format(time.DateTime), title, line)
+	}
+	for _, n := range notices {
+		if n.failed() {
+			body.WriteString("\n" + noticeDetails(n))
+		}
+	}
+	e.sendLater(fmt.Sprintf("Digest: %d backups, %d failed", len(notices), failed), body.String())
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// sendLater sends a mail in the background, logging failures.
+func (e *emailer) sendLater(subject, body string) {
+	pendingNotifications.Add(1)
+	go func() {
+		defer pendingNotifications.Done()
+		if err := e.send(subject, body); err != nil {
+			log.Println("Failed to send email notification:", err)
+		}
+	}()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// send mails a plain text message to every recipient. The subject is prefixed with the host name, so mail
+// from several machines can be told apart.
+func (e *emailer) send(subject, body string) error {
+	host, _ := os.Hostname()
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", "foldermon on "+host+": "+subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if e.User != "" {
+		serverHost, _, _ := net.SplitHostPort(e.Server)
+		auth = smtp.PlainAuth("", e.User, e.Password, serverHost)
+	}
+	return smtp.SendMail(e.Server, auth, e.From, e.To, []byte(msg.String()))
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// noticeDetails describes a backup outcome with all its attributes, for the body of a mail.
+func noticeDetails(n backupNotice) string {
+	title, line := n.summary()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%s\n\nTime: %s\n", title, line, n.Time.Format(time.RFC3339))
+
+	keys := make([]string, 0, len(n.Attrs))
+	for key := range n.Attrs {
+		if key != "event" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "%s: %s\n", key, n.Attrs[key])
+	}
+	return b.String()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix starts the environment variables that set flags, e.g. FOLDERMON_MIN_INTERVAL for the monitor's
+// --min-interval and FOLDERMON_RESTORE_FORCE for restore's --force.
+const envPrefix = "FOLDERMON_"
+
+// explicitFlags are the monitor flags given on the command line or through FOLDERMON_* variables. They take
+// precedence over the settings of the config file.
+var explicitFlags = make(map[string]bool)
+
+// ------------------------------------------------------------------------------------------------------------
+// recordExplicitFlags notes which monitor flags were given, once the command line is parsed. flag.Visit also
+// visits the flags applyEnv set.
+func recordExplicitFlags() {
+	flag.Visit(func(f *flag.Flag) { explicitFlags[f.Name] = true })
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// explicit reports whether any of the named monitor flags was given on the command line or through the
+// environment, so the config file must not override it.
+func explicit(names ...string) bool {
+	for _, name := range names {
+		if explicitFlags[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// envName returns the environment variable that sets a flag of fs. Flags of subcommands have the name of the
+// subcommand in front, so they are not set by variables meant for the monitor or its hooks.
+func envName(fs *flag.FlagSet, flagName string) string {
+	if fs != flag.CommandLine {
+		flagName = fs.Name() + "_" + flagName
+	}
+	return envPrefix + strings.ToUpper(strings.NewReplacer("-", "_", " ", "_").Replace(flagName))
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// applyEnv sets every flag of fs that has a FOLDERMON_* environment variable from it. It runs before the
+// command line is parsed, so flags given there take precedence, and both take precedence over the config
+// file (see explicit).
+func applyEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envName(fs, f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = withExitCode(exitConfig, fmt.Errorf("invalid value %q for %s: %w", value, name, setErr))
+		}
+	})
+	return err
+}
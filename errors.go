@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"syscall"
+)
+
+// Stages of a backup run, reported in backupError.Stage.
+const (
+	stagePrepare  = "prepare"  // Loading state, creating the archive
+	stageWalk     = "walk"     // Listing the watch folder
+	stageRead     = "read"     // Opening a file to archive
+	stageCompress = "compress" // Writing a file into the archive
+	stageManifest = "manifest" // Writing the manifest or snapshot
+	stageVerify   = "verify"   // Reading the archive back with --verify-after-write
+	stageMove     = "move"     // Moving the archive into place
+	stageHook     = "hook"     // Running the --pre-backup command
+	stageProcess  = "process"  // Passing the archive through the processors
+)
+
+// Failure classes, reported in backupError.Class.
+const (
+	classDiskFull         = "disk_full"
+	classPermissionDenied = "permission_denied"
+	classNotFound         = "not_found"
+	classTimeout          = "timeout"
+	classCanceled         = "canceled"
+	classIO               = "io"
+	classCorrupt          = "corrupt"  // The archive read back differently from what was written
+	classInfected         = "infected" // A virus scanner flagged a file, with --scan-action abort
+	classOther            = "other"
+)
+
+// backupError annotates a failed backup with where and why it failed, so alerts can be routed on the
+// failure class instead of on the error message.
+type backupError struct {
+	Stage   string `json:"stage"`
+	Path    string `json:"path,omitempty"`
+	Class   string `json:"class"`
+	Errno   int    `json:"errno,omitempty"`
+	Retries int    `json:"retries"`
+	Message string `json:"message"`
+	err     error
+}
+
+func (e *backupError) Error() string { return e.err.Error() }
+func (e *backupError) Unwrap() error { return e.err }
+
+// ------------------------------------------------------------------------------------------------------------
+// attrs returns the annotations as key/value pairs for structured log records.
+func (e *backupError) attrs() []any {
+	return []any{"stage", e.Stage, "class", e.Class, "errno", e.Errno, "path", e.Path, "retries", e.Retries}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// annotate wraps err with the stage and path it occurred at. Errors that are already annotated keep their
+// original, innermost annotation.
+func annotate(stage, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var be *backupError
+	if errors.As(err, &be) {
+		return err
+	}
+
+	be = &backupError{Stage: stage, Path: path, Class: classify(err), Message: err.Error(), err: err}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		be.Errno = int(errno)
+	}
+	return be
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// classify maps an error to one of the failure classes.
+func classify(err error) string {
+	var errno syscall.Errno
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return classTimeout
+	case errors.Is(err, context.Canceled):
+		return classCanceled
+	case isDiskFull(err), errors.Is(err, errInsufficientSpace):
+		return classDiskFull
+	case errors.Is(err, fs.ErrPermission):
+		return classPermissionDenied
+	case errors.Is(err, fs.ErrNotExist):
+		return classNotFound
+	case errors.Is(err, errVerifyFailed):
+		return classCorrupt
+	case errors.Is(err, errInfected):
+		return classInfected
+	case errors.As(err, &errno):
+		return classIO
+	default:
+		return classOther
+	}
+}
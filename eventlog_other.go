@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// newEventLogHandler fails: the event log only exists on Windows. Use --syslog instead.
+func newEventLogHandler() (slog.Handler, error) {
+	return nil, fmt.Errorf("--eventlog is only available on Windows, use --syslog instead")
+}
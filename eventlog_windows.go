@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+const eventSource = "foldermon"
+
+// eventIDs assigns an event log ID to each significant event. Other records are not written to the
+// event log.
+var eventIDs = map[string]uint32{
+	"backup_finished": 1,
+	"backup_failed":   2,
+	"backup_aborted":  3,
+	"watcher_error":   4,
+}
+
+// eventLogHandler writes significant events to the Windows Application event log.
+type eventLogHandler struct {
+	log   *eventlog.Log
+	attrs []slog.Attr
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newEventLogHandler opens the Application event log, registering foldermon as an event source if it is
+// not yet. Registering needs administrator rights; without them events are still written, but Event Viewer
+// shows them with a note about the missing source.
+func newEventLogHandler() (slog.Handler, error) {
+	eventlog.InstallAsEventCreate(eventSource, eventlog.Error|eventlog.Warning|eventlog.Info)
+	l, err := eventlog.Open(eventSource)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLogHandler{log: l}, nil
+}
+
+func (h *eventLogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (h *eventLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *eventLogHandler) WithGroup(name string) slog.Handler { return h }
+
+// ------------------------------------------------------------------------------------------------------------
+// Handle writes the record if it is a significant event.
+func (h *eventLogHandler) Handle(_ context.Context, r slog.Record) error {
+	var id uint32
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == "event" {
+			id = eventIDs[a.Value.String()]
+			return false
+		}
+		return true
+	})
+	if id == 0 {
+		return nil
+	}
+
+	msg := formatMessage(r, h.attrs)
+	switch {
+	case r.Level >= slog.LevelError:
+		return h.log.Error(id, msg)
+	case r.Level >= slog.LevelWarn:
+		return h.log.Warning(id, msg)
+	default:
+		return h.log.Info(id, msg)
+	}
+}
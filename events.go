@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// liveEvents relays log records carrying an event attribute (file_created, backup_started,
+// backup_finished, ...) to the clients subscribed to /events.
+var liveEvents = &eventHub{subscribers: make(map[chan liveEvent]bool)}
+
+// liveEvent is an event record encoded for /events.
+type liveEvent struct {
+	name string
+	data []byte
+}
+
+// eventHub keeps the subscribers of /events.
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan liveEvent]bool
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// subscribe returns a channel receiving every event from now on. Events are dropped for subscribers that
+// fall too far behind.
+func (hub *eventHub) subscribe() chan liveEvent {
+	ch := make(chan liveEvent, 64)
+	hub.mu.Lock()
+	hub.subscribers[ch] = true
+	hub.mu.Unlock()
+	return ch
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// unsubscribe stops delivering events to ch.
+func (hub *eventHub) unsubscribe(ch chan liveEvent) {
+	hub.mu.Lock()
+	delete(hub.subscribers, ch)
+	hub.mu.Unlock()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// active reports whether anyone is subscribed.
+func (hub *eventHub) active() bool {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	return len(hub.subscribers) > 0
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// publish delivers an event to every subscriber with room for it.
+func (hub *eventHub) publish(event liveEvent) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for ch := range hub.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// eventStreamHandler is the slog sink feeding liveEvents. It is only enabled while someone is subscribed,
+// so debug-level events such as file_added cost nothing otherwise.
+type eventStreamHandler struct {
+	hub   *eventHub
+	attrs []slog.Attr
+}
+
+func (h *eventStreamHandler) Enabled(context.Context, slog.Level) bool { return h.hub.active() }
+
+func (h *eventStreamHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *eventStreamHandler) WithGroup(name string) slog.Handler { return h }
+
+// ------------------------------------------------------------------------------------------------------------
+// Handle publishes the record as a JSON object if it is an event.
+func (h *eventStreamHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := map[string]any{"time": r.Time.Format(time.RFC3339Nano), "level": r.Level.String(), "message": r.Message}
+	add := func(a slog.Attr) bool {
+		switch v := a.Value.Resolve(); v.Kind() {
+		case slog.KindDuration:
+			fields[a.Key] = v.Duration().String()
+		case slog.KindAny:
+			if err, ok := v.Any().(error); ok {
+				fields[a.Key] = err.Error()
+			} else {
+				fields[a.Key] = v.Any()
+			}
+		default:
+			fields[a.Key] = v.Any()
+		}
+		return true
+	}
+	for _, a := range h.attrs {
+		add(a)
+	}
+	r.Attrs(add)
+
+	name, ok := fields["event"].(string)
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	h.hub.publish(liveEvent{name: name, data: data})
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// handleEvents streams events to the client as server-sent events, named after the event, until it
+// disconnects.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	ch := liveEvents.subscribe()
+	defer liveEvents.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-ch:
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, event.data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Exit statuses, so wrappers and cron jobs can tell why foldermon stopped. They are kept stable across
+// releases.
+const (
+	exitOK           = 0
+	exitFailure      = 1 // Any other error
+	exitConfig       = 2 // Bad flags, environment variables, config file or command line, as with flag parse errors
+	exitWatchSetup   = 3 // A watch, the PID file, the control socket or the metrics listener could not be set up
+	exitBackupFailed = 4 // A --once backup failed
+	exitVerifyFailed = 5 // An archive failed verification, after a --once backup or in "foldermon verify"
+	exitPartial      = 6 // A --once backup left files out, or only some of the watches were backed up
+)
+
+// errPartial fails a --once backup that completed but left files not ready yet for the next run.
+var errPartial = errors.New("files were left out of the backup")
+
+// exitError is an error that ends foldermon with a particular exit status.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// ------------------------------------------------------------------------------------------------------------
+// withExitCode makes foldermon exit with code if err ends a subcommand.
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// usageError returns an error for a subcommand called with the wrong arguments.
+func usageError(format string, args ...any) error {
+	return withExitCode(exitConfig, fmt.Errorf(format, args...))
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// exitCodeOf returns the exit status for an error that ended foldermon.
+func exitCodeOf(err error) int {
+	var ee *exitError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &ee):
+		return ee.code
+	case errors.Is(err, errVerifyFailed):
+		return exitVerifyFailed
+	case errors.Is(err, errPartial):
+		return exitPartial
+	}
+	return exitFailure
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// onceExitCode returns the exit status for the outcome of a --once run, given the error of every watch. When
+// every watch fails the status is that of the failure, or exitBackupFailed if they failed for different
+// reasons; when only some do, it is exitPartial.
+func onceExitCode(errs []error) int {
+	code, failures := exitOK, 0
+	for _, err := range errs {
+		c := exitCodeOf(err)
+		switch {
+		case err == nil:
+			continue
+		case c == exitFailure:
+			c = exitBackupFailed
+		}
+		if c != exitPartial {
+			failures++
+		}
+		switch {
+		case code == exitOK:
+			code = c
+		case code != c && c != exitPartial && code != exitPartial:
+			code = exitBackupFailed
+		}
+	}
+	if failures > 0 && failures < len(errs) {
+		return exitPartial
+	}
+	return code
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// fatal is log.Fatal with an exit status.
+func fatal(code int, v ...any) {
+	log.Print(v...)
+	os.Exit(code)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// fatalf is log.Fatalf with an exit status.
+func fatalf(code int, format string, v ...any) {
+	log.Printf(format, v...)
+	os.Exit(code)
+}
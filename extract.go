@@ -0,0 +1,103 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// runExtract implements "foldermon extract <archive> <path-in-archive> --to <dir> [--force]", copying a
+// single file out of a backup into dir without restoring anything else. For incremental and differential
+// archives the file is taken from the newest archive of the chain that contains it.
+func runExtract(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	to := fs.String("to", ".", "directory to extract the file into")
+	force := fs.Bool("force", false, "overwrite the file if it already exists in the target directory")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return usageError("usage: %s extract <archive> <path-in-archive> --to <dir> [--force]", os.Args[0])
+	}
+	archivePath, name := positional[0], path.Clean(filepath.ToSlash(positional[1]))
+
+	target := filepath.Join(*to, path.Base(name))
+	if !*force {
+		if _, err := os.Lstat(target); err == nil {
+			return fmt.Errorf("refusing to overwrite %s (use --force)", target)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if isSnapshot(archivePath) {
+		snap, err := readSnapshot(archivePath)
+		if err != nil {
+			return err
+		}
+		for _, file := range snap.Files {
+			if file.Path == name {
+				if err := extractSnapshotFile(ctx, filepath.Dir(filepath.Dir(archivePath)), file, target); err != nil {
+					return err
+				}
+				fmt.Printf("Extracted %s to %s\n", name, target)
+				return nil
+			}
+		}
+		return fmt.Errorf("%s not found in %s", name, archivePath)
+	}
+
+	chain, err := archiveChain(archivePath)
+	if err != nil {
+		return err
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		found, deleted, err := extractFromArchive(ctx, chain[i], name, target)
+		if err != nil {
+			return err
+		}
+		if found {
+			fmt.Printf("Extracted %s from %s to %s\n", name, filepath.Base(chain[i]), target)
+			return nil
+		}
+		if deleted {
+			return fmt.Errorf("%s was deleted before %s was taken", name, filepath.Base(chain[i]))
+		}
+	}
+	return fmt.Errorf("%s not found in %s", name, archivePath)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// extractFromArchive writes the named entry of an archive to target. If the archive has no such entry,
+// it reports whether the archive's manifest records the file as deleted instead.
+func extractFromArchive(ctx context.Context, archivePath, name, target string) (found, deleted bool, err error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return false, false, err
+	}
+	defer reader.Close()
+
+	for _, file := range reader.File {
+		if file.Name == name && !file.FileInfo().IsDir() {
+			return true, false, extractFile(ctx, file, target)
+		}
+	}
+
+	m, err := readManifest(&reader.Reader)
+	if err != nil || m == nil {
+		return false, false, err
+	}
+	for _, t := range m.Deleted {
+		if t.Path == name {
+			return false, true, nil
+		}
+	}
+	return false, false, nil
+}
@@ -0,0 +1,10 @@
+//go:build !windows
+
+package main
+
+// ------------------------------------------------------------------------------------------------------------
+// isFileLocked reports whether err means another process locks the file. Locks are advisory on Unix and
+// never keep a file from being opened.
+func isFileLocked(err error) bool {
+	return false
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Windows error codes for a file another process has opened without sharing or has locked a range of.
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// isFileLocked reports whether err means another process locks the file.
+func isFileLocked(err error) bool {
+	return errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
@@ -3,6 +3,13 @@
 //
 // Dependencies
 // - fsnotify
+// - modernc.org/sqlite
+// - golang.org/x/sys/windows/svc (Windows service)
+// - golang.org/x/sys/windows/svc/eventlog (Windows event log)
+// - gopkg.in/natefinch/lumberjack.v2 (log rotation)
+// - github.com/prometheus/client_golang (metrics)
+// - go.opentelemetry.io/otel (tracing)
+// - golang.org/x/net/webdav (browse)
 // - archive/zip
 // - log
 // - os
@@ -13,160 +20,889 @@ package main
 
 import (
 	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 var (
-	watchFolder  string
-	backupFolder string
+	watchFolder    string
+	backupFolder   string
+	maxPause       time.Duration
+	watcherBackend string
+	pollInterval   time.Duration
+	maxDuration    time.Duration
+	incremental    bool
+	differential   bool
+	fullEvery      time.Duration
+	dedup          bool
+	splitArchives  bool
+	dryRun         bool
+	once           bool
+	backupOnStart  bool
+	minInterval    time.Duration
+	maxInterval    time.Duration
+	retryAttempts  int                       // Retries of a failed backup before waiting for the next change
+	retryDelay     time.Duration             // Delay before the first retry, doubling with every further attempt
+	logConsole     io.Writer     = os.Stdout // Where log output goes besides the log file
 )
 
 const (
-	deleteAfterZip = false // Set to true to delete files after zipping
-	logFilePath    = "foldermon.log"
+	deleteAfterZip  = false // Set to true to delete files after zipping
+	logFilePath     = "foldermon.log"
+	pauseFileName   = ".foldermon-pause" // Producers create this file in the watch folder to suspend archiving
+	abortRetryDelay = 5 * time.Minute    // Delay before retrying a backup aborted by --max-duration
+	maxRetryDelay   = time.Hour          // Longest delay between retries of a failed backup
 )
 
+// commands maps subcommand names to their handlers. Anything else on the command line is treated as
+// "<watchFolder> <backupFolder>" and starts the folder monitor.
+var commands = map[string]func(ctx context.Context, args []string) error{
+	"restore": runRestore,
+	"service": runService,
+	"list":    runList,
+	"verify":  runVerify,
+	"index":   runIndex,
+	"search":  runSearch,
+	"history": runHistory,
+	"diff":    runDiff,
+	"extract": runExtract,
+	"status":  runStatus,
+	"stop":    runStop,
+	"ctl":     runCtl,
+	"keygen":  runKeygen,
+	"catalog": runCatalog,
+	"browse":  runBrowse,
+	"gc":      runGC,
+	"key":     runKey,
+	"rekey":   runRekey,
+}
+
 // ------------------------------------------------------------------------------------------------------------
 // Main function.
 func main() {
-	// Setup logging
-	logFile, err := os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	// Cancel long operations on Ctrl+C or termination
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Dispatch subcommands
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(ctx, os.Args[2:]); err != nil {
+				fatal(exitCodeOf(err), err)
+			}
+			return
+		}
+	}
+
+	// Under the Windows service manager, the monitor runs until the service is stopped
+	if runAsService(runMonitor) {
+		return
+	}
+	runMonitor(ctx)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// runMonitor starts the folder monitor with the flags and folders on the command line and runs it until
+// ctx ends.
+func runMonitor(ctx context.Context) {
+	// Exit with exitCode once everything deferred below has run
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	// Get flags and folders from command line arguments, or the config file.
+	logFormat := flag.String("log-format", logFormatText, "log format: text or json")
+	logLevel := flag.String("log-level", "info", "minimum level logged: debug, info, warn or error")
+	logMaxSize := flag.Int("log-max-size", 100, "rotate "+logFilePath+" when it reaches this many megabytes")
+	logMaxAge := flag.Int("log-max-age", 0, "delete rotated log files older than this many days (0 = keep regardless of age)")
+	logMaxFiles := flag.Int("log-max-files", 5, "number of rotated log files to keep (0 = keep all)")
+	syslogDestination := flag.String("syslog", "", "also send log records to syslog: local, udp://host[:514] or tcp://host[:601]")
+	syslogFacility := flag.String("syslog-facility", "daemon", "syslog facility, e.g. daemon, user or local0-local7")
+	notify := flag.String("notify", notifyOff, "show desktop notifications for failed backups (failures), every backup (all) or none (off)")
+	var email emailSettings
+	emailTo := flag.String("email-to", "", "email backup outcomes to these comma-separated addresses")
+	emailOn := flag.String("email-on", notifyFailures, "outcomes to email: failures, all, or digest for a daily summary")
+	flag.StringVar(&email.Server, "smtp-server", "", "SMTP server for --email-to, as host:port")
+	flag.StringVar(&email.User, "smtp-user", "", "SMTP user name; the password is read from "+smtpPasswordEnv)
+	flag.StringVar(&email.From, "email-from", "", "sender address of notification emails (default foldermon@<hostname>)")
+	flag.StringVar(&preBackupHook, "pre-backup", "", "shell command to run before each backup; the backup is skipped if it fails")
+	flag.StringVar(&postBackupHook, "post-backup", "", "shell command to run after each backup, with FOLDERMON_STATUS and FOLDERMON_ARCHIVE set")
+	report := flag.String("report", reportOff, "write a summary of backups, bytes, failures and skipped files every day (daily) or week (weekly), also emailed and posted to --webhook-url")
+	reportFile := flag.String("report-file", defaultReportFile, "file summary reports are appended to")
+	webhookURL := flag.String("webhook-url", "", "POST a JSON payload to this URL when a backup starts, finishes or fails, signed with "+webhookSecretEnv+" if set")
+	chatURL := flag.String("chat-url", "", "post backup outcomes as messages to this Slack, Discord or Microsoft Teams incoming webhook")
+	chatOn := flag.String("chat-on", notifyFailures, "outcomes to post to --chat-url: failures or all")
+	chatTemplate := flag.String("chat-template", defaultChatTemplate, "message posted to --chat-url as a Go template with {{.Title}}, {{.Summary}}, {{.Event}}, {{.Host}}, {{.Watch}}, {{.Failed}} and {{.Attrs}}")
+	eventLog := flag.Bool("eventlog", false, "also write backup results and watcher errors to the Windows Application event log")
+	verbose := flag.Bool("verbose", false, "log at debug level, including every archived file")
+	quiet := flag.Bool("quiet", false, "only log warnings and errors")
+	workDir := flag.String("workdir", "", "change to this directory first; relative folders and "+logFilePath+" are resolved against it")
+	flag.StringVar(&configFile, "config", "", "JSON file listing the folders to watch and exclude patterns, reloaded on SIGHUP")
+	flag.DurationVar(&maxPause, "max-pause", time.Hour, "resume archiving if the pause file is present for longer than this")
+	flag.StringVar(&watcherBackend, "watcher", watcherNative, "watcher backend: native or poll")
+	flag.DurationVar(&pollInterval, "poll-interval", 10*time.Second, "scan interval of the poll watcher")
+	poll := flag.Duration("poll", 0, "scan the watch folders this often instead of relying on notifications, e.g. 30s for NFS or CIFS mounts; same as --watcher poll --poll-interval")
+	triggers := flag.String("triggers", "create", "comma-separated file events that start a backup: create, write, rename, remove, chmod")
+	flag.BoolVar(&incremental, "incremental", false, "only archive files that are new or changed since the last successful backup")
+	flag.BoolVar(&differential, "differential", false, "only archive files that are new or changed since the last full backup")
+	flag.BoolVar(&dedup, "dedup", false, "store backups as snapshots in a deduplicating chunk repository instead of zip archives")
+	flag.StringVar(&catalogFile, "catalog", "", "path of the SQLite backup catalog (default <backupFolder>/"+catalogFileName+")")
+	flag.StringVar(&copyMode, "copy", copyOff, "copy files as they are instead of archiving them: timestamped (new and changed files into a backup_<timestamp> folder per run), snapshot (every file into a backup_<timestamp> folder per run, hard-linking unchanged ones to the previous run), mirror (one folder kept identical to the watch folder) or sync (every change mirrored right away to --sync-to)")
+	flag.StringVar(&syncTo, "sync-to", "", "folder --copy sync mirrors the watch folder to, e.g. a mounted remote share")
+	flag.BoolVar(&syncDelete, "sync-delete", false, "with --copy sync, also remove files from --sync-to once they are removed from the watch folder")
+	nameFormat := flag.String("name-template", defaultNameTemplate, "archive file name as a Go template with {{.Folder}}, {{.Timestamp}} and {{.Host}}, e.g. {{.Host}}_{{.Folder}}_{{.Timestamp}}.zip")
+	flag.StringVar(&dateFolders, "date-folders", "", "sort archives into date subfolders of the backup folder: day (2025/06/15), month, year or a Go time layout")
+	flag.BoolVar(&splitArchives, "split", false, "write one archive per top-level subdirectory of the watch folder")
+	flag.DurationVar(&fullEvery, "full-every", 7*24*time.Hour, "take a new full backup this often in differential mode")
+	maxBackups := flag.Int("max-backups", 0, "maximum number of backups running at once across all watches, hooks and processors included (0 = no limit)")
+	maxArchives := flag.Int("max-archives", 0, "maximum number of archives built at once across all watches (0 = no limit)")
+	maxWalks := flag.Int("max-walks", 0, "maximum number of directory walks at once across all watches (0 = no limit)")
+	flag.StringVar(&symlinkPolicy, "symlinks", symlinksFollow, "symbolic links: follow (archive what they point to), store (archive the links) or skip")
+	flag.BoolVar(&captureXattrs, "xattrs", false, "record extended attributes and POSIX ACLs (NTFS ACLs on Windows) and reapply them on restore")
+	flag.StringVar(&quarantineFolder, "quarantine", "", "move files that cannot be read (permissions, locks) to this folder on the same volume (default leave them in place)")
+	flag.StringVar(&clamdAddress, "clamd", "", "scan files for viruses with clamd before archiving them, at this socket path or host:port")
+	flag.StringVar(&scanCommand, "scan-command", "", "scan files for viruses with this shell command before archiving them; it gets the file in FOLDERMON_FILE and exits 1 if infected")
+	flag.StringVar(&scanAction, "scan-action", scanAction, "what to do with infected files: skip, quarantine (needs --quarantine) or abort the backup")
+	flag.BoolVar(&skipOpenFiles, "skip-open", true, "leave files still open for writing by another process to the next backup")
+	flag.StringVar(&diskCheck, "disk-check", diskCheckWarn, "when the backup folder looks too full for the next archive: warn, refuse (fail the backup) or off")
+	upload := flag.String("upload-limit", "", "maximum rate of copy processors, e.g. 5MB/s (default unlimited)")
+	uploadWindow := flag.String("upload-limit-hours", "", "only apply --upload-limit during these hours, e.g. 08:00-18:00 (default always)")
+	minFree := flag.String("min-free", "", "space to keep free in the backup folder besides the next archive, e.g. 10G (default none)")
+	flag.StringVar(&protectMode, "protect", protectOff, "protect finished archives from modification: readonly or immutable (also chattr +i on Linux, chflags schg on macOS; needs root)")
+	priority := flag.String("priority", priorityNormal, "process priority: normal, low (nice 10, low I/O priority) or idle (nice 19, idle I/O; background mode on Windows)")
+	bufferSize := flag.String("buffer-size", "256K", "size of the buffers files are streamed through, e.g. 1M for network shares")
+	flag.BoolVar(&detectAnomalies, "detect-anomalies", false, "alert on bursts of changes, files changing extension and content that looks encrypted, and never delete files after such a backup")
+	flag.IntVar(&anomalyBurst, "anomaly-burst", anomalyBurst, "file events within a minute that count as a burst with --detect-anomalies")
+	flag.BoolVar(&verifyAfterWrite, "verify-after-write", false, "read every archive back and check it against its manifest before counting the backup as done")
+	flag.BoolVar(&showProgress, "progress", false, "draw a progress bar with files, bytes and time remaining on the terminal during backups")
+	flag.IntVar(&compressWorkers, "workers", 1, "files compressed at once, e.g. the number of CPU cores")
+	maxSize := flag.String("max-file-size", "", "leave out files larger than this, e.g. 4G; they are listed as skipped in the manifest (default no limit)")
+	flag.DurationVar(&minFileAge, "min-age", 0, "leave files modified less than this long ago to the next backup, e.g. 30s (0 = no minimum)")
+	flag.BoolVar(&dryRun, "dry-run", false, "log what each backup would archive, create and delete without writing or removing anything")
+	blackoutSpec := flag.String("blackout", "", "defer backups during these comma-separated windows, e.g. \"08:00-18:00 weekdays,22:00-02:00\" (default none)")
+	flag.DurationVar(&minInterval, "min-interval", 0, "wait at least this long between backups, coalescing changes in between (0 = no limit)")
+	flag.DurationVar(&maxInterval, "max-interval", 0, "back up at least this often even if no new files are detected (0 = only on changes)")
+	flag.BoolVar(&backupOnStart, "backup-on-start", false, "back up immediately on startup to cover files that appeared while foldermon was not running")
+	daemon := flag.Bool("daemon", false, "run in the background, detached from the terminal (PID in --pid-file, default "+defaultPidFile+")")
+	flag.StringVar(&pidFile, "pid-file", "", "write the process ID to this file, for 'foldermon stop' and 'foldermon status'")
+	flag.StringVar(&controlSocket, "control-socket", "", "accept 'foldermon ctl' commands on this Unix socket (default "+defaultControlSocket+" with --daemon)")
+	metricsListen := flag.String("metrics-listen", "", "serve Prometheus metrics on /metrics and JSON status on /status at this address, e.g. :9090")
+	flag.StringVar(&apiToken, "api-token", "", "enable the control API on the --metrics-listen address, authenticated with this token (or set "+apiTokenEnv+")")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "export a trace of every backup run to this OTLP/HTTP collector, e.g. http://localhost:4318")
+	flag.BoolVar(&once, "once", false, "run a single backup and exit instead of watching the folder")
+	flag.IntVar(&retryAttempts, "retry-attempts", 5, "retries of a failed backup, with exponential backoff, before waiting for the next change")
+	flag.DurationVar(&retryDelay, "retry-delay", 30*time.Second, "delay before the first retry of a failed backup, doubling with every further attempt")
+	flag.DurationVar(&maxDuration, "max-duration", 0, "abort and reschedule backups running longer than this (0 = no limit)")
+	timezone := flag.String("timezone", "", "time zone of archive names, manifests, log lines and schedules, e.g. UTC or Europe/Lisbon (default: the system's)")
+	timestampUTC := flag.Bool("timestamp-utc", false, "same as --timezone UTC")
+	args, err := parseArgs(flag.CommandLine, os.Args[1:])
 	if err != nil {
-		log.Fatal(err)
+		fatal(exitConfig, err)
+	}
+	recordExplicitFlags()
+	if *workDir != "" {
+		if err := os.Chdir(*workDir); err != nil {
+			fatal(exitConfig, err)
+		}
+	}
+	if err := setTimezone(*timezone, *timestampUTC); err != nil {
+		fatal(exitConfig, err)
+	}
+
+	// Setup logging, rotating the log file by size and age
+	if *logMaxSize <= 0 {
+		fatal(exitConfig, "--log-max-size must be positive")
+	}
+	logFile := &lumberjack.Logger{
+		Filename:   logFilePath,
+		MaxSize:    *logMaxSize,
+		MaxAge:     *logMaxAge,
+		MaxBackups: *logMaxFiles,
+		LocalTime:  true,
 	}
 	defer logFile.Close()
-	log.SetOutput(io.MultiWriter(os.Stdout, logFile))
+	level, err := parseLogLevel(*logLevel)
+	if err != nil {
+		fatal(exitConfig, err)
+	}
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	if *quiet {
+		level = slog.LevelWarn
+	}
+	sinks := []slog.Handler{&eventStreamHandler{hub: liveEvents}}
+	if *syslogDestination != "" {
+		syslog, err := newSyslogHandler(*syslogDestination, *syslogFacility, level)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		sinks = append(sinks, syslog)
+	}
+	if *eventLog {
+		events, err := newEventLogHandler()
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		sinks = append(sinks, events)
+	}
+	desktop, err := newDesktopHandler(*notify)
+	if err != nil {
+		fatal(exitConfig, err)
+	}
+	if desktop != nil {
+		sinks = append(sinks, desktop)
+	}
+	defer pendingNotifications.Wait()
+	if *emailTo != "" {
+		for _, to := range strings.Split(*emailTo, ",") {
+			email.To = append(email.To, strings.TrimSpace(to))
+		}
+		email.Password = secret("smtp-password")
+		mailer, err := newEmailer(ctx, email, *emailOn)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		sinks = append(sinks, mailer.handler())
+		defer mailer.flushDigest()
+	}
+	if *webhookURL != "" {
+		hook, err := newWebhook(*webhookURL, secret("webhook-secret"))
+		if err != nil {
+			fatal(exitConfig, "--webhook-url: ", err)
+		}
+		sinks = append(sinks, hook.handler())
+	}
+	if *chatURL != "" {
+		chat, err := newChatHandler(*chatURL, *chatOn, *chatTemplate)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		sinks = append(sinks, chat)
+	}
+	if *report != reportOff {
+		reporter, err := newReporter(ctx, *report, *reportFile)
+		if err != nil {
+			fatal(exitConfig, err)
+		}
+		sinks = append(sinks, reporter.handler())
+		defer reporter.flush()
+	}
+	if err := setupLogging(io.MultiWriter(logConsole, logFile), *logFormat, level, sinks...); err != nil {
+		fatal(exitConfig, err)
+	}
 	log.Println("Foldermon: starting folder monitor...")
 
-	// Get folders from command line arguments.
-	watchFolder, backupFolder, err := getFoldersFromArgs()
+	switch symlinkPolicy {
+	case symlinksFollow, symlinksStore, symlinksSkip:
+	default:
+		fatalf(exitConfig, "unknown --symlinks policy %q (want %s, %s or %s)", symlinkPolicy, symlinksFollow, symlinksStore, symlinksSkip)
+	}
+	if *maxSize != "" {
+		if maxFileSize, err = parseSize(*maxSize); err != nil {
+			fatal(exitConfig, "--max-file-size: ", err)
+		}
+	}
+	switch *priority {
+	case priorityNormal:
+	case priorityLow, priorityIdle:
+		if err := setPriority(*priority); err != nil {
+			fatal(exitConfig, "--priority: ", err)
+		}
+	default:
+		fatalf(exitConfig, "unknown --priority %q (want %s, %s or %s)", *priority, priorityNormal, priorityLow, priorityIdle)
+	}
+	switch {
+	case scanAction != scanSkip && scanAction != scanQuarantine && scanAction != scanAbort:
+		fatalf(exitConfig, "unknown --scan-action %q (want %s, %s or %s)", scanAction, scanSkip, scanQuarantine, scanAbort)
+	case scanAction == scanQuarantine && quarantineFolder == "":
+		fatal(exitConfig, "--scan-action quarantine needs --quarantine")
+	}
+	switch protectMode {
+	case protectOff, protectReadOnly, protectImmutable:
+	default:
+		fatalf(exitConfig, "unknown --protect mode %q (want %s or %s)", protectMode, protectReadOnly, protectImmutable)
+	}
+	switch diskCheck {
+	case diskCheckWarn, diskCheckRefuse, diskCheckOff:
+	default:
+		fatalf(exitConfig, "unknown --disk-check mode %q (want %s, %s or %s)", diskCheck, diskCheckWarn, diskCheckRefuse, diskCheckOff)
+	}
+	if *upload != "" {
+		if uploadLimit, err = parseRate(*upload); err != nil {
+			fatal(exitConfig, "--upload-limit: ", err)
+		}
+	}
+	if *uploadWindow != "" {
+		if uploadHours, err = parseDailyWindow(*uploadWindow); err != nil {
+			fatal(exitConfig, "--upload-limit-hours: ", err)
+		}
+	}
+	if blackout, err = parseBlackout(*blackoutSpec); err != nil {
+		fatal(exitConfig, "--blackout: ", err)
+	}
+	if *minFree != "" {
+		if minFreeSpace, err = parseSize(*minFree); err != nil {
+			fatal(exitConfig, "--min-free: ", err)
+		}
+	}
+	if *poll > 0 {
+		watcherBackend, pollInterval = watcherPoll, *poll
+	}
+	if pollInterval <= 0 {
+		fatal(exitConfig, "--poll-interval must be positive")
+	}
+	if size, err := parseSize(*bufferSize); err != nil || size < 4<<10 || size > 64<<20 {
+		fatalf(exitConfig, "--buffer-size must be between 4K and 64M, got %q", *bufferSize)
+	} else {
+		copyBufferSize = int(size)
+	}
+	if compressWorkers < 1 {
+		fatal(exitConfig, "--workers must be at least 1")
+	}
+	if retryAttempts < 0 || retryDelay <= 0 {
+		fatal(exitConfig, "--retry-attempts must not be negative and --retry-delay must be positive")
+	}
+	if defaultTriggers, err = parseTriggers(strings.Split(*triggers, ",")); err != nil {
+		fatal(exitConfig, "--triggers: ", err)
+	}
+	cfg, err := configFromArgs(args)
 	if err != nil {
-		log.Fatal(err)
+		fatal(exitConfig, err)
+	}
+	if err := setPipeline(cfg.Processors); err != nil {
+		fatal(exitConfig, err)
 	}
+	if incremental && differential {
+		fatal(exitConfig, "--incremental and --differential cannot be combined")
+	}
+	if dedup && (incremental || differential) {
+		fatal(exitConfig, "--dedup snapshots are always complete and cannot be combined with --incremental or --differential")
+	}
+	if splitArchives && (incremental || differential || dedup) {
+		fatal(exitConfig, "--split cannot be combined with --incremental, --differential or --dedup")
+	}
+	if dateFolders, err = parseDateFolders(dateFolders); err != nil {
+		fatal(exitConfig, err)
+	}
+	if nameTemplate, err = parseNameTemplate(*nameFormat); err != nil {
+		fatal(exitConfig, err)
+	}
+	switch {
+	case copyMode != copyOff && copyMode != copyTimestamped && copyMode != copySnapshot && copyMode != copyMirror && copyMode != copySync:
+		fatalf(exitConfig, "unknown --copy mode %q (want %s, %s, %s or %s)", copyMode, copyTimestamped, copySnapshot, copyMirror, copySync)
+	case copyMode != copyOff && (incremental || differential || dedup || splitArchives):
+		fatal(exitConfig, "--copy cannot be combined with --incremental, --differential, --dedup or --split")
+	case copyMode == copySync && syncTo == "":
+		fatal(exitConfig, "--copy sync needs a --sync-to folder")
+	case copyMode != copySync && (syncTo != "" || syncDelete):
+		fatal(exitConfig, "--sync-to and --sync-delete only apply to --copy sync")
+	case copyMode == copySync && len(cfg.Watches) > 1:
+		fatal(exitConfig, "--copy sync mirrors a single watch folder to --sync-to")
+	}
+	for _, w := range cfg.Watches {
+		if copyMode == copySync && (pathWithin(w.Watch, syncTo) || pathWithin(syncTo, w.Watch)) {
+			fatalf(exitConfig, "--sync-to %s and the watch folder %s cannot be inside each other", syncTo, w.Watch)
+		}
+	}
+	if apiToken == "" && (*metricsListen != "" || os.Getenv(apiTokenEnv) != "") {
+		apiToken = secret("api-token")
+	}
+	if apiToken != "" && *metricsListen == "" {
+		fatal(exitConfig, "the control API is served on the --metrics-listen address, set one")
+	}
+	backupSlots, archiveSlots, walkSlots = newSemaphore(*maxBackups), newSemaphore(*maxArchives), newSemaphore(*maxWalks)
 
-	fmt.Printf("Watching folder: %s\n", watchFolder)
-	fmt.Printf("Backup folder: %s\n", backupFolder)
+	stopTracing := func() {}
+	if *otlpEndpoint != "" {
+		if stopTracing, err = setupTracing(ctx, *otlpEndpoint); err != nil {
+			fatal(exitConfig, err)
+		}
+	}
+	defer stopTracing()
 
-	// Ensure backup folder exists
-	os.MkdirAll(backupFolder, os.ModePerm)
+	if dryRun {
+		log.Println("Dry run: nothing will be written to or removed from the backup or watch folders")
+	}
+	for _, w := range cfg.Watches {
+		fmt.Printf("Watching folder: %s\n", w.Watch)
+		fmt.Printf("Backup folder: %s\n", w.Backup)
+		if rel, ok := w.backupWithin(); ok {
+			log.Printf("Backup folder is inside the watch folder, leaving %s out of backups\n", rel)
+		}
 
-	// Create file watcher
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		log.Fatal(err)
+		// Ensure backup folder exists
+		if !dryRun {
+			os.MkdirAll(w.Backup, os.ModePerm)
+		}
 	}
-	defer watcher.Close()
 
-	err = watcher.Add(watchFolder)
-	if err != nil {
-		log.Fatal(err)
+	// One-shot mode for cron and CI: back up every watch at once, within --max-backups, and report the
+	// outcome in the exit status (see onceExitCode)
+	if once {
+		var wg sync.WaitGroup
+		errs := make([]error, len(cfg.Watches))
+		for i, w := range cfg.Watches {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				settings := cfg.settings(w)
+				errs[i] = backupOnce(withProfile(ctx, settings.profile), w.Watch, w.Backup, settings.exclude)
+			}()
+		}
+		wg.Wait()
+		exitCode = onceExitCode(errs)
+		return
 	}
 
-	// Monitor loop
+	// Detach from the terminal: start a background copy of ourselves and leave
+	if *daemon && pidFile == "" {
+		pidFile = defaultPidFile
+	}
+	if *daemon && controlSocket == "" {
+		controlSocket = defaultControlSocket
+	}
+	if *daemon && os.Getenv(daemonEnv) == "" {
+		if err := daemonize(); err != nil {
+			fatal(exitWatchSetup, err)
+		}
+		return
+	}
+	if pidFile != "" {
+		if err := claimPidFile(pidFile); err != nil {
+			fatal(exitWatchSetup, err)
+		}
+		defer os.Remove(pidFile)
+	}
+
+	// Start a monitor per watch
+	monitors := make(map[string]*monitor)
+	var wg sync.WaitGroup
+	start := func(w watchConfig, settings watchSettings) error {
+		mon, err := newMonitor(w, settings)
+		if err != nil {
+			return err
+		}
+		monitors[w.key()] = mon
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mon.run(ctx)
+		}()
+		return nil
+	}
+	for _, w := range cfg.Watches {
+		if err := start(w, cfg.settings(w)); err != nil {
+			fatal(exitWatchSetup, err)
+		}
+	}
+
+	if *metricsListen != "" {
+		if err := serveMetrics(ctx, *metricsListen); err != nil {
+			fatal(exitWatchSetup, err)
+		}
+	}
+	if controlSocket != "" {
+		if err := serveControl(ctx, controlSocket); err != nil {
+			fatal(exitWatchSetup, err)
+		}
+		defer os.Remove(controlSocket)
+	}
+
+	// Report readiness and keep the watchdog fed when running under a service manager
+	serviceReady()
+	var watchdog <-chan time.Time
+	if interval := serviceWatchdog(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+
+	// Apply config changes on SIGHUP, leaving unchanged watches and their state alone
+	reloadSignal := make(chan os.Signal, 1)
+	notifyReloadSignal(reloadSignal)
 	for {
 		select {
-		case event, ok := <-watcher.Events:
-			if !ok {
-				return
-			}
+		case <-ctx.Done():
+			log.Println("Foldermon: shutting down")
+			serviceStopping()
+			wg.Wait()
+			return
 
-			if event.Op&fsnotify.Create == fsnotify.Create {
-				log.Printf("Detected new file: %s\n", event.Name)
-				time.Sleep(1 * time.Second) // Wait to ensure file is completely written
+		case <-watchdog:
+			servicePing()
 
-				// Call the zipAndMove function
-				if err := zipAndMove(watchFolder, backupFolder); err != nil {
-					fmt.Println("Error during zip and move:", err)
-					os.Exit(1)
-				}
+		case <-reloadSignal:
+			if configFile == "" {
+				log.Println("Reload requested, but there is no --config file to reload")
+				continue
+			}
+			newCfg, err := loadConfig(configFile)
+			if err == nil {
+				err = setPipeline(newCfg.Processors)
+			}
+			if err != nil {
+				slog.Error("Failed to reload config, keeping the current one", "error", err)
+				continue
 			}
 
-		case err, ok := <-watcher.Errors:
-			if !ok {
-				return
+			keep := make(map[string]bool)
+			for _, w := range newCfg.Watches {
+				keep[w.key()] = true
+				if mon, ok := monitors[w.key()]; ok {
+					mon.setSettings(newCfg.settings(w))
+					continue
+				}
+				if !dryRun {
+					os.MkdirAll(w.Backup, os.ModePerm)
+				}
+				if err := start(w, newCfg.settings(w)); err != nil {
+					slog.Error("Failed to watch", "path", w.Watch, "error", err)
+					keep[w.key()] = false
+					continue
+				}
+				log.Printf("Watching %s, backing up to %s\n", w.Watch, w.Backup)
+			}
+			for key, mon := range monitors {
+				if !keep[key] {
+					close(mon.stop)
+					delete(monitors, key)
+				}
 			}
-			log.Println("Watcher error:", err)
+			log.Println("Config reloaded from", configFile)
 		}
 	}
 }
 
 // ------------------------------------------------------------------------------------------------------------
-// Zip the contents of the watch folder into a zip file and move it to the backup folder.
-func zipAndMove(watchFolder, backupFolder string) error {
-	timestamp := time.Now().Format("20060102_150405")
-	zipFileName := fmt.Sprintf("backup_%s.zip", timestamp)
-	zipFilePath := filepath.Join(backupFolder, zipFileName)
-
-	zipFile, err := os.Create(zipFilePath)
+// backupOnce runs a single backup for --once and records its outcome. A backup that left files not ready yet
+// for the next run returns errPartial.
+func backupOnce(ctx context.Context, watchFolder, backupFolder string, exclude []string) error {
+	runCtx, cancel := ctx, context.CancelFunc(func() {})
+	if maxDuration > 0 {
+		runCtx, cancel = context.WithTimeout(ctx, maxDuration)
+	}
+	runCtx, deferred := withDeferred(runCtx)
+	_, err := runBackup(runCtx, watchFolder, backupFolder, exclude)
+	cancel()
+	if n, _ := deferred.pending(); n > 0 {
+		slog.Warn("Files not ready yet were left out, run again to back them up", "path", watchFolder, "files", n)
+	}
+	if !dryRun {
+		recordRun(backupFolder, err)
+		observeRun(watchFolder, err)
+	}
 	if err != nil {
-		log.Println("Failed to create zip:", err)
+		var be *backupError
+		if errors.As(err, &be) {
+			slog.Error("ALERT: backup failed", append([]any{"event", "backup_failed", "watch", watchFolder, "error", err}, be.attrs()...)...)
+		} else {
+			slog.Error("Backup failed", "error", err)
+		}
 		return err
 	}
+	if n, _ := deferred.pending(); n > 0 {
+		return errPartial
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// runBackup backs up the watch folder once with the configured method: zipAndMove, or its dedup and
+// split variants, leaving out files matching an exclude pattern, between the pre- and post-backup commands.
+// The archives written go through the processor pipeline, and their final paths are returned. In dry-run
+// mode it only logs the plan.
+func runBackup(ctx context.Context, watchFolder, backupFolder string, exclude []string) ([]string, error) {
+	if err := backupSlots.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer backupSlots.release()
+	ctx = withAnomaly(ctx, "")
+
+	slog.Info("Backup started", "event", "backup_started", "path", watchFolder, "backup", backupFolder)
+	ctx, span := tracer.Start(ctx, "backup", trace.WithAttributes(
+		attribute.String("foldermon.watch", watchFolder), attribute.String("foldermon.backup", backupFolder)))
+	var archives []string
+	err := preBackup(ctx, watchFolder, backupFolder)
+	mode := profileFrom(ctx).archiveMode()
+	if err == nil && !dryRun {
+		err = checkFreeSpace(watchFolder, backupFolder, mode)
+	}
+	switch {
+	case err != nil:
+		// The pre-backup command failed or the backup folder is too full, skip the backup
+	case dryRun:
+		err = planBackup(ctx, watchFolder, backupFolder, exclude)
+	case copyMode != copyOff:
+		var folder string
+		if folder, err = copyAndMirror(ctx, watchFolder, backupFolder, exclude); folder != "" {
+			archives = []string{folder}
+		}
+	case dedup:
+		var snapshot string
+		if snapshot, err = backupToRepository(ctx, watchFolder, backupFolder, exclude); snapshot != "" {
+			archives = []string{snapshot}
+		}
+	case mode == modeSplit:
+		archives, err = zipAndMoveSplit(ctx, watchFolder, backupFolder, exclude)
+	default:
+		var archive string
+		if archive, err = zipAndMove(ctx, watchFolder, backupFolder, exclude); archive != "" {
+			archives = []string{archive}
+		}
+	}
+	if err == nil && len(archives) > 0 {
+		anomalyFrom(ctx).suspect(watchFolder)
+	}
+	if err == nil && len(archives) > 0 && copyMode == copyOff {
+		archives, err = processArchives(ctx, archives)
+	}
+	if err == nil && copyMode == copyOff {
+		protectArchives(archives)
+	}
+	endSpan(span, err)
+	postBackup(ctx, watchFolder, backupFolder, archives, err)
+	return archives, err
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// Zip the contents of the watch folder into a zip file in the backup folder, returning its path, or "" if
+// there were no changes to archive. The archive is written under a temporary name and only renamed once it
+// is complete and on disk, so nothing watching the backup folder sees a partial backup_*.zip.
+// If ctx ends before the archive is complete, the partial archive is removed and ctx.Err() is returned.
+func zipAndMove(ctx context.Context, watchFolder, backupFolder string, exclude []string) (string, error) {
+	if err := archiveSlots.acquire(ctx); err != nil {
+		return "", err
+	}
+	defer archiveSlots.release()
+
+	now := time.Now()
+	folder, err := archiveFolder(backupFolder, now)
+	if err != nil {
+		return "", annotate(stagePrepare, folder, err)
+	}
+	base := filepath.Join(folder, archiveStem(watchFolder, now)+".zip")
+	destPath, zipFile, err := createArchive(base)
+	zipFilePath := destPath + ".tmp"
+	if err != nil {
+		slog.Error("Failed to create zip", "error", err)
+		return "", annotate(stagePrepare, zipFilePath, err)
+	}
 	defer zipFile.Close()
 
-	fmt.Printf("Zip file path: %s\n", zipFilePath)
+	fmt.Printf("Zip file path: %s\n", destPath)
 
 	zipWriter := zip.NewWriter(zipFile)
 	defer zipWriter.Close()
 
-	// Walk through files in the watch folder
-	err = filepath.Walk(watchFolder, func(path string, info os.FileInfo, err error) error {
+	state, err := loadState(backupFolder)
+	if err != nil {
+		slog.Error("Failed to read backup state", "error", err)
+		return "", annotate(stagePrepare, filepath.Join(backupFolder, stateFileName), err)
+	}
+	m, compareTo := nextArchive(backupFolder, state, profileFrom(ctx).archiveMode())
+	m.Base = baseReference(backupFolder, destPath, m.Base)
+	current := make(map[string]fileState)
+
+	// Walk through files in the watch folder, compressing them into the archive as they are found
+	if err := walkSlots.acquire(ctx); err != nil {
+		zipWriter.Close()
+		zipFile.Close()
+		os.Remove(zipFilePath)
+		return "", err
+	}
+	_, walkSpan := tracer.Start(ctx, "walk", trace.WithAttributes(attribute.String("foldermon.type", string(m.Type))))
+	progress := progressFrom(ctx)
+	if progress == nil {
+		ctx, progress = withProgress(ctx)
+	}
+	progress.setTotals(countBackupFiles(watchFolder, exclude, func(relPath string, info os.FileInfo) bool {
+		return (m.Type == archiveFull || changedSince(compareTo, relPath, info)) && (maxFileSize <= 0 || info.Size() <= maxFileSize)
+	}))
+	stopProgress := reportProgress(watchFolder, progress)
+	deferral := newFileDeferral(ctx, watchFolder)
+	archive := newArchiveWriter(ctx, zipWriter, m)
+	archive.previous = state.Files
+	err = walkFolder(watchFolder, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
+			return annotate(stageWalk, path, err)
+		}
+		if err := ctx.Err(); err != nil {
 			return err
 		}
 
-		if info.IsDir() {
+		if path == filepath.Join(watchFolder, pauseFileName) {
 			return nil
 		}
 
 		relPath, err := filepath.Rel(watchFolder, path)
 		if err != nil {
-			return err
+			return annotate(stageWalk, path, err)
 		}
-
-		zipEntry, err := zipWriter.Create(relPath)
-		if err != nil {
-			return err
+		if excluded(exclude, filepath.ToSlash(relPath)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
-
-		fileToZip, err := os.Open(path)
-		if err != nil {
-			return err
+		if info.IsDir() {
+			return nil
+		}
+		if deferral.skip(path, info) {
+			// Keep the last known state, so the file counts as changed next time rather than deleted
+			if prev, ok := state.Files[filepath.ToSlash(relPath)]; ok {
+				current[filepath.ToSlash(relPath)] = prev
+			}
+			return nil
 		}
-		defer fileToZip.Close()
 
-		_, err = io.Copy(zipEntry, fileToZip)
-		if err != nil {
-			return err
+		current[filepath.ToSlash(relPath)] = fileState{Size: info.Size(), ModTime: info.ModTime()}
+		if m.Type != archiveFull && !changedSince(compareTo, filepath.ToSlash(relPath), info) {
+			return nil
+		}
+		if skipOversized(&m.Skipped, path, filepath.ToSlash(relPath), info) {
+			return nil
 		}
 
-		log.Printf("Added to zip: %s\n", path)
-		return nil
+		return archive.add(path, filepath.ToSlash(relPath), info)
 	})
+	if err == nil {
+		err = archive.flush()
+	} else {
+		archive.discard()
+	}
+	stopProgress()
+	walkSlots.release()
+	walkSpan.SetAttributes(attribute.Int("foldermon.files", len(m.Files)))
+	endSpan(walkSpan, err)
 
+	if ctx.Err() != nil {
+		zipWriter.Close()
+		zipFile.Close()
+		os.Remove(zipFilePath)
+		log.Println("Backup aborted, removed partial archive:", zipFilePath)
+		return "", annotate(stageCompress, zipFilePath, ctx.Err())
+	}
 	if err != nil {
-		log.Println("Error creating zip archive:", err)
-		return err
+		zipWriter.Close()
+		zipFile.Close()
+		os.Remove(zipFilePath)
+		slog.Error("Error creating zip archive", "error", err)
+		return "", err
+	}
+
+	// Unreadable and infected files keep their last known state like deferred ones, so they are retried once
+	// changed
+	for _, s := range m.Skipped {
+		if !strings.HasPrefix(s.Reason, unreadableReason) && !strings.HasPrefix(s.Reason, infectedReason) {
+			continue
+		}
+		if prev, ok := state.Files[s.Path]; ok {
+			current[s.Path] = prev
+		} else {
+			delete(current, s.Path)
+		}
+	}
+
+	// Record deletions relative to the archive this one builds on, or to the previous backup for full ones
+	previous := compareTo
+	if m.Type == archiveFull {
+		previous = state.Files
+	}
+	m.Deleted = deletionsSince(state.PendingDeletions, previous, current)
+	if err := writeManifest(zipWriter, m); err != nil {
+		zipWriter.Close()
+		zipFile.Close()
+		os.Remove(zipFilePath)
+		slog.Error("Error creating zip archive", "error", err)
+		return "", annotate(stageManifest, zipFilePath, err)
+	}
+
+	if m.Type != archiveFull && len(m.Files) == 0 && len(m.Deleted) == 0 {
+		zipWriter.Close()
+		zipFile.Close()
+		os.Remove(zipFilePath)
+		log.Println("No changes since the last backup, nothing archived")
+		return "", nil
 	}
 
-	// Move zip to backup folder
-	destPath := filepath.Join(backupFolder, zipFileName)
-	err = os.Rename(zipFilePath, destPath)
+	// Finish the archive so its size is final when it is cataloged
+	_, compressSpan := tracer.Start(ctx, "compress")
+	err = zipWriter.Close()
+	if err == nil {
+		err = syncAndClose(zipFile)
+	}
+	endSpan(compressSpan, err)
 	if err != nil {
-		log.Println("Failed to move zip file:", err)
-		return err
+		zipFile.Close()
+		os.Remove(zipFilePath)
+		slog.Error("Error creating zip archive", "error", err)
+		return "", annotate(stageCompress, zipFilePath, err)
+	}
+
+	// Only an archive that reads back intact counts as a backup, before anything is deleted
+	_, verifySpan := tracer.Start(ctx, "verify")
+	err = checkWritten(ctx, zipFilePath)
+	endSpan(verifySpan, err)
+	if err != nil {
+		os.Remove(zipFilePath)
+		slog.Error("Archive failed verification, removed it", "error", err)
+		return "", annotate(stageVerify, zipFilePath, err)
+	}
+
+	// Give the complete archive its final name
+	_, moveSpan := tracer.Start(ctx, "move")
+	destPath, err = publishArchive(zipFilePath, destPath, base)
+	endSpan(moveSpan, err)
+	if err != nil {
+		os.Remove(zipFilePath)
+		slog.Error("Failed to move zip file", "error", err)
+		return "", annotate(stageMove, destPath, err)
 	}
-	log.Printf("Moved zip to: %s\n", destPath)
+	archiveFinished(watchFolder, destPath, m, fileSize(destPath))
 
-	// Delete files if required
-	if deleteAfterZip {
+	suspect := anomalyFrom(ctx).suspect(watchFolder)
+	_, catalogSpan := tracer.Start(ctx, "catalog")
+	if err := recordBackup(backupFolder, archiveName(backupFolder, destPath), m, current, suspect); err != nil {
+		slog.Warn("Failed to record backed up files", "error", err)
+	}
+	err = catalogArchive(backupFolder, destPath, m)
+	endSpan(catalogSpan, err)
+	if err != nil {
+		slog.Warn("Failed to update catalog", "error", err)
+	}
+
+	// Delete files if required, unless the files archived may have been damaged
+	if deleteAfterZip && suspect != "" {
+		log.Printf("Possible ransomware activity (%s), files not deleted\n", suspect)
+	} else if deleteAfterZip {
+		// The archive is written, so a run canceled while waiting for a walk slot only keeps the files
+		if err := walkSlots.acquire(ctx); err != nil {
+			slog.Warn("Files not deleted", "error", err)
+			return destPath, nil
+		}
+		defer walkSlots.release()
 		err = filepath.Walk(watchFolder, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				return err
@@ -183,20 +919,207 @@ func zipAndMove(watchFolder, backupFolder string) error {
 		})
 
 		if err != nil {
-			log.Println("Error deleting files:", err)
+			slog.Warn("Error deleting files", "error", err)
+		}
+	}
+	return destPath, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// syncAndClose flushes a written archive to disk before closing it, so it survives a crash once renamed.
+func syncAndClose(file *os.File) error {
+	err := file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// archiveFinished logs a written archive or snapshot and records it in the metrics. bytes is the archive
+// size, or the new data stored for dedup snapshots.
+func archiveFinished(watchFolder, archive string, m *manifest, bytes int64, attrs ...any) {
+	duration := time.Since(m.Created)
+	if len(m.Skipped) > 0 {
+		attrs = append(attrs, "skipped", len(m.Skipped))
+	}
+	slog.Info("Backup finished", append([]any{"event", "backup_finished", "path", watchFolder, "archive", archive, "type", m.Type,
+		"files", len(m.Files), "bytes", bytes, "duration", duration.Round(time.Millisecond).Seconds()}, attrs...)...)
+
+	bytesArchived.WithLabelValues(watchFolder).Add(float64(bytes))
+	countArchived(watchFolder, bytes)
+	archiveDuration.Observe(duration.Seconds())
+	archiveFiles.Observe(float64(len(m.Files)))
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// fileSize returns the size of a file, or 0 if it cannot be read.
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// nextArchive starts the manifest of the next archive and returns the file list it is compared against.
+// Incremental backups only archive files that differ from the last successful backup, differential
+// backups those that differ from the last full one. Either falls back to a full backup if its base
+// archive is gone.
+func nextArchive(backupFolder string, state *backupState, mode string) (*manifest, map[string]fileState) {
+	m := &manifest{Created: time.Now(), Type: archiveFull}
+	switch {
+	case mode == modeDifferential && archiveExists(backupFolder, state.LastFull) && time.Since(state.LastFullTime) < fullEvery:
+		m.Type, m.Base = archiveDifferential, state.LastFull
+		return m, state.FullFiles
+	case mode == modeIncremental && archiveExists(backupFolder, state.LastArchive):
+		m.Type, m.Base = archiveIncremental, state.LastArchive
+		return m, state.Files
+	}
+	return m, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// addToZip copies a file into the archive under relPath and records it in the manifest.
+func addToZip(ctx context.Context, zipWriter *zip.Writer, m *manifest, path, relPath string, info os.FileInfo) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		progressFrom(ctx).fileDone(info.Size())
+		return addLinkToZip(zipWriter, m, path, relPath, info)
+	}
+	// Open the file before adding its entry, so an unreadable file leaves no empty entry behind
+	fileToZip, err := os.Open(path)
+	if err != nil {
+		if skipUnreadable(&m.Skipped, path, relPath, info, err) {
+			return nil
 		}
+		return annotate(stageRead, path, err)
+	}
+	defer fileToZip.Close()
+
+	header, owner, err := zipHeader(relPath, info)
+	if err != nil {
+		return annotate(stageCompress, path, err)
 	}
+	zipEntry, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return annotate(stageCompress, path, err)
+	}
+
+	// Hash while copying so the manifest costs no extra read
+	hash := sha256.New()
+	size, err := copyData(io.MultiWriter(zipEntry, hash), contextReader{ctx, fileToZip})
+	if err != nil {
+		return annotate(stageCompress, path, err)
+	}
+
+	addedToZip(m, path, relPath, info, size, hash.Sum(nil), owner)
+	progressFrom(ctx).fileDone(size)
 	return nil
 }
 
 // ------------------------------------------------------------------------------------------------------------
-// getFoldersFromArgs retrieves the watchFolder and backupFolder from the command line arguments.
+// addedToZip lists a file written to the archive in the manifest.
+func addedToZip(m *manifest, path, relPath string, info os.FileInfo, size int64, sum []byte, owner *fileOwnership) {
+	entry := manifestEntry{
+		Path:    relPath,
+		Size:    size,
+		ModTime: info.ModTime(),
+		Mode:    info.Mode(),
+		SHA256:  hex.EncodeToString(sum),
+		Owner:   owner,
+	}
+	recordAttrs(path, &entry)
+	m.Files = append(m.Files, entry)
+
+	slog.Debug("Added to zip", "event", "file_added", "path", path, "bytes", size)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// addLinkToZip stores a symbolic link as a zip entry with the link mode and the target as its content, the
+// way Info-ZIP does.
+func addLinkToZip(zipWriter *zip.Writer, m *manifest, path, relPath string, info os.FileInfo) error {
+	linkTarget, err := os.Readlink(path)
+	if err != nil {
+		return annotate(stageRead, path, err)
+	}
+	header, owner, err := zipHeader(relPath, info)
+	if err != nil {
+		return annotate(stageCompress, path, err)
+	}
+	header.Method = zip.Store
+	zipEntry, err := zipWriter.CreateHeader(header)
+	if err == nil {
+		_, err = io.WriteString(zipEntry, linkTarget)
+	}
+	if err != nil {
+		return annotate(stageCompress, path, err)
+	}
+
+	sum := sha256.Sum256([]byte(linkTarget))
+	m.Files = append(m.Files, manifestEntry{
+		Path:    relPath,
+		Size:    int64(len(linkTarget)),
+		ModTime: info.ModTime(),
+		Mode:    info.Mode(),
+		SHA256:  hex.EncodeToString(sum[:]),
+		Link:    linkTarget,
+		Owner:   owner,
+	})
+	slog.Debug("Added link to zip", "event", "file_added", "path", path, "target", linkTarget)
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// isPaused reports whether the pause file is present in the watch folder.
+func isPaused(watchFolder string) bool {
+	_, err := os.Stat(filepath.Join(watchFolder, pauseFileName))
+	return err == nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// getFoldersFromArgs retrieves the watchFolder and backupFolder from the positional command line arguments.
 // It returns an error if the correct number of arguments are not provided.
-func getFoldersFromArgs() (string, string, error) {
-	if len(os.Args) != 3 {
-		return "", "", fmt.Errorf("usage: %s <watchFolder> <backupFolder>", os.Args[0])
+func getFoldersFromArgs(args []string) (string, string, error) {
+	if len(args) != 2 {
+		return "", "", usageError("usage: %s [flags] <watchFolder> <backupFolder>, or %s [flags] --config <file>", os.Args[0], os.Args[0])
 	}
-	watchFolder = os.Args[1]
-	backupFolder := os.Args[2]
+	watchFolder = args[0]
+	backupFolder := args[1]
 	return watchFolder, backupFolder, nil
 }
+
+// ------------------------------------------------------------------------------------------------------------
+// parseArgs parses flags for a subcommand, allowing flags to appear before or after positional arguments
+// (e.g. "restore backup.zip --to dir"), after setting those given by environment variables. It returns the
+// positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	if err := applyEnv(fs); err != nil {
+		return nil, err
+	}
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// contextReader fails reads once its context is done, so copies of large files can be interrupted.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package main
+
+import "errors"
+
+// ------------------------------------------------------------------------------------------------------------
+// freeSpace is not supported on this platform.
+func freeSpace(path string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
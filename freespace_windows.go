@@ -0,0 +1,17 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// ------------------------------------------------------------------------------------------------------------
+// freeSpace returns the bytes available to the current user on the volume holding path.
+func freeSpace(path string) (int64, error) {
+	name, err := windows.UTF16PtrFromString(longPath(path))
+	if err != nil {
+		return 0, err
+	}
+	var available uint64
+	if err := windows.GetDiskFreeSpaceEx(name, &available, nil, nil); err != nil {
+		return 0, err
+	}
+	return int64(available), nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Backups and garbage collection of a dedup repository exclude each other with lock files in
+// <repo>/locks: every backup creates one of its own, gc creates "gc" exclusively, and each checks for the
+// other's after creating its own, so at least one of them notices and backs off.
+const (
+	repoLocksDir = "locks"
+	gcLockName   = "gc"
+)
+
+// errRepositoryLocked fails a backup or gc that found the repository locked by the other.
+var errRepositoryLocked = errors.New("repository is locked")
+
+// ------------------------------------------------------------------------------------------------------------
+// lockRepository registers a backup writing to the repository and returns the function that unregisters
+// it. It fails while gc runs; the backup is retried like any other failure.
+func lockRepository(repo string) (func(), error) {
+	dir := filepath.Join(repo, repoLocksDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	lock, err := os.CreateTemp(dir, "backup-")
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(lock, "%d %s\n", os.Getpid(), time.Now().Format(time.RFC3339))
+	lock.Close()
+	unlock := func() { os.Remove(lock.Name()) }
+
+	if _, err := os.Stat(filepath.Join(dir, gcLockName)); err == nil {
+		unlock()
+		return nil, fmt.Errorf("%w: garbage collection is running (remove %s if it is not)", errRepositoryLocked, filepath.Join(dir, gcLockName))
+	}
+	return unlock, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// lockRepositoryForGC takes the exclusive lock of a repository and returns the function that releases it.
+// It fails if another gc holds the lock or a backup is writing.
+func lockRepositoryForGC(repo string) (func(), error) {
+	dir := filepath.Join(repo, repoLocksDir)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, gcLockName)
+	lock, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return nil, fmt.Errorf("%w: another gc is running (remove %s if it is not)", errRepositoryLocked, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(lock, "%d %s\n", os.Getpid(), time.Now().Format(time.RFC3339))
+	lock.Close()
+	unlock := func() { os.Remove(path) }
+
+	backups, err := filepath.Glob(filepath.Join(dir, "backup-*"))
+	if err == nil && len(backups) > 0 {
+		err = fmt.Errorf("%w: a backup is writing to it, try again later (remove %s if none is)", errRepositoryLocked, strings.Join(backups, ", "))
+	}
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// runGC implements "foldermon gc <repository> [--dry-run]", removing the chunks of a dedup repository that
+// no snapshot refers to any more, such as those of deleted snapshots and of backups that failed before
+// writing theirs, along with temporary files left by interrupted runs.
+func runGC(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "only report what would be removed")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("usage: %s gc <repository> [--dry-run]", os.Args[0])
+	}
+	repo := positional[0]
+	if !isRepository(repo) {
+		return fmt.Errorf("%s is not a dedup repository", repo)
+	}
+
+	unlock, err := lockRepositoryForGC(repo)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Every snapshot must be read: a chunk is only known to be unused once all of them have been checked
+	snapshots, err := findSnapshots(repo)
+	if err != nil {
+		return err
+	}
+	used := make(map[string]bool)
+	for _, path := range snapshots {
+		snap, err := readSnapshot(path)
+		if err != nil {
+			return fmt.Errorf("%v, nothing removed", err)
+		}
+		for _, file := range snap.Files {
+			for _, id := range file.Chunks {
+				used[id] = true
+			}
+		}
+	}
+
+	var removed, kept int
+	var freed int64
+	remove := func(path string, info os.FileInfo) error {
+		removed++
+		freed += info.Size()
+		if *dryRun {
+			return nil
+		}
+		return os.Remove(path)
+	}
+	err = filepath.Walk(filepath.Join(repo, repoChunksDir), func(path string, info os.FileInfo, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if name := info.Name(); strings.HasSuffix(name, ".tmp") || !used[name] {
+			return remove(path, info)
+		}
+		kept++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	temps, _ := filepath.Glob(filepath.Join(repo, repoSnapshotsDir, "*.tmp"))
+	for _, path := range temps {
+		if info, err := os.Stat(path); err == nil {
+			if err := remove(path, info); err != nil {
+				return err
+			}
+		}
+	}
+	if !*dryRun {
+		removeEmptyDirs(filepath.Join(repo, repoChunksDir))
+	}
+
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+	fmt.Printf("%s %d unused files (%s), kept %d chunks used by %d snapshots\n", verb, removed, formatSize(freed), kept, len(snapshots))
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// removeEmptyDirs removes the empty subfolders of the chunks folder.
+func removeEmptyDirs(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			os.Remove(filepath.Join(dir, entry.Name())) // Fails unless empty
+		}
+	}
+}
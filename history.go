@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"text/tabwriter"
+	"time"
+)
+
+// historyBatch is the number of file events a monitor collects before writing them to the catalog. They
+// are also written before every backup and when the monitor stops.
+const historyBatch = 256
+
+// fileEvent is a file event seen by a monitor, kept in the events table of the catalog.
+type fileEvent struct {
+	Time    time.Time `json:"time"`
+	Op      string    `json:"op"`      // CREATE, WRITE, REMOVE, RENAME or CHMOD, combined with | if several
+	Path    string    `json:"path"`    // Slash-separated, relative to the watch folder
+	Archive string    `json:"archive"` // Archive or snapshot of the backup that captured the event, "" until one did
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// recordEvents appends file events of a watch to the catalog of its backup folder. They are assigned to
+// the next successful backup when it is cataloged.
+func recordEvents(watchFolder, backupFolder string, events []fileEvent) error {
+	db, err := openCatalog(backupFolder)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	insert, err := tx.Prepare(`INSERT INTO events (destination, watch, time, op, path) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	destination := catalogDestination(backupFolder)
+	for _, e := range events {
+		if _, err := insert.Exec(destination, watchFolder, e.Time, e.Op, e.Path); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// runHistory implements "foldermon history <backupFolder>", listing the file events recorded for a backup
+// folder, oldest first, with the backup that captured each one.
+func runHistory(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	fs.StringVar(&catalogFile, "catalog", "", "path of the SQLite backup catalog (default <backupFolder>/"+catalogFileName+")")
+	pattern := fs.String("path", "", "only show events for files matching this pattern, as in 'foldermon search'")
+	since := fs.Duration("since", 0, "only show events from this long ago onwards, e.g. 24h (0 = all)")
+	limit := fs.Int("limit", 0, "only show the most recent events, at most this many (0 = all)")
+	output := outputFlag(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("usage: %s history <backupFolder> [--path pattern] [--since 24h] [--limit n] [--output json]", os.Args[0])
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	backupFolder := positional[0]
+	if _, err := path.Match(*pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %v", *pattern, err)
+	}
+
+	db, err := openExistingCatalog(backupFolder)
+	if err != nil {
+		return err
+	}
+	if db == nil {
+		return fmt.Errorf("no catalog found at %s", catalogPath(backupFolder))
+	}
+	defer db.Close()
+
+	var from time.Time
+	if *since > 0 {
+		from = time.Now().Add(-*since)
+	}
+	rows, err := db.Query(`SELECT e.time, e.op, e.path, COALESCE(b.archive, '')
+		FROM events e LEFT JOIN backups b ON b.id = e.backup_id
+		WHERE e.destination = ? AND e.time >= ? ORDER BY e.time, e.id`, catalogDestination(backupFolder), from)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	events := []fileEvent{}
+	for rows.Next() {
+		var e fileEvent
+		if err := rows.Scan(&e.Time, &e.Op, &e.Path, &e.Archive); err != nil {
+			return err
+		}
+		if *pattern == "" || matchPattern(*pattern, e.Path) {
+			events = append(events, e)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if *limit > 0 && len(events) > *limit {
+		events = events[len(events)-*limit:]
+	}
+	if *output == outputJSON {
+		return printJSON(events)
+	}
+	if len(events) == 0 {
+		fmt.Println("No file events recorded")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tEVENT\tPATH\tBACKUP")
+	for _, e := range events {
+		archive := e.Archive
+		if archive == "" {
+			archive = "(not yet backed up)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Time.Local().Format(time.DateTime), e.Op, e.Path, archive)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Shell commands run around every backup, set by --pre-backup and --post-backup. The pre-backup command
+// can quiesce an application or check preconditions; if it fails, the backup is not taken. The
+// post-backup command runs whatever the outcome, e.g. to copy the archive offsite.
+//
+// Both see FOLDERMON_WATCH and FOLDERMON_BACKUP. The post-backup command also gets FOLDERMON_STATUS
+// (success, failed, aborted or canceled), FOLDERMON_ARCHIVE (the archives written, separated by the path
+// list separator) and FOLDERMON_ERROR.
+var preBackupHook, postBackupHook string
+
+// ------------------------------------------------------------------------------------------------------------
+// runHook runs a hook command through the shell with extra environment variables, logging its output.
+func runHook(ctx context.Context, name, command string, env []string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), env...)
+
+	output, err := cmd.CombinedOutput()
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		log.Printf("%s: %s\n", name, scanner.Text())
+	}
+	if err != nil {
+		return fmt.Errorf("%s %q: %w", name, command, err)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// preBackup runs the pre-backup hook, if any, for a watch.
+func preBackup(ctx context.Context, watchFolder, backupFolder string) error {
+	command := profileFrom(ctx).preBackupCommand()
+	if command == "" {
+		return nil
+	}
+	if dryRun {
+		log.Printf("Dry run: would run pre-backup command %q\n", command)
+		return nil
+	}
+	err := runHook(ctx, "pre-backup", command, []string{"FOLDERMON_WATCH=" + watchFolder, "FOLDERMON_BACKUP=" + backupFolder})
+	return annotate(stageHook, watchFolder, err)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// postBackup runs the post-backup hook, if any, with the outcome of a backup. A failing hook is logged but
+// does not change the outcome.
+func postBackup(ctx context.Context, watchFolder, backupFolder string, archives []string, runErr error) {
+	command := profileFrom(ctx).postBackupCommand()
+	if command == "" {
+		return
+	}
+	if dryRun {
+		log.Printf("Dry run: would run post-backup command %q\n", command)
+		return
+	}
+	env := []string{
+		"FOLDERMON_WATCH=" + watchFolder,
+		"FOLDERMON_BACKUP=" + backupFolder,
+		"FOLDERMON_STATUS=" + runResult(runErr),
+		"FOLDERMON_ARCHIVE=" + strings.Join(archives, string(os.PathListSeparator)),
+		"FOLDERMON_ERROR=",
+	}
+	if runErr != nil {
+		env[len(env)-1] += runErr.Error()
+	}
+	// Run even if the backup was aborted or canceled
+	if err := runHook(context.WithoutCancel(ctx), "post-backup", command, env); err != nil {
+		log.Println("Post-backup command failed:", err)
+	}
+}
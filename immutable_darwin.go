@@ -0,0 +1,10 @@
+package main
+
+import "golang.org/x/sys/unix"
+
+// ------------------------------------------------------------------------------------------------------------
+// setImmutable sets the system immutable flag (chflags schg), which only root can set and, at a raised
+// securelevel, no one can clear. It needs root.
+func setImmutable(path string) error {
+	return unix.Chflags(path, unix.SF_IMMUTABLE)
+}
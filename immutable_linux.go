@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const fsImmutableFlag = 0x10 // FS_IMMUTABLE_FL from linux/fs.h
+
+// ------------------------------------------------------------------------------------------------------------
+// setImmutable sets the immutable attribute (chattr +i), after which not even root can modify, rename or
+// delete the file without clearing it first. It needs CAP_LINUX_IMMUTABLE and a filesystem that supports it.
+func setImmutable(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	flags, err := unix.IoctlGetUint32(int(file.Fd()), unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return err
+	}
+	return unix.IoctlSetPointerInt(int(file.Fd()), unix.FS_IOC_SETFLAGS, int(flags|fsImmutableFlag))
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// ------------------------------------------------------------------------------------------------------------
+// setImmutable is not supported on this platform; archives are left read-only.
+func setImmutable(path string) error {
+	return errors.ErrUnsupported
+}
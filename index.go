@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"html/template"
+	"os"
+	"time"
+)
+
+// indexPage is a self-contained HTML page listing every archive and its files. The search box filters
+// archives and files client-side, so the page can be served by any static web server.
+var indexPage = template.Must(template.New("index").Funcs(template.FuncMap{
+	"datetime": func(t time.Time) string { return t.Local().Format(time.DateTime) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>foldermon backups: {{.Destination}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+input { width: 100%; padding: .5em; font-size: 1em; margin-bottom: 1em; }
+details { border-bottom: 1px solid #ddd; padding: .4em 0; }
+summary { cursor: pointer; }
+table { border-collapse: collapse; margin: .5em 0 .5em 1.5em; }
+td { padding: .1em .8em; font-family: monospace; }
+.meta { color: #666; }
+</style>
+</head>
+<body>
+<h1>Backups of {{.Destination}}</h1>
+<p class="meta">{{len .Backups}} archives, generated {{datetime .Generated}}</p>
+<input id="search" type="search" placeholder="Filter by archive or file name" autofocus>
+{{range .Backups}}
+<details class="backup">
+<summary><strong>{{.Archive}}</strong> <span class="meta">{{datetime .Created}} &middot; {{.Type}} &middot; {{.Size}} bytes &middot; {{len .Files}} files</span></summary>
+<table>
+{{range .Files}}<tr class="file"><td>{{.Path}}</td><td>{{.Size}}</td><td>{{datetime .ModTime}}</td><td title="SHA-256">{{printf "%.12s" .SHA256}}</td></tr>
+{{end}}</table>
+</details>
+{{end}}
+<script>
+document.getElementById("search").addEventListener("input", function () {
+	var query = this.value.toLowerCase();
+	document.querySelectorAll(".backup").forEach(function (backup) {
+		var archiveMatch = backup.querySelector("summary").textContent.toLowerCase().includes(query);
+		var fileMatches = 0;
+		backup.querySelectorAll(".file").forEach(function (file) {
+			var match = archiveMatch || file.cells[0].textContent.toLowerCase().includes(query);
+			file.style.display = match ? "" : "none";
+			if (match) fileMatches++;
+		});
+		backup.style.display = archiveMatch || fileMatches > 0 ? "" : "none";
+		backup.open = query !== "" && !archiveMatch && fileMatches > 0;
+	});
+});
+</script>
+</body>
+</html>
+`))
+
+// indexBackup is an archive shown on the index page.
+type indexBackup struct {
+	catalogBackup
+	Files []catalogEntry
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// runIndex implements "foldermon index <backupFolder> --out index.html", writing a static, searchable HTML
+// index of all archives and their files from the catalog.
+func runIndex(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	out := fs.String("out", "index.html", "file to write the HTML index to")
+	fs.StringVar(&catalogFile, "catalog", "", "path of the SQLite backup catalog (default <backupFolder>/"+catalogFileName+")")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("usage: %s index <backupFolder> [--out index.html]", os.Args[0])
+	}
+	backupFolder := positional[0]
+
+	db, err := openExistingCatalog(backupFolder)
+	if err != nil {
+		return err
+	}
+	if db == nil {
+		return fmt.Errorf("no catalog found at %s", catalogPath(backupFolder))
+	}
+	defer db.Close()
+
+	backups, err := catalogBackups(db, backupFolder)
+	if err != nil {
+		return err
+	}
+	page := struct {
+		Destination string
+		Generated   time.Time
+		Backups     []indexBackup
+	}{Destination: catalogDestination(backupFolder), Generated: time.Now()}
+
+	// Newest first, which is what people usually look for
+	for i := len(backups) - 1; i >= 0; i-- {
+		files, err := catalogFiles(db, backups[i].ID)
+		if err != nil {
+			return err
+		}
+		page.Backups = append(page.Backups, indexBackup{catalogBackup: backups[i], Files: files})
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := indexPage.Execute(file, page); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote index of %d archives to %s\n", len(page.Backups), *out)
+	return nil
+}
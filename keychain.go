@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+const keychainService = "foldermon" // Service the secrets are filed under in the OS keychain
+
+// secretEnvs maps the secrets "foldermon key" manages to the environment variables that override them.
+var secretEnvs = map[string]string{
+	"smtp-password":  smtpPasswordEnv,
+	"webhook-secret": webhookSecretEnv,
+	"api-token":      apiTokenEnv,
+}
+
+// errNoSecret is returned by keychainGet for a secret not in the keychain.
+var errNoSecret = errors.New("secret not found in the keychain")
+
+// ------------------------------------------------------------------------------------------------------------
+// runKey implements "foldermon key set|delete <name>", storing a secret in the OS keychain, or removing it,
+// so it does not have to sit in a service definition or shell profile. The secret is read from the first
+// line of standard input.
+func runKey(ctx context.Context, args []string) error {
+	usage := usageError("usage: %s key set|delete smtp-password|webhook-secret|api-token", os.Args[0])
+	if len(args) != 2 {
+		return usage
+	}
+	name := args[1]
+	if _, ok := secretEnvs[name]; !ok {
+		return usage
+	}
+
+	switch args[0] {
+	case "set":
+		if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			fmt.Printf("Enter the %s and press Enter: ", name)
+		}
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		value := strings.TrimRight(line, "\r\n")
+		if value == "" {
+			return fmt.Errorf("no %s given on standard input: %v", name, err)
+		}
+		if err := keychainSet(name, value); err != nil {
+			return err
+		}
+		fmt.Printf("Stored the %s in the keychain\n", name)
+	case "delete":
+		if err := keychainDelete(name); err != nil {
+			return err
+		}
+		fmt.Printf("Removed the %s from the keychain\n", name)
+	default:
+		return usage
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// secret returns the named secret from its environment variable, or the OS keychain if the variable is
+// not set, or "" if neither has it.
+func secret(name string) string {
+	if value := os.Getenv(secretEnvs[name]); value != "" {
+		return value
+	}
+	value, err := keychainGet(name)
+	if err != nil && !errors.Is(err, errNoSecret) {
+		log.Printf("Cannot read the %s from the keychain: %v\n", name, err)
+	}
+	return value
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// keychainError describes a failed run of the keychain tool, with what it printed.
+func keychainError(tool string, err error, out []byte) error {
+	if msg := strings.TrimSpace(string(out)); msg != "" {
+		return fmt.Errorf("%s: %v: %s", tool, err, msg)
+	}
+	return fmt.Errorf("%s: %v", tool, err)
+}
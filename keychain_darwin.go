@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+const errSecItemNotFound = 44 // Exit status of security(1) for a missing keychain item
+
+// ------------------------------------------------------------------------------------------------------------
+// keychainGet reads a secret from the login keychain with security(1).
+func keychainGet(name string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", keychainService, "-a", name, "-w").Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == errSecItemNotFound {
+		return "", errNoSecret
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// keychainSet stores a secret in the login keychain, replacing any earlier one. security(1) only takes the
+// secret as an argument, so the command is written to its standard input in interactive mode rather than
+// put on a command line other users could see. Interactive mode does not fail with the command, so the
+// secret is read back to check that it was stored.
+func keychainSet(name, value string) error {
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(strings.Join([]string{"add-generic-password", "-U",
+		"-s", securityQuote(keychainService), "-a", securityQuote(name), "-w", securityQuote(value)}, " ") + "\n")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return keychainError("security", err, out)
+	}
+	if stored, err := keychainGet(name); err != nil || stored != value {
+		return keychainError("security", errors.New("secret not stored"), out)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// securityQuote quotes an argument for a command read by security -i.
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// keychainDelete removes a secret from the login keychain.
+func keychainDelete(name string) error {
+	out, err := exec.Command("security", "delete-generic-password", "-s", keychainService, "-a", name).CombinedOutput()
+	if err != nil {
+		return keychainError("security", err, out)
+	}
+	return nil
+}
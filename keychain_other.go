@@ -0,0 +1,48 @@
+//go:build !windows && !darwin
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// keychainGet reads a secret from the Secret Service (GNOME Keyring, KWallet) with secret-tool, which exits
+// with status 1 and no output for a missing secret.
+func keychainGet(name string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", keychainService, "account", name).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(out) == 0 {
+		return "", errNoSecret
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", errNoSecret
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// keychainSet stores a secret in the Secret Service, replacing any earlier one. secret-tool reads it from
+// standard input.
+func keychainSet(name, value string) error {
+	cmd := exec.Command("secret-tool", "store", "--label", "foldermon "+name, "service", keychainService, "account", name)
+	cmd.Stdin = strings.NewReader(value)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return keychainError("secret-tool", err, out)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// keychainDelete removes a secret from the Secret Service.
+func keychainDelete(name string) error {
+	if out, err := exec.Command("secret-tool", "clear", "service", keychainService, "account", name).CombinedOutput(); err != nil {
+		return keychainError("secret-tool", err, out)
+	}
+	return nil
+}
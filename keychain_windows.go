@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// secretPath returns the file a secret is kept in, encrypted with DPAPI for the current user.
+func secretPath(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "foldermon", "secrets", name), nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// keychainGet reads a secret stored by keychainSet. Only the user who stored it can decrypt it.
+func keychainGet(name string) (string, error) {
+	path, err := secretPath(name)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", errNoSecret
+	}
+	if err != nil || len(data) == 0 {
+		return "", err
+	}
+
+	in := windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	var out windows.DataBlob
+	if err := windows.CryptUnprotectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return "", err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return string(unsafe.Slice(out.Data, out.Size)), nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// keychainSet encrypts a secret with DPAPI for the current user and stores it in its file, replacing any
+// earlier one.
+func keychainSet(name, value string) error {
+	path, err := secretPath(name)
+	if err != nil {
+		return err
+	}
+	data := []byte(value)
+	in := windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	var out windows.DataBlob
+	if err := windows.CryptProtectData(&in, nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, unsafe.Slice(out.Data, out.Size), 0600)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// keychainDelete removes a stored secret.
+func keychainDelete(name string) error {
+	path, err := secretPath(name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// runList implements "foldermon list <backupFolder|archive> [--output json]". Given a backup folder it lists
+// the archives in it; given an archive it lists the files inside. Only the zip central directory is read, so
+// listing does not extract anything.
+func runList(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.StringVar(&catalogFile, "catalog", "", "path of the SQLite backup catalog (default <backupFolder>/"+catalogFileName+")")
+	output := outputFlag(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("usage: %s list <backupFolder|archive> [--output json]", os.Args[0])
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(positional[0])
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return listArchives(positional[0], *output)
+	}
+	return listArchiveContents(positional[0], *output)
+}
+
+// archiveListing is an archive as listed by "foldermon list --output json".
+type archiveListing struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size"`
+	Files   *int      `json:"files"` // null if the archive cannot be read
+}
+
+// fileListing is a file in an archive as listed by "foldermon list --output json".
+type fileListing struct {
+	Path     string    `json:"path"`
+	Size     uint64    `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// listArchives prints name, timestamp, size and file count for every archive in the backup folder.
+// File counts come from the catalog when it knows the archive, so archives only need to be opened when
+// they predate the catalog.
+func listArchives(backupFolder, output string) error {
+	archives, err := findArchives(backupFolder)
+	if err != nil {
+		return err
+	}
+
+	cataloged := make(map[string]catalogBackup)
+	db, err := openExistingCatalog(backupFolder)
+	if err != nil {
+		return err
+	}
+	if db != nil {
+		backups, err := catalogBackups(db, backupFolder)
+		db.Close()
+		if err != nil {
+			return err
+		}
+		for _, b := range backups {
+			cataloged[b.Archive] = b
+		}
+	}
+
+	listings := []archiveListing{}
+	for _, archivePath := range archives {
+		info, err := os.Stat(archivePath)
+		if err != nil {
+			return err
+		}
+
+		listing := archiveListing{Name: filepath.Base(archivePath), Path: archivePath, Created: archiveTime(archivePath), Size: info.Size()}
+		if b, ok := cataloged[filepath.Base(archivePath)]; ok {
+			listing.Files = &b.Files
+		} else if reader, err := zip.OpenReader(archivePath); err == nil {
+			files := countFiles(reader.File)
+			listing.Files = &files
+			reader.Close()
+		}
+		listings = append(listings, listing)
+	}
+	if output == outputJSON {
+		return printJSON(listings)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTIMESTAMP\tSIZE\tFILES")
+	for _, l := range listings {
+		files := "?"
+		if l.Files != nil {
+			files = fmt.Sprint(*l.Files)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", l.Name, l.Created.Format(time.DateTime), l.Size, files)
+	}
+	return w.Flush()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// listArchiveContents prints the size, modification time and path of every file in the archive.
+func listArchiveContents(archivePath, output string) error {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	listings := []fileListing{}
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() || file.Name == manifestName {
+			continue
+		}
+		listings = append(listings, fileListing{Path: file.Name, Size: file.UncompressedSize64, Modified: file.Modified})
+	}
+	if output == outputJSON {
+		return printJSON(listings)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SIZE\tMODIFIED\tPATH")
+	for _, l := range listings {
+		fmt.Fprintf(w, "%d\t%s\t%s\n", l.Size, l.Modified.Local().Format(time.DateTime), l.Path)
+	}
+	return w.Flush()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// countFiles returns the number of archived files, not counting directories and the manifest.
+func countFiles(files []*zip.File) int {
+	count := 0
+	for _, file := range files {
+		if !file.FileInfo().IsDir() && file.Name != manifestName {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Log formats selectable with --log-format.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// setupLogging sends log output at or above level to out in the given format. In JSON format every log line
+// becomes a record with time, level and msg, and backup events carry their details (event, path, archive,
+// duration, bytes) as separate fields. Plain log.Printf lines are logged at info level. Records are also
+// passed to the handlers in also, such as syslog.
+func setupLogging(out io.Writer, format string, level slog.Level, also ...slog.Handler) error {
+	log.SetOutput(out)
+	var handler slog.Handler
+	switch format {
+	case logFormatText:
+		handler = &textHandler{out: out, level: level, mu: &sync.Mutex{}}
+	case logFormatJSON:
+		handler = slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})
+	default:
+		return fmt.Errorf("unknown log format %q (want %s or %s)", format, logFormatText, logFormatJSON)
+	}
+	if len(also) > 0 {
+		handler = teeHandler(append([]slog.Handler{handler}, also...))
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// parseLogLevel parses a --log-level value.
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// textHandler writes records in the classic log format, "2006/01/02 15:04:05 msg", with the level in front
+// of the message unless it is info and attributes appended as key=value pairs.
+type textHandler struct {
+	out   io.Writer
+	level slog.Level
+	attrs []slog.Attr
+	mu    *sync.Mutex
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool { return level >= h.level }
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler { return h }
+
+// ------------------------------------------------------------------------------------------------------------
+// Handle formats and writes a record.
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var buf bytes.Buffer
+	buf.WriteString(r.Time.Format("2006/01/02 15:04:05 "))
+	if r.Level != slog.LevelInfo {
+		buf.WriteString(r.Level.String() + " ")
+	}
+	buf.WriteString(formatMessage(r, h.attrs))
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.out.Write(buf.Bytes())
+	return err
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// formatMessage returns the message of a record followed by its attributes as key=value pairs.
+func formatMessage(r slog.Record, attrs []slog.Attr) string {
+	var buf strings.Builder
+	buf.WriteString(strings.TrimSuffix(r.Message, "\n"))
+	writeAttr := func(a slog.Attr) bool {
+		value := a.Value.Resolve().String()
+		if value == "" || strings.ContainsAny(value, " \t\"=") {
+			value = strconv.Quote(value)
+		}
+		buf.WriteString(" " + a.Key + "=" + value)
+		return true
+	}
+	for _, a := range attrs {
+		writeAttr(a)
+	}
+	r.Attrs(writeAttr)
+	return buf.String()
+}
+
+// teeHandler passes records to several handlers.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := make(teeHandler, len(t))
+	for i, h := range t {
+		clone[i] = h.WithAttrs(attrs)
+	}
+	return clone
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	clone := make(teeHandler, len(t))
+	for i, h := range t {
+		clone[i] = h.WithGroup(name)
+	}
+	return clone
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// Handle passes a record to each handler that is enabled for its level.
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			if hErr := h.Handle(ctx, r.Clone()); err == nil {
+				err = hErr
+			}
+		}
+	}
+	return err
+}
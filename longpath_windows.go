@@ -0,0 +1,24 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxShortPath is the length from which Windows APIs need the \\?\ prefix. Directories are limited to
+// MAX_PATH minus room for an 8.3 file name.
+const maxShortPath = 248
+
+// ------------------------------------------------------------------------------------------------------------
+// longPath returns path in the \\?\ form Windows APIs accept beyond MAX_PATH. The os package does this by
+// itself, so it is only needed for paths handed to the Windows API directly.
+func longPath(path string) string {
+	if len(path) < maxShortPath || strings.HasPrefix(path, `\\?\`) || !filepath.IsAbs(path) {
+		return path
+	}
+	path = filepath.Clean(path)
+	if strings.HasPrefix(path, `\\`) {
+		return `\\?\UNC\` + path[2:]
+	}
+	return `\\?\` + path
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// TestLongUnicodePath archives and restores a file whose path is beyond MAX_PATH and whose folder and file
+// names are not ASCII.
+func TestLongUnicodePath(t *testing.T) {
+	watch, backup, target := t.TempDir(), t.TempDir(), t.TempDir()
+	rel := ""
+	for len(filepath.Join(watch, rel)) <= 300 {
+		rel = filepath.Join(rel, "Ünïcødé-文件夹-каталог")
+	}
+	rel = filepath.Join(rel, "данные-数据-🗂.txt")
+	if len(filepath.Join(target, rel)) <= 260 {
+		t.Fatalf("test path is only %d characters", len(filepath.Join(target, rel)))
+	}
+	content := []byte("long path, ünïcødé name\n")
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(watch, rel)), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(watch, rel), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	archive, err := zipAndMove(ctx, watch, backup, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive == "" {
+		t.Fatal("no archive written")
+	}
+	if err := restoreArchive(ctx, archive, target, false, false); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := os.ReadFile(filepath.Join(target, rel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(restored) != string(content) {
+		t.Errorf("restored %q, want %q", restored, content)
+	}
+}
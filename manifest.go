@@ -0,0 +1,93 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"time"
+)
+
+const manifestName = "MANIFEST.json" // Archive entry describing every file in the archive
+
+// manifest lists the files stored in an archive together with their checksums.
+type manifest struct {
+	Created time.Time       `json:"created"`
+	Type    string          `json:"type,omitempty"` // "full", "incremental" or "differential"
+	Base    string          `json:"base,omitempty"` // Archive this one builds on, if not full
+	Files   []manifestEntry `json:"files"`
+	Deleted []tombstone     `json:"deleted,omitempty"` // Files removed since the base (or previous) backup
+	Skipped []skippedFile   `json:"skipped,omitempty"` // Files left out, e.g. by --max-file-size
+}
+
+// Archive types recorded in the manifest. Incremental archives build on the previous archive,
+// differential archives on the last full one.
+const (
+	archiveFull         = "full"
+	archiveIncremental  = "incremental"
+	archiveDifferential = "differential"
+)
+
+// manifestEntry describes a single archived file.
+type manifestEntry struct {
+	Path    string            `json:"path"`
+	Size    int64             `json:"size"`
+	ModTime time.Time         `json:"mtime"`
+	Mode    fs.FileMode       `json:"mode"`
+	SHA256  string            `json:"sha256"`
+	Link    string            `json:"link,omitempty"` // Target of a symbolic link stored with --symlinks store
+	Owner   *fileOwnership    `json:"owner,omitempty"`
+	Xattrs  map[string][]byte `json:"xattrs,omitempty"` // Extended attributes, with --xattrs on Linux and macOS
+	ACL     string            `json:"acl,omitempty"`    // NTFS access control list in SDDL, with --xattrs on Windows
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// readManifest loads the manifest embedded in an archive. It returns nil without error for archives that
+// were written without one.
+func readManifest(reader *zip.Reader) (*manifest, error) {
+	file, err := reader.Open(manifestName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var m manifest
+	if err := json.NewDecoder(file).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// hashEntry reads an archive entry to the end, which also validates its CRC, and returns its SHA-256 sum.
+func hashEntry(ctx context.Context, file *zip.File) (string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	hash := sha256.New()
+	if _, err := copyData(hash, contextReader{ctx, src}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// writeManifest stores the manifest as the manifestName entry of the archive being written.
+func writeManifest(zipWriter *zip.Writer, m *manifest) error {
+	entry, err := zipWriter.Create(manifestName)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m)
+}
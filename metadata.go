@@ -0,0 +1,154 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"hash/crc32"
+	"io/fs"
+	"log/slog"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+// captureXattrs records extended attributes and ACLs in the manifest, set by --xattrs.
+var captureXattrs bool
+
+// unixExtraID is the Info-ZIP "ux" extra field holding the UID and GID of an entry, which unzip -X also
+// restores.
+const unixExtraID = 0x7875
+
+// unicodePathExtraID is the Info-ZIP Unicode Path extra field, carrying the UTF-8 name for tools that
+// ignore the UTF-8 flag of the entry and would otherwise show non-ASCII names in the wrong code page.
+const unicodePathExtraID = 0x7075
+
+// fileOwnership is the numeric owner of a file. It is only recorded on Unix.
+type fileOwnership struct {
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// zipHeader returns the zip header for a file, carrying its mode, modification time and owner, and the
+// owner on its own for the manifest.
+func zipHeader(relPath string, info os.FileInfo) (*zip.FileHeader, *fileOwnership, error) {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return nil, nil, err
+	}
+	header.Name, header.Method = relPath, zip.Deflate
+	if utf8.ValidString(relPath) && !isASCII(relPath) {
+		header.Extra = append(header.Extra, unicodePathExtra(relPath)...) // archive/zip sets the UTF-8 flag
+	}
+	owner := ownerOf(info)
+	if owner != nil {
+		header.Extra = append(header.Extra, ownerExtra(*owner)...)
+	}
+	return header, owner, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// ownerExtra encodes an owner as an Info-ZIP "ux" extra field.
+func ownerExtra(owner fileOwnership) []byte {
+	field := make([]byte, 15)
+	binary.LittleEndian.PutUint16(field[0:], unixExtraID)
+	binary.LittleEndian.PutUint16(field[2:], 11)
+	field[4], field[5] = 1, 4 // Version, UID size
+	binary.LittleEndian.PutUint32(field[6:], uint32(owner.UID))
+	field[10] = 4 // GID size
+	binary.LittleEndian.PutUint32(field[11:], uint32(owner.GID))
+	return field
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// unicodePathExtra encodes name as an Info-ZIP Unicode Path extra field.
+func unicodePathExtra(name string) []byte {
+	field := make([]byte, 9, 9+len(name))
+	binary.LittleEndian.PutUint16(field[0:], unicodePathExtraID)
+	binary.LittleEndian.PutUint16(field[2:], uint16(5+len(name)))
+	field[4] = 1 // Version
+	binary.LittleEndian.PutUint32(field[5:], crc32.ChecksumIEEE([]byte(name)))
+	return append(field, name...)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// isASCII reports whether s holds only ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// zipOwner returns the owner recorded in the extra fields of a zip entry, or nil if there is none.
+func zipOwner(extra []byte) *fileOwnership {
+	for len(extra) >= 4 {
+		id, size := binary.LittleEndian.Uint16(extra), int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			return nil
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+		if id != unixExtraID || size < 3 || field[0] != 1 {
+			continue
+		}
+		uid, rest, ok := readExtraID(field[1:])
+		if !ok {
+			return nil
+		}
+		gid, _, ok := readExtraID(rest)
+		if !ok {
+			return nil
+		}
+		return &fileOwnership{UID: uid, GID: gid}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// readExtraID reads a size-prefixed little-endian ID of a "ux" extra field.
+func readExtraID(b []byte) (int, []byte, bool) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return 0, nil, false
+	}
+	var id uint64
+	for i := int(b[0]); i > 0; i-- {
+		id = id<<8 | uint64(b[i])
+	}
+	return int(id), b[1+int(b[0]):], true
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// restoreMetadata applies the mode, modification time and, when running as root on Unix, the owner of a
+// backed up file to its restored copy.
+func restoreMetadata(target string, mode fs.FileMode, modTime time.Time, owner *fileOwnership) error {
+	if owner != nil {
+		if err := chownFile(target, *owner); err != nil {
+			return err
+		}
+	}
+	if mode.Perm() != 0 {
+		if err := os.Chmod(target, mode.Perm()|mode&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky)); err != nil {
+			return err
+		}
+	}
+	if !modTime.IsZero() {
+		return os.Chtimes(target, modTime, modTime)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// recordAttrs adds the extended attributes or ACL of a file to its manifest entry when --xattrs is set.
+// Failures are only logged, so a file is never left out of a backup for its attributes.
+func recordAttrs(path string, entry *manifestEntry) {
+	if !captureXattrs {
+		return
+	}
+	if err := captureAttrs(path, entry); err != nil {
+		slog.Warn("Failed to read extended attributes", "path", path, "error", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics exposed on /metrics with --metrics-listen. They are kept up to date whether or not the
+// endpoint is enabled.
+var (
+	eventsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "foldermon_events_received_total",
+		Help: "Filesystem events received, by watch folder and operation.",
+	}, []string{"watch", "op"})
+	backupRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "foldermon_backups_total",
+		Help: "Backup runs, by watch folder and result (success, failure, aborted).",
+	}, []string{"watch", "result"})
+	lastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "foldermon_last_success_timestamp_seconds",
+		Help: "Unix time of the last successful backup run, by watch folder.",
+	}, []string{"watch"})
+	bytesArchived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "foldermon_archived_bytes_total",
+		Help: "Bytes written to archives, or new chunk data for dedup snapshots, by watch folder.",
+	}, []string{"watch"})
+	archiveDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "foldermon_archive_duration_seconds",
+		Help:    "Time taken to write an archive.",
+		Buckets: prometheus.ExponentialBuckets(0.25, 4, 10), // 0.25s to about 18h
+	})
+	watcherFallback = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "foldermon_watcher_fallback",
+		Help: "1 for watch folders polled because the system ran out of native filesystem watches.",
+	}, []string{"watch"})
+	consecutiveFailures = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "foldermon_consecutive_failures",
+		Help: "Backup runs that failed or were aborted in a row, by watch folder; 0 after a success.",
+	}, []string{"watch"})
+	freeBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "foldermon_backup_free_bytes",
+		Help: "Free space in the backup folder before the last backup, by watch folder.",
+	}, []string{"watch"})
+	anomalies = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "foldermon_anomalies_total",
+		Help: "Possible ransomware activity detected, by watch folder and kind (burst, extensions or entropy).",
+	}, []string{"watch", "kind"})
+	archiveFiles = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "foldermon_archive_files",
+		Help:    "Files stored per archive.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10), // 1 to about 260k
+	})
+)
+
+func init() {
+	prometheus.MustRegister(eventsReceived, backupRuns, lastSuccess, bytesArchived, archiveDuration, archiveFiles, watcherFallback,
+		consecutiveFailures, freeBytes, anomalies)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// serveMetrics serves /metrics, /status and /events on addr in the background until ctx ends, plus the control API
+// if an API token is set.
+func serveMetrics(ctx context.Context, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/status", handleStatus)
+	mux.HandleFunc("/events", handleEvents)
+	if apiToken != "" {
+		registerAPI(mux, apiToken)
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server failed", "error", err)
+		}
+	}()
+	slog.Info("Serving metrics", "address", listener.Addr().String())
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// observeRun counts the outcome of a backup run of a watch folder.
+func observeRun(watchFolder string, err error) {
+	switch {
+	case err == nil:
+		backupRuns.WithLabelValues(watchFolder, "success").Inc()
+		lastSuccess.WithLabelValues(watchFolder).SetToCurrentTime()
+	case errors.Is(err, context.DeadlineExceeded):
+		backupRuns.WithLabelValues(watchFolder, "aborted").Inc()
+	default:
+		backupRuns.WithLabelValues(watchFolder, "failure").Inc()
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Copy modes, set by --copy: files are copied as they are instead of being archived.
+const (
+	copyOff         = ""
+	copyTimestamped = "timestamped" // New and changed files into a backup_<timestamp> folder per run
+	copyMirror      = "mirror"      // Every file into one folder kept identical to the watch folder
+	copySnapshot    = "snapshot"    // Every file into a backup_<timestamp> folder per run, unchanged ones hard-linked
+)
+
+var copyMode string
+
+const mirrorFolderName = "mirror" // Folder in the backup folder that --copy mirror keeps up to date
+
+// ------------------------------------------------------------------------------------------------------------
+// copyAndMirror copies the files of the watch folder into a folder in the backup folder and returns its
+// path, or "" if there was nothing to copy. With --copy timestamped each run creates backup_<timestamp>,
+// holding the files new or changed since the last run and a MANIFEST.json; with --copy mirror every run
+// updates the mirror folder, copying files that differ and removing files no longer in the watch folder,
+// and --copy sync does the same for --sync-to, removing files only with --sync-delete;
+// with --copy snapshot each run creates a complete backup_<timestamp>, where files unchanged since the
+// previous snapshot are hard links to it. Each file is written under a temporary name and renamed once
+// complete, but the folder of a timestamped copy or snapshot is filled in place, so it is removed again
+// when the run fails or finds nothing to copy.
+func copyAndMirror(ctx context.Context, watchFolder, backupFolder string, exclude []string) (string, error) {
+	if err := archiveSlots.acquire(ctx); err != nil {
+		return "", err
+	}
+	defer archiveSlots.release()
+
+	state, err := loadState(backupFolder)
+	if err != nil {
+		return "", annotate(stagePrepare, backupFolder, err)
+	}
+	m := &manifest{Created: time.Now(), Type: archiveFull}
+	var compareTo map[string]fileState
+	var prev string                        // Previous snapshot, with --copy snapshot
+	var prevFiles map[string]manifestEntry // Files in it
+	complete := false                      // The timestamped copy or snapshot is to be kept
+	dest := filepath.Join(backupFolder, mirrorFolderName)
+	if copyMode == copySync {
+		dest = syncTo
+	}
+	if copyMode == copyTimestamped || copyMode == copySnapshot {
+		folder, err := archiveFolder(backupFolder, m.Created)
+		if err != nil {
+			return "", annotate(stagePrepare, folder, err)
+		}
+		if dest, err = createCopyFolder(filepath.Join(folder, archiveStem(watchFolder, m.Created))); err != nil {
+			return "", annotate(stagePrepare, dest, err)
+		}
+		defer func() {
+			if !complete {
+				os.RemoveAll(dest)
+			}
+		}()
+		switch {
+		case !archiveExists(backupFolder, state.LastArchive):
+		case copyMode == copyTimestamped:
+			m.Type, m.Base, compareTo = archiveIncremental, baseReference(backupFolder, dest, state.LastArchive), state.Files
+		default:
+			prev = filepath.Join(backupFolder, state.LastArchive)
+			if prevFiles, err = snapshotFiles(prev); err != nil {
+				slog.Warn("Cannot read the previous snapshot, copying every file", "path", prev, "error", err)
+			}
+		}
+	}
+	slog.Info("Copying files", "path", watchFolder, "destination", dest)
+
+	// Mirrors are compared with the copies themselves, so a damaged or removed copy is replaced
+	changed := func(relPath string, info os.FileInfo) bool {
+		if copyMode == copyMirror || copyMode == copySync {
+			copied, err := os.Lstat(filepath.Join(dest, filepath.FromSlash(relPath)))
+			return err != nil || copied.Size() != info.Size() || !copied.ModTime().Equal(info.ModTime())
+		}
+		return m.Type == archiveFull || changedSince(compareTo, relPath, info)
+	}
+
+	if err := walkSlots.acquire(ctx); err != nil {
+		return "", err
+	}
+	progress := progressFrom(ctx)
+	if progress == nil {
+		ctx, progress = withProgress(ctx)
+	}
+	progress.setTotals(countBackupFiles(watchFolder, exclude, changed))
+	stopProgress := reportProgress(watchFolder, progress)
+	deferral := newFileDeferral(ctx, watchFolder)
+	current := make(map[string]fileState)
+	present := make(map[string]bool) // Every file in the watch folder, deferred and skipped ones included
+	linked := 0
+	err = walkFolder(watchFolder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return annotate(stageWalk, path, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if path == filepath.Join(watchFolder, pauseFileName) {
+			return nil
+		}
+		relPath, err := filepath.Rel(watchFolder, path)
+		if err != nil {
+			return annotate(stageWalk, path, err)
+		}
+		relPath = filepath.ToSlash(relPath)
+		if excluded(exclude, relPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		present[relPath] = true
+		if deferral.skip(path, info) {
+			if prev, ok := state.Files[relPath]; ok {
+				current[relPath] = prev
+			}
+			return nil
+		}
+		current[relPath] = fileState{Size: info.Size(), ModTime: info.ModTime()}
+		if !changed(relPath, info) || skipOversized(&m.Skipped, path, relPath, info) {
+			return nil
+		}
+		target := filepath.Join(dest, filepath.FromSlash(relPath))
+		if entry, ok := prevFiles[relPath]; ok && linkUnchanged(prev, target, entry, info) {
+			m.Files = append(m.Files, entry)
+			progress.fileDone(info.Size())
+			linked++
+			return nil
+		}
+		if skip, err := skipInfected(ctx, &m.Skipped, path, relPath, info); skip || err != nil {
+			return err
+		}
+		if changedSince(state.Files, relPath, info) {
+			anomalyFrom(ctx).scan(path)
+		}
+		return copyFile(ctx, m, path, relPath, target, info)
+	})
+	stopProgress()
+	walkSlots.release()
+	if err == nil && (copyMode == copyMirror || copyMode == copySync && syncDelete) {
+		err = pruneMirror(dest, present)
+	}
+	if err != nil {
+		slog.Error("Error copying files", "error", err)
+		return "", err
+	}
+
+	// Unreadable and infected files are retried once changed, as with archives
+	for _, s := range m.Skipped {
+		if !strings.HasPrefix(s.Reason, unreadableReason) && !strings.HasPrefix(s.Reason, infectedReason) {
+			continue
+		}
+		if prev, ok := state.Files[s.Path]; ok {
+			current[s.Path] = prev
+		} else {
+			delete(current, s.Path)
+		}
+	}
+	m.Deleted = deletionsSince(state.PendingDeletions, state.Files, current)
+	if len(m.Files) == linked && len(m.Deleted) == 0 && (copyMode == copyMirror || copyMode == copySync || m.Type != archiveFull || prevFiles != nil) {
+		log.Println("No changes since the last backup, nothing copied")
+		return "", nil
+	}
+	if copyMode == copyTimestamped || copyMode == copySnapshot {
+		if err := writeFolderManifest(dest, m); err != nil {
+			return "", annotate(stageManifest, dest, err)
+		}
+	}
+	complete = true
+	archiveFinished(watchFolder, dest, m, folderSize(m))
+
+	if err := recordBackup(backupFolder, archiveName(backupFolder, dest), m, current, anomalyFrom(ctx).suspect(watchFolder)); err != nil {
+		slog.Warn("Failed to record backed up files", "error", err)
+	}
+	if err := catalogArchive(backupFolder, dest, m); err != nil {
+		slog.Warn("Failed to update catalog", "error", err)
+	}
+	return dest, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// copyFile copies a file or symbolic link to target, keeping its permissions, owner and modification time,
+// and records it in the manifest.
+func copyFile(ctx context.Context, m *manifest, path, relPath, target string, info os.FileInfo) error {
+	defer progressFrom(ctx).fileDone(info.Size())
+	if info.Mode()&os.ModeSymlink != 0 {
+		linkTarget, err := os.Readlink(path)
+		if err != nil {
+			return annotate(stageRead, path, err)
+		}
+		if err := restoreLink(linkTarget, target); err != nil {
+			return annotate(stageCompress, target, err)
+		}
+		sum := sha256.Sum256([]byte(linkTarget))
+		addedToZip(m, path, relPath, info, int64(len(linkTarget)), sum[:], nil)
+		return nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		if skipUnreadable(&m.Skipped, path, relPath, info, err) {
+			return nil
+		}
+		return annotate(stageRead, path, err)
+	}
+	defer src.Close()
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return annotate(stageCompress, target, err)
+	}
+	dst, err := os.Create(target + ".tmp")
+	if err != nil {
+		return annotate(stageCompress, target, err)
+	}
+	hash := sha256.New()
+	size, err := copyData(io.MultiWriter(dst, hash), contextReader{ctx, src})
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(target+".tmp", target)
+	}
+	if err != nil {
+		os.Remove(target + ".tmp")
+		return annotate(stageCompress, target, err)
+	}
+
+	owner := ownerOf(info)
+	if err := restoreMetadata(target, info.Mode(), info.ModTime(), owner); err != nil {
+		slog.Warn("Failed to copy file attributes", "path", target, "error", err)
+	}
+	addedToZip(m, path, relPath, info, size, hash.Sum(nil), owner)
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// linkUnchanged hard-links a file unchanged since the previous snapshot from there to target, and reports
+// whether it did. Files that cannot be linked, e.g. with the backup folder on a file system without hard
+// links, are copied instead.
+func linkUnchanged(prev, target string, entry manifestEntry, info os.FileInfo) bool {
+	if !info.Mode().IsRegular() || entry.Mode != info.Mode() || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+		return false
+	}
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return false
+	}
+	if err := os.Link(filepath.Join(prev, filepath.FromSlash(entry.Path)), target); err != nil {
+		slog.Debug("Cannot hard-link, copying", "path", target, "error", err)
+		return false
+	}
+	return true
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// snapshotFiles reads the MANIFEST.json of a snapshot folder and returns its files by path. A previous
+// backup that is not a folder, e.g. from before --copy snapshot was set, has none.
+func snapshotFiles(folder string) (map[string]manifestEntry, error) {
+	if info, err := os.Stat(folder); err != nil || !info.IsDir() {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(folder, manifestName))
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	files := make(map[string]manifestEntry, len(m.Files))
+	for _, entry := range m.Files {
+		files[entry.Path] = entry
+	}
+	return files, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// pruneMirror removes the files of the mirror folder that are no longer in the watch folder.
+func pruneMirror(mirror string, present map[string]bool) error {
+	return filepath.Walk(mirror, func(path string, info os.FileInfo, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(mirror, path)
+		if err != nil {
+			return err
+		}
+		if present[filepath.ToSlash(relPath)] {
+			return nil
+		}
+		log.Printf("Removed from mirror: %s\n", path)
+		return os.Remove(path)
+	})
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// writeFolderManifest writes the manifest of a timestamped copy or snapshot as MANIFEST.json in its folder.
+func writeFolderManifest(folder string, m *manifest) error {
+	if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(folder, manifestName), append(data, '\n'), 0644)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// folderSize returns the bytes copied in a run, as recorded in its manifest.
+func folderSize(m *manifest) int64 {
+	var size int64
+	for _, entry := range m.Files {
+		size += entry.Size
+	}
+	return size
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// monitor watches one folder and backs it up to its backup folder. Each monitor runs its own loop, so
+// adding or removing a watch on config reload leaves the others and their state alone.
+type monitor struct {
+	watchFolder  string
+	backupFolder string
+	exclude      []string
+	profile      *watchProfile    // Settings the watch overrides in the config file
+	triggers     fsnotify.Op      // Event ops that start a backup
+	minInterval  time.Duration    // Shortest time between backups, from --min-interval or the watch's config
+	blackout     []blackoutWindow // When backups are deferred, from --blackout or the watch's config
+	watcher      Watcher
+	reload       chan watchSettings // New settings from a config reload
+	stop         chan struct{}      // Closed when the watch is removed from the config
+	control      chan string        // Requests from the control API, see controlBackup
+
+	mu     sync.Mutex
+	status watchStatus // Reported on /status, guarded by mu
+
+	history  []fileEvent      // File events not yet written to the catalog
+	progress *progressTracker // Of the backup running, guarded by mu
+}
+
+// Requests a monitor accepts on its control channel.
+const (
+	controlBackup = "backup" // Back up now, regardless of --min-interval
+	controlPause  = "pause"  // Suspend archiving until resumed, like SIGUSR1
+	controlResume = "resume" // Resume archiving, like SIGUSR2
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// newMonitor starts watching a folder, with the poll watcher if the watch sets a poll interval and neither
+// --watcher nor --poll-interval is given, and the --watcher backend otherwise. If the system is out of native watches, it polls instead of failing.
+// Call run to process its events.
+func newMonitor(w watchConfig, settings watchSettings) (*monitor, error) {
+	backend, interval := watcherBackend, pollInterval
+	if w.Poll != "" && !explicit("watcher", "poll-interval") {
+		backend = watcherPoll
+		interval, _ = time.ParseDuration(w.Poll)
+	}
+	var fallback string
+	watcher, err := openWatcher(backend, interval, w.Watch)
+	if err != nil && backend == watcherNative && watchLimitReached(err) {
+		slog.Warn("ALERT: out of filesystem watches, polling the folder instead", "event", "watch_limit", "path", w.Watch,
+			"poll_interval", interval.String(), "error", err, "hint", watchLimitHint)
+		backend, fallback = watcherPoll, err.Error()
+		watcherFallback.WithLabelValues(w.Watch).Set(1)
+		watcher, err = openWatcher(backend, interval, w.Watch)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &monitor{
+		watchFolder:  w.Watch,
+		backupFolder: w.Backup,
+		exclude:      settings.exclude,
+		profile:      settings.profile,
+		triggers:     settings.triggers,
+		minInterval:  settings.minInterval,
+		blackout:     settings.blackout,
+		watcher:      watcher,
+		reload:       make(chan watchSettings, 1),
+		stop:         make(chan struct{}),
+		control:      make(chan string, 1),
+		status:       watchStatus{Watch: w.Watch, Backup: w.Backup, Watcher: backend, WatcherFallback: fallback},
+	}, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// setSettings hands new settings to the monitor loop, replacing any it has not picked up yet.
+func (mon *monitor) setSettings(settings watchSettings) {
+	select {
+	case <-mon.reload:
+	default:
+	}
+	mon.reload <- settings
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// retryBackoff returns how long to wait before retrying a backup that failed after the given number of
+// earlier failed attempts: --retry-delay, doubling with every attempt up to maxRetryDelay.
+func retryBackoff(failed int) time.Duration {
+	delay := retryDelay
+	for i := 0; i < failed && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// send hands a control request to the monitor loop. It reports false if an earlier request has not been
+// picked up yet.
+func (mon *monitor) send(request string) bool {
+	select {
+	case mon.control <- request:
+		return true
+	default:
+		return false
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// flushHistory writes the collected file events to the catalog.
+func (mon *monitor) flushHistory() {
+	if len(mon.history) == 0 {
+		return
+	}
+	if err := recordEvents(mon.watchFolder, mon.backupFolder, mon.history); err != nil {
+		slog.Warn("Failed to record file events", "error", err)
+	}
+	mon.history = nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// run is the monitor loop. It returns when ctx ends, the watch is stopped or the watcher fails; a backup
+// in progress is always finished first.
+func (mon *monitor) run(ctx context.Context) {
+	defer mon.watcher.Close()
+	defer mon.flushHistory()
+	watchFolder, backupFolder := mon.watchFolder, mon.backupFolder
+
+	running.Lock()
+	running.monitors[mon] = true
+	running.Unlock()
+	defer func() {
+		running.Lock()
+		delete(running.monitors, mon)
+		running.Unlock()
+		watcherFallback.DeleteLabelValues(watchFolder)
+		consecutiveFailures.DeleteLabelValues(watchFolder)
+	}()
+
+	// Pause state, see pauseFileName
+	var (
+		pending      bool             // A backup was requested while paused
+		pauseTimeout <-chan time.Time // Fires when the pause file has been present for maxPause
+		pauseExpired bool             // maxPause elapsed, ignore the pause file until it is removed
+		retry        <-chan time.Time // Fires when a failed or aborted backup should run again
+		retries      int              // Consecutive failed or aborted attempts
+		lastBackup   time.Time        // Start of the last backup, for minInterval
+		throttled    <-chan time.Time // Fires when minInterval has passed and a deferred backup should run
+		blackedOut   <-chan time.Time // Fires when the blackout window deferring a backup ends
+		interval     <-chan time.Time // Fires when no backup has run for maxInterval
+		held         bool             // Paused by SIGUSR1 until SIGUSR2
+		followUp     <-chan time.Time // Fires when files deferred by the last backup should be backed up
+		job          *pendingJob      // Outstanding backup, persisted in the backup folder
+		anomalies    = newAnomalyDetector()
+		suspect      string                  // Anomaly seen in the file events since the last backup
+		syncing      = make(map[string]bool) // Paths changed since they were last synced, with --copy sync
+		syncDue      <-chan time.Time        // Fires when the changed paths should be synced
+	)
+	pauseSignal, resumeSignal := make(chan os.Signal, 1), make(chan os.Signal, 1)
+	notifyPauseSignals(pauseSignal, resumeSignal)
+	defer signal.Stop(pauseSignal)
+	defer signal.Stop(resumeSignal)
+	if maxInterval > 0 {
+		interval = time.After(maxInterval)
+	}
+
+	// saveJob and clearJob persist the outstanding backup, so it survives a restart
+	saveJob := func() {
+		if err := saveJob(backupFolder, job); err != nil {
+			slog.Warn("Failed to write backup queue", "error", err)
+		}
+	}
+	clearJob := func() {
+		job = nil
+		if err := clearJob(backupFolder); err != nil {
+			slog.Warn("Failed to clear backup queue", "error", err)
+		}
+	}
+
+	backup := func() {
+		// Wait to ensure file is completely written
+		select {
+		case <-time.After(1 * time.Second):
+		case <-ctx.Done():
+			return
+		}
+
+		lastBackup = time.Now()
+		if maxInterval > 0 {
+			interval = time.After(maxInterval)
+		}
+		mon.flushHistory()
+		followUp = nil
+
+		runCtx, cancel := ctx, context.CancelFunc(func() {})
+		if maxDuration > 0 {
+			runCtx, cancel = context.WithTimeout(ctx, maxDuration)
+		}
+		defer cancel()
+		runCtx, deferred := withDeferred(runCtx)
+		runCtx, progress := withProgress(runCtx)
+		runCtx, suspect = withAnomaly(runCtx, suspect), ""
+		runCtx = withProfile(runCtx, mon.profile)
+
+		mon.updateStatus(func(s *watchStatus) {
+			s.BackingUp = true
+			mon.progress = progress
+		})
+		_, err := runBackup(runCtx, watchFolder, backupFolder, mon.exclude)
+		mon.finishStatus(err)
+		if n, wait := deferred.pending(); n > 0 && err == nil && !dryRun {
+			wait = max(wait, time.Second).Round(time.Second)
+			log.Printf("%d files not ready yet, backing them up in %s\n", n, wait)
+			followUp = time.After(wait)
+		}
+		if dryRun {
+			if err != nil {
+				slog.Error("Dry run failed", "error", err)
+			}
+			return
+		}
+		if errors.Is(err, context.Canceled) {
+			log.Println("Backup canceled")
+			return
+		}
+		var retryIn time.Duration
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) && retries < retryAttempts {
+			retryIn = retryBackoff(retries)
+		}
+		var be *backupError
+		if errors.As(err, &be) {
+			be.Retries = retries
+			attrs := append([]any{"event", "backup_failed", "watch", watchFolder, "error", err}, be.attrs()...)
+			if retryIn > 0 {
+				attrs = append(attrs, "retry_in", retryIn.String())
+			}
+			slog.Error("ALERT: backup failed", attrs...)
+		}
+		recordRun(backupFolder, err)
+		observeRun(watchFolder, err)
+		if err == nil {
+			retries, retry = 0, nil
+			consecutiveFailures.WithLabelValues(watchFolder).Set(0)
+			clearJob()
+			return
+		}
+		retries++
+		consecutiveFailures.WithLabelValues(watchFolder).Set(float64(retries))
+		job.Attempts = retries
+		saveJob()
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			slog.Warn("ALERT: backup aborted after exceeding the maximum duration", "event", "backup_aborted", "path", watchFolder,
+				"max_duration", maxDuration.String(), "retry_in", abortRetryDelay.String())
+			retry = time.After(abortRetryDelay)
+		case retryIn > 0:
+			log.Printf("Backup failed, retrying in %s (attempt %d of %d)\n", retryIn, retries+1, retryAttempts+1)
+			retry = time.After(retryIn)
+		default:
+			slog.Error("ALERT: backup failed after all retries, waiting for the next change", "event", "backup_retries_exhausted",
+				"path", watchFolder, "attempts", retries, "error", err)
+			// The next change starts a new sequence of attempts, with the full backoff
+			retries = 0
+			clearJob()
+		}
+	}
+
+	// trigger runs a backup, or defers it while archiving is paused, until a blackout window ends or until
+	// minInterval has passed since the last one
+	trigger := func() {
+		if job == nil && !dryRun {
+			job = &pendingJob{Watch: watchFolder, Triggered: time.Now(), Attempts: retries}
+			saveJob()
+		}
+		if held {
+			log.Println("Archiving paused until resumed, backup deferred")
+			pending = true
+			return
+		}
+		if !pauseExpired && isPaused(watchFolder) {
+			log.Println("Archiving paused, backup deferred")
+			pending = true
+			if pauseTimeout == nil {
+				pauseTimeout = time.After(maxPause)
+			}
+			return
+		}
+		if end := blackoutEnd(mon.blackout, time.Now()); !end.IsZero() {
+			if blackedOut == nil {
+				log.Printf("Blackout window, backup deferred until %s\n", end.Format(time.DateTime))
+				blackedOut = time.After(time.Until(end))
+			}
+			return
+		}
+		if wait := mon.minInterval - time.Since(lastBackup); wait > 0 {
+			if throttled == nil {
+				log.Printf("Last backup less than %s ago, backup deferred by %s\n", mon.minInterval, wait.Round(time.Second))
+				throttled = time.After(wait)
+			}
+			return
+		}
+		backup()
+	}
+
+	// hold and release suspend and resume archiving on request, by signal or through the control API
+	hold := func(reason string) {
+		if !held {
+			log.Printf("%s, archiving suspended until resumed\n", reason)
+			held = true
+		}
+	}
+	release := func(reason string) {
+		if held {
+			log.Printf("%s, archiving resumed\n", reason)
+			held = false
+			if pending {
+				pending = false
+				trigger()
+			}
+		}
+	}
+
+	// Cover files that appeared while the monitor was down, and finish a backup left outstanding by the
+	// last run
+	queued, err := loadJob(backupFolder)
+	if err != nil {
+		slog.Warn("Failed to read backup queue", "error", err)
+	}
+	if queued != nil && queued.Watch == watchFolder && !dryRun {
+		log.Printf("Resuming backup triggered at %s (%d failed attempts)\n", queued.Triggered.Local().Format(time.DateTime), queued.Attempts)
+		job, retries = queued, queued.Attempts
+		trigger()
+	} else if backupOnStart || copyMode == copySync {
+		log.Println("Backing up on start")
+		trigger()
+	}
+
+	// Monitor loop
+	for {
+		mon.updateStatus(func(s *watchStatus) {
+			s.Queued = pending || throttled != nil || blackedOut != nil || retry != nil || followUp != nil
+			s.Paused = held || pauseTimeout != nil
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-mon.stop:
+			log.Printf("Stopped watching %s\n", watchFolder)
+			return
+
+		case settings := <-mon.reload:
+			mon.exclude, mon.profile = settings.exclude, settings.profile
+			mon.triggers, mon.minInterval, mon.blackout = settings.triggers, settings.minInterval, settings.blackout
+
+		case event, ok := <-mon.watcher.Events():
+			if !ok {
+				return
+			}
+
+			eventsReceived.WithLabelValues(watchFolder, event.Op.String()).Inc()
+			mon.updateStatus(func(s *watchStatus) {
+				now := time.Now()
+				s.LastEvent = &now
+			})
+
+			if filepath.Base(event.Name) == pauseFileName {
+				if event.Op&fsnotify.Create == fsnotify.Create {
+					log.Printf("Pause file detected, archiving suspended for at most %s\n", maxPause)
+					pauseTimeout = time.After(maxPause)
+				} else if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+					log.Println("Pause file removed, archiving resumed")
+					pauseTimeout, pauseExpired = nil, false
+					if pending {
+						pending = false
+						trigger()
+					}
+				}
+				continue
+			}
+
+			relPath, err := filepath.Rel(watchFolder, event.Name)
+			if err == nil && excluded(mon.exclude, filepath.ToSlash(relPath)) {
+				continue
+			}
+			if err == nil && !dryRun {
+				mon.history = append(mon.history, fileEvent{Time: time.Now(), Op: event.Op.String(), Path: filepath.ToSlash(relPath)})
+				if len(mon.history) >= historyBatch {
+					mon.flushHistory()
+				}
+			}
+
+			if kind, reason := anomalies.observe(event); reason != "" {
+				anomalyDetected(watchFolder, kind, reason)
+				suspect = reason
+			}
+
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				if dryRun {
+					log.Printf("Dry run: would record deletion of %s\n", event.Name)
+				} else {
+					recordDeletion(watchFolder, backupFolder, event.Name)
+				}
+			}
+
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				slog.Info("Detected new file", "event", "file_created", "path", event.Name)
+			} else if event.Op&mon.triggers != 0 {
+				slog.Debug("Detected change", "event", "file_changed", "path", event.Name, "op", event.Op.String())
+			}
+			// With --copy sync every change is synced on its own instead of starting a backup
+			if copyMode == copySync {
+				if err == nil && event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename) != 0 {
+					syncing[filepath.ToSlash(relPath)] = true
+					if syncDue == nil {
+						syncDue = time.After(syncDelay)
+					}
+				}
+			} else if event.Op&mon.triggers != 0 {
+				trigger()
+			}
+
+		case <-syncDue:
+			syncDue = nil
+			if held || !pauseExpired && isPaused(watchFolder) || dryRun {
+				clear(syncing)
+				trigger()
+				continue
+			}
+			if err := syncChanges(ctx, watchFolder, mon.exclude, syncing); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Warn("Sync failed, syncing the whole folder", "path", watchFolder, "error", err)
+				trigger()
+			}
+			clear(syncing)
+
+		case <-pauseTimeout:
+			log.Printf("Pause file present for more than %s, archiving resumed\n", maxPause)
+			pauseTimeout, pauseExpired = nil, true
+			if pending {
+				pending = false
+				trigger()
+			}
+
+		case <-pauseSignal:
+			hold("Pause signal received")
+
+		case <-resumeSignal:
+			release("Resume signal received")
+
+		case request := <-mon.control:
+			switch request {
+			case controlBackup:
+				log.Println("Backup requested")
+				lastBackup, throttled = time.Time{}, nil
+				trigger()
+			case controlPause:
+				hold("Pause requested")
+			case controlResume:
+				release("Resume requested")
+			}
+
+		case <-throttled:
+			throttled = nil
+			trigger()
+
+		case <-blackedOut:
+			blackedOut = nil
+			log.Println("Blackout window ended, running the deferred backup")
+			trigger()
+
+		case <-interval:
+			log.Printf("No backup for %s, backing up\n", maxInterval)
+			trigger()
+
+		case <-retry:
+			retry = nil
+			log.Println("Retrying backup")
+			trigger()
+
+		case <-followUp:
+			followUp = nil
+			log.Println("Backing up files deferred by the last backup")
+			trigger()
+
+		case err, ok := <-mon.watcher.Errors():
+			if !ok {
+				return
+			}
+			slog.Warn("Watcher error", "event", "watcher_error", "path", watchFolder, "error", err)
+			// The kernel dropped events, so changes may have been missed; a backup picks them up
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				log.Println("Events were lost, backing up to catch up")
+				trigger()
+			}
+		}
+	}
+}
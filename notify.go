@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Values of --notify.
+const (
+	notifyOff      = "off"
+	notifyFailures = "failures" // Failed and aborted backups
+	notifyAll      = "all"      // Every backup outcome
+)
+
+// pendingNotifications tracks notifications still being sent, so they can finish before foldermon exits.
+var pendingNotifications sync.WaitGroup
+
+// backupNotice is a backup event taken from a backup_started, backup_finished, backup_failed,
+// backup_aborted or backup_report log record, for notifying someone about it.
+type backupNotice struct {
+	Event   string
+	Time    time.Time
+	Message string
+	Attrs   map[string]string // Record attributes, e.g. path, archive, files, bytes, duration, error
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// noticeFromRecord returns the backup event a log record reports, if it reports one.
+func noticeFromRecord(r slog.Record, attrs []slog.Attr) (backupNotice, bool) {
+	n := backupNotice{Time: r.Time, Message: r.Message, Attrs: make(map[string]string)}
+	add := func(a slog.Attr) bool {
+		n.Attrs[a.Key] = a.Value.Resolve().String()
+		return true
+	}
+	for _, a := range attrs {
+		add(a)
+	}
+	r.Attrs(add)
+
+	switch n.Event = n.Attrs["event"]; n.Event {
+	case "backup_started", "backup_finished", "backup_failed", "backup_aborted", "backup_report":
+		return n, true
+	}
+	return n, false
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// outcome reports whether the notice is about a finished backup run rather than its start.
+func (n backupNotice) outcome() bool {
+	return n.Event != "backup_started"
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// failed reports whether the backup did not complete.
+func (n backupNotice) failed() bool {
+	return n.Event == "backup_failed" || n.Event == "backup_aborted"
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// watch returns the watch folder the notice is about.
+func (n backupNotice) watch() string {
+	if n.Event == "backup_failed" {
+		return n.Attrs["watch"]
+	}
+	return n.Attrs["path"]
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// summary returns a title and a one-line description of the outcome.
+func (n backupNotice) summary() (string, string) {
+	switch n.Event {
+	case "backup_started":
+		return "Backup started", fmt.Sprintf("%s to %s", n.watch(), n.Attrs["backup"])
+	case "backup_finished":
+		return "Backup finished", fmt.Sprintf("%s: %s files, %s bytes in %ss", n.watch(), n.Attrs["files"], n.Attrs["bytes"], n.Attrs["duration"])
+	case "backup_report":
+		return "Backup report", fmt.Sprintf("%s backups, %s failed, %s bytes, %s files skipped since %s",
+			n.Attrs["backups"], n.Attrs["failures"], n.Attrs["bytes"], n.Attrs["skipped"], n.Attrs["since"])
+	case "backup_aborted":
+		return "Backup aborted", fmt.Sprintf("%s: still running after %s, retrying in %s", n.watch(), n.Attrs["max_duration"], n.Attrs["retry_in"])
+	default:
+		if retryIn := n.Attrs["retry_in"]; retryIn != "" {
+			return "Backup failed", fmt.Sprintf("%s: %s, retrying in %s", n.watch(), n.Attrs["error"], retryIn)
+		}
+		return "Backup failed", fmt.Sprintf("%s: %s", n.watch(), n.Attrs["error"])
+	}
+}
+
+// desktopHandler is the slog sink showing backup outcomes as desktop notifications.
+type desktopHandler struct {
+	all   bool // Also notify about successful backups
+	attrs []slog.Attr
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newDesktopHandler returns the sink for --notify, or nil if notifications are off.
+func newDesktopHandler(mode string) (slog.Handler, error) {
+	switch mode {
+	case notifyOff:
+		return nil, nil
+	case notifyFailures, notifyAll:
+		return &desktopHandler{all: mode == notifyAll}, nil
+	}
+	return nil, fmt.Errorf("unknown --notify value %q (want %s, %s or %s)", mode, notifyOff, notifyFailures, notifyAll)
+}
+
+func (h *desktopHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (h *desktopHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *desktopHandler) WithGroup(name string) slog.Handler { return h }
+
+// ------------------------------------------------------------------------------------------------------------
+// Handle shows a notification for backup outcomes. It does not wait for the notification to be shown.
+func (h *desktopHandler) Handle(_ context.Context, r slog.Record) error {
+	n, ok := noticeFromRecord(r, h.attrs)
+	if !ok || !n.outcome() || (!h.all && !n.failed()) {
+		return nil
+	}
+	title, body := n.summary()
+	pendingNotifications.Add(1)
+	go func() {
+		defer pendingNotifications.Done()
+		if err := desktopNotify("foldermon: "+title, body, n.failed()); err != nil {
+			log.Println("Failed to show desktop notification:", err)
+		}
+	}()
+	return nil
+}
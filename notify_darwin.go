@@ -0,0 +1,14 @@
+package main
+
+import "os/exec"
+
+// ------------------------------------------------------------------------------------------------------------
+// desktopNotify shows a notification in Notification Center. The text is passed as script arguments, so
+// it needs no quoting.
+func desktopNotify(title, body string, urgent bool) error {
+	return exec.Command("osascript",
+		"-e", "on run argv",
+		"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+		"-e", "end run",
+		title, body).Run()
+}
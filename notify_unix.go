@@ -0,0 +1,15 @@
+//go:build !windows && !darwin
+
+package main
+
+import "os/exec"
+
+// ------------------------------------------------------------------------------------------------------------
+// desktopNotify shows a notification with notify-send, marked critical if urgent.
+func desktopNotify(title, body string, urgent bool) error {
+	urgency := "normal"
+	if urgent {
+		urgency = "critical"
+	}
+	return exec.Command("notify-send", "--app-name=foldermon", "--urgency="+urgency, title, body).Run()
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// toastScript shows a toast notification with the text in FOLDERMON_TITLE and FOLDERMON_BODY. It is
+// shown on behalf of PowerShell, which is registered as a notification sender on every Windows install.
+const toastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode($env:FOLDERMON_TITLE)) | Out-Null
+$text.Item(1).AppendChild($template.CreateTextNode($env:FOLDERMON_BODY)) | Out-Null
+$appId = '{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe'
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($appId).Show([Windows.UI.Notifications.ToastNotification]::new($template))
+`
+
+// ------------------------------------------------------------------------------------------------------------
+// desktopNotify shows a toast notification. The text is passed in the environment, so it needs no quoting.
+func desktopNotify(title, body string, urgent bool) error {
+	cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", toastScript)
+	cmd.Env = append(os.Environ(), "FOLDERMON_TITLE="+title, "FOLDERMON_BODY="+body)
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	return cmd.Run()
+}
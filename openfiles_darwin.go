@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// openWriters returns a function reporting whether a file below root is open for writing by another
+// process, according to lsof. Processes of other users are only visible when running as root. lsof names
+// files with symbolic links resolved, such as /tmp as /private/tmp, so paths are resolved before looking them
+// up.
+func openWriters(root string) func(path string) bool {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil
+	}
+	// -F an prints the access mode and name of every open file on lines of their own; lsof exits with 1
+	// when nothing is open, so only missing output counts as a failure
+	output, _ := exec.Command("lsof", "-F", "an", "+D", root).Output()
+	writers := make(map[string]bool)
+	writing := false
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "a"):
+			writing = strings.ContainsAny(line[1:], "wu")
+		case strings.HasPrefix(line, "n") && writing:
+			writers[line[1:]] = true
+		}
+	}
+
+	return func(path string) bool {
+		if len(writers) == 0 {
+			return false
+		}
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return false
+		}
+		resolved, err = filepath.Abs(resolved)
+		return err == nil && writers[resolved]
+	}
+}
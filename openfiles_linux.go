@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// openWriters returns a function reporting whether a file below root is open for writing by another
+// process. The file descriptors of all processes are read from /proc once; processes of other users are
+// only visible when running as root. /proc holds the paths with symbolic links resolved, so root and the
+// paths asked about are resolved too, for watch folders reached through a link or a bind-mount alias.
+func openWriters(root string) func(path string) bool {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil
+	}
+	writers := make(map[string]bool)
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	self := os.Getpid()
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue // Gone, or owned by another user
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(target, root+string(filepath.Separator)) {
+				continue
+			}
+			if fdWritable(filepath.Join("/proc", proc.Name(), "fdinfo", fd.Name())) {
+				writers[target] = true
+			}
+		}
+	}
+
+	return func(path string) bool {
+		if len(writers) == 0 {
+			return false
+		}
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return false
+		}
+		resolved, err = filepath.Abs(resolved)
+		return err == nil && writers[resolved]
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// fdWritable reports whether the flags in a /proc/<pid>/fdinfo file show the descriptor open for writing.
+func fdWritable(fdinfo string) bool {
+	file, err := os.Open(fdinfo)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "flags:"); ok {
+			flags, err := strconv.ParseUint(strings.TrimSpace(value), 8, 64)
+			return err == nil && flags&syscall.O_ACCMODE != syscall.O_RDONLY
+		}
+	}
+	return false
+}
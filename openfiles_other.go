@@ -0,0 +1,9 @@
+//go:build !linux && !windows && !darwin
+
+package main
+
+// ------------------------------------------------------------------------------------------------------------
+// openWriters cannot detect open files on this platform, so nothing is deferred.
+func openWriters(root string) func(path string) bool {
+	return nil
+}
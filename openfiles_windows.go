@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// openWriters returns a function reporting whether a file is open for writing by another process. A file
+// is considered in use if it cannot be opened while denying others write access.
+func openWriters(root string) func(path string) bool {
+	return func(path string) bool {
+		name, err := windows.UTF16PtrFromString(longPath(path))
+		if err != nil {
+			return false
+		}
+		handle, err := windows.CreateFile(name, windows.GENERIC_READ, windows.FILE_SHARE_READ, nil,
+			windows.OPEN_EXISTING, windows.FILE_ATTRIBUTE_NORMAL, 0)
+		if err != nil {
+			return errors.Is(err, windows.ERROR_SHARING_VIOLATION)
+		}
+		windows.CloseHandle(handle)
+		return false
+	}
+}
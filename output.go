@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Values of the --output flag of subcommands. JSON output is meant for scripts and dashboards: its field
+// names stay stable, times are RFC 3339 and sizes are in bytes.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// outputFlag adds --output to the flags of a subcommand.
+func outputFlag(fs *flag.FlagSet) *string {
+	return fs.String("output", outputText, "output format: text or json")
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// checkOutput rejects unknown --output formats.
+func checkOutput(format string) error {
+	if format != outputText && format != outputJSON {
+		return fmt.Errorf("unknown --output format %q (want %s or %s)", format, outputText, outputJSON)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// printJSON writes v to standard output as indented JSON.
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
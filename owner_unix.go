@@ -0,0 +1,28 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// ownerOf returns the owner of a file.
+func ownerOf(info os.FileInfo) *fileOwnership {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	return &fileOwnership{UID: int(stat.Uid), GID: int(stat.Gid)}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// chownFile gives a restored file its recorded owner. Only root can do that, so for anyone else the file
+// keeps belonging to whoever restores it.
+func chownFile(path string, owner fileOwnership) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	return os.Lchown(path, owner.UID, owner.GID)
+}
@@ -0,0 +1,15 @@
+package main
+
+import "os"
+
+// ------------------------------------------------------------------------------------------------------------
+// ownerOf returns nil, as Windows files have no numeric owner.
+func ownerOf(info os.FileInfo) *fileOwnership {
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// chownFile does nothing on Windows.
+func chownFile(path string, owner fileOwnership) error {
+	return nil
+}
@@ -0,0 +1,14 @@
+package main
+
+// Process priorities selectable with --priority.
+const (
+	priorityNormal = "normal"
+	priorityLow    = "low"  // nice 10 and the lowest best-effort I/O priority, below normal on Windows
+	priorityIdle   = "idle" // nice 19 and idle I/O, background mode on Windows: only use otherwise idle time
+)
+
+// Nice values of the priorities on Unix.
+const (
+	niceLow  = 10
+	niceIdle = 19
+)
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// I/O priorities for ioprio_set, see ioprio_set(2).
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// setPriority lowers the CPU and I/O priority of the process. Linux keeps both per thread, so every thread
+// is changed; threads started later inherit the priority of the thread starting them.
+func setPriority(priority string) error {
+	nice, ioprio := niceLow, ioprioClassBE<<ioprioClassShift|7
+	if priority == priorityIdle {
+		nice, ioprio = niceIdle, ioprioClassIdle<<ioprioClassShift
+	}
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if err := unix.Setpriority(unix.PRIO_PROCESS, tid, nice); err != nil {
+			return err
+		}
+		if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(ioprio)); errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
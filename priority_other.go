@@ -0,0 +1,16 @@
+//go:build !linux && !windows
+
+package main
+
+import "syscall"
+
+// ------------------------------------------------------------------------------------------------------------
+// setPriority lowers the CPU priority of the process. I/O priority is left alone, as there is no portable
+// way to lower it.
+func setPriority(priority string) error {
+	nice := niceLow
+	if priority == priorityIdle {
+		nice = niceIdle
+	}
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, nice)
+}
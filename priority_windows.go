@@ -0,0 +1,16 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// processModeBackgroundBegin lowers the CPU, I/O and memory priority of the process, see SetPriorityClass.
+const processModeBackgroundBegin = 0x00100000
+
+// ------------------------------------------------------------------------------------------------------------
+// setPriority lowers the priority class of the process. Background mode also lowers its I/O priority.
+func setPriority(priority string) error {
+	class := uint32(windows.BELOW_NORMAL_PRIORITY_CLASS)
+	if priority == priorityIdle {
+		class = processModeBackgroundBegin
+	}
+	return windows.SetPriorityClass(windows.CurrentProcess(), class)
+}
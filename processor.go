@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"plugin"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// processor acts on a finished archive, e.g. to copy, checksum or upload it. It returns the path of the
+// archive for the next processor, which differs from its input if the processor transformed it.
+type processor interface {
+	process(ctx context.Context, archive string) (string, error)
+}
+
+// processorConfig is an entry of the "processors" list in the config file. Which fields apply depends on
+// the type:
+//
+//	{"type": "checksum"}                          write <archive>.sha256 next to the archive
+//	{"type": "sign", "key": "foldermon.key"}      write an Ed25519 signature to <archive>.sig
+//	{"type": "copy", "to": "/mnt/offsite"}        copy the archive into another folder
+//	{"type": "command", "run": "gpg ..."}         run a shell command with FOLDERMON_ARCHIVE set
+//	{"type": "plugin", "path": "upload.so"}       call the Process function of a Go plugin
+//	{"type": "repack", "format": "tar.xz"}        write the archive again as .tar.xz or .7z
+type processorConfig struct {
+	Type   string `json:"type"`
+	To     string `json:"to,omitempty"`
+	Run    string `json:"run,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+// processorTypes creates processors from their config. New built-in processors register here.
+var processorTypes = map[string]func(pc processorConfig) (processor, error){
+	"checksum": func(pc processorConfig) (processor, error) { return checksumProcessor{}, nil },
+	"sign":     newSignProcessor,
+	"copy":     newCopyProcessor,
+	"command":  newCommandProcessor,
+	"plugin":   newPluginProcessor,
+	"repack":   newRepackProcessor,
+}
+
+// processorChain is a list of processors an archive goes through, in config order.
+type processorChain struct {
+	processors []processor
+	types      []string
+}
+
+// pipeline is the chain of processors every finished archive goes through, unless its watch lists its own.
+// It is replaced on config reload.
+var pipeline struct {
+	sync.Mutex
+	processorChain
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newProcessorChain creates the processors listed in a config.
+func newProcessorChain(configs []processorConfig) (processorChain, error) {
+	var chain processorChain
+	for _, pc := range configs {
+		create, ok := processorTypes[pc.Type]
+		if !ok {
+			return chain, fmt.Errorf("unknown processor type %q", pc.Type)
+		}
+		p, err := create(pc)
+		if err != nil {
+			return chain, fmt.Errorf("%s processor: %w", pc.Type, err)
+		}
+		chain.processors, chain.types = append(chain.processors, p), append(chain.types, pc.Type)
+	}
+	return chain, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// setPipeline creates the processors listed in the config and makes them the current pipeline.
+func setPipeline(configs []processorConfig) error {
+	chain, err := newProcessorChain(configs)
+	if err != nil {
+		return err
+	}
+	pipeline.Lock()
+	pipeline.processorChain = chain
+	pipeline.Unlock()
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// processArchives passes each archive through the processors of its watch, or the pipeline, and returns
+// their final paths. It stops at the first processor that fails.
+func processArchives(ctx context.Context, archives []string) ([]string, error) {
+	pipeline.Lock()
+	chain := pipeline.processorChain
+	pipeline.Unlock()
+	if p := profileFrom(ctx); p != nil && p.processors != nil {
+		chain = *p.processors
+	}
+	processors, types := chain.processors, chain.types
+
+	processed := make([]string, len(archives))
+	for i, archive := range archives {
+		for j, p := range processors {
+			_, span := tracer.Start(ctx, "process", trace.WithAttributes(
+				attribute.String("foldermon.processor", types[j]), attribute.String("foldermon.archive", archive)))
+			next, err := p.process(ctx, archive)
+			endSpan(span, err)
+			if err != nil {
+				return processed[:i], annotate(stageProcess, archive, fmt.Errorf("%s processor: %w", types[j], err))
+			}
+			slog.Debug("Processed archive", "processor", types[j], "archive", archive, "result", next)
+			archive = next
+		}
+		processed[i] = archive
+	}
+	return processed, nil
+}
+
+// checksumProcessor writes the SHA-256 sum of the archive to <archive>.sha256, in the format read by
+// sha256sum -c.
+type checksumProcessor struct{}
+
+func (checksumProcessor) process(ctx context.Context, archive string) (string, error) {
+	sum, err := fileSHA256(archive)
+	if err != nil {
+		return "", err
+	}
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(archive))
+	return archive, os.WriteFile(archive+".sha256", []byte(line), 0644)
+}
+
+// copyProcessor copies the archive into another folder, e.g. a mounted offsite share, within --upload-limit.
+type copyProcessor struct {
+	to string
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newCopyProcessor creates a copy processor for the "to" folder.
+func newCopyProcessor(pc processorConfig) (processor, error) {
+	if pc.To == "" {
+		return nil, fmt.Errorf(`"to" is required`)
+	}
+	return copyProcessor{to: pc.To}, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// process copies the archive under a temporary name and renames it once complete, so the destination
+// never holds a partial copy. The archive passed on is the original.
+func (p copyProcessor) process(ctx context.Context, archive string) (string, error) {
+	if err := os.MkdirAll(p.to, os.ModePerm); err != nil {
+		return "", err
+	}
+	src, err := os.Open(archive)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dest := filepath.Join(p.to, filepath.Base(archive))
+	dst, err := os.Create(dest + ".tmp")
+	if err != nil {
+		return "", err
+	}
+	_, err = copyData(dst, &throttledReader{ctx: ctx, r: src})
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(dest+".tmp", dest)
+	}
+	if err != nil {
+		os.Remove(dest + ".tmp")
+		return "", err
+	}
+	return archive, nil
+}
+
+// commandProcessor runs a shell command for the archive, with its path in FOLDERMON_ARCHIVE.
+type commandProcessor struct {
+	run string
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newCommandProcessor creates a command processor running the "run" command.
+func newCommandProcessor(pc processorConfig) (processor, error) {
+	if pc.Run == "" {
+		return nil, fmt.Errorf(`"run" is required`)
+	}
+	return commandProcessor{run: pc.Run}, nil
+}
+
+func (p commandProcessor) process(ctx context.Context, archive string) (string, error) {
+	return archive, runHook(ctx, "processor", p.run, []string{"FOLDERMON_ARCHIVE=" + archive})
+}
+
+// pluginProcessor calls the Process function exported by a Go plugin:
+//
+//	func Process(ctx context.Context, archive string) (string, error)
+//
+// Process returns the path of the archive for the next processor, or "" to keep it unchanged. Plugins are
+// built with "go build -buildmode=plugin" using the same Go version as foldermon, and only load on Linux,
+// FreeBSD and macOS.
+type pluginProcessor struct {
+	fn func(ctx context.Context, archive string) (string, error)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newPluginProcessor loads the plugin at "path".
+func newPluginProcessor(pc processorConfig) (processor, error) {
+	if pc.Path == "" {
+		return nil, fmt.Errorf(`"path" is required`)
+	}
+	plug, err := plugin.Open(pc.Path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := plug.Lookup("Process")
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := sym.(func(context.Context, string) (string, error))
+	if !ok {
+		return nil, fmt.Errorf("%s: Process has type %T, want func(context.Context, string) (string, error)", pc.Path, sym)
+	}
+	return pluginProcessor{fn: fn}, nil
+}
+
+func (p pluginProcessor) process(ctx context.Context, archive string) (string, error) {
+	next, err := p.fn(ctx, archive)
+	if next == "" {
+		next = archive
+	}
+	return next, err
+}
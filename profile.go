@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Archive modes a watch can choose in the config file, instead of --incremental, --differential or --split.
+const (
+	modeFull         = "full"
+	modeIncremental  = "incremental"
+	modeDifferential = "differential"
+	modeSplit        = "split"
+)
+
+// watchProfile holds the settings a watch in the config file overrides for its own backups, so one daemon
+// can apply different policies to different folders. Unset fields fall back to the command line flags and
+// the top-level processors, and flags given on the command line or through the environment win over it.
+type watchProfile struct {
+	mode       string          // modeFull, modeIncremental, modeDifferential or modeSplit
+	preBackup  *string         // Pre-backup command, empty to run none
+	postBackup *string         // Post-backup command, empty to run none
+	processors *processorChain // Replaces the top-level processors
+	workers    int             // Files compressed at once, 0 for --workers
+}
+
+type profileKey struct{}
+
+// ------------------------------------------------------------------------------------------------------------
+// newProfile creates the profile of a watch from its config.
+func newProfile(w watchConfig) (*watchProfile, error) {
+	p := &watchProfile{mode: w.Mode, preBackup: w.PreBackup, postBackup: w.PostBackup, workers: w.Workers}
+	if w.Workers < 0 {
+		return nil, fmt.Errorf("workers must be positive, got %d", w.Workers)
+	}
+	switch w.Mode {
+	case "", modeFull, modeIncremental, modeDifferential, modeSplit:
+	default:
+		return nil, fmt.Errorf("unknown mode %q (want %s, %s, %s or %s)", w.Mode, modeFull, modeIncremental, modeDifferential, modeSplit)
+	}
+	if w.Mode != "" && (dedup || copyMode != copyOff) {
+		return nil, fmt.Errorf("mode %s cannot be combined with --dedup or --copy", w.Mode)
+	}
+	if w.Processors != nil {
+		chain, err := newProcessorChain(w.Processors)
+		if err != nil {
+			return nil, err
+		}
+		p.processors = &chain
+	}
+	return p, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// withProfile returns a context carrying the profile of the watch being backed up.
+func withProfile(ctx context.Context, p *watchProfile) context.Context {
+	return context.WithValue(ctx, profileKey{}, p)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// profileFrom returns the profile carried by ctx, or nil if the watch has none.
+func profileFrom(ctx context.Context) *watchProfile {
+	p, _ := ctx.Value(profileKey{}).(*watchProfile)
+	return p
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// archiveMode returns the archive mode of the watch, from its profile or the flags.
+func (p *watchProfile) archiveMode() string {
+	switch {
+	case p != nil && p.mode != "" && !explicit("incremental", "differential", "split"):
+		return p.mode
+	case splitArchives:
+		return modeSplit
+	case incremental:
+		return modeIncremental
+	case differential:
+		return modeDifferential
+	}
+	return modeFull
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// workerCount returns how many files of the watch are compressed at once, from its profile or --workers.
+func (p *watchProfile) workerCount() int {
+	if p != nil && p.workers > 0 && !explicit("workers") {
+		return p.workers
+	}
+	return compressWorkers
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// preBackupCommand returns the pre-backup command of the watch, from its profile or --pre-backup.
+func (p *watchProfile) preBackupCommand() string {
+	if p != nil && p.preBackup != nil && !explicit("pre-backup") {
+		return *p.preBackup
+	}
+	return preBackupHook
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// postBackupCommand returns the post-backup command of the watch, from its profile or --post-backup.
+func (p *watchProfile) postBackupCommand() string {
+	if p != nil && p.postBackup != nil && !explicit("post-backup") {
+		return *p.postBackup
+	}
+	return postBackupHook
+}
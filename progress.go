@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// showProgress draws a progress bar on the terminal during backups, set by --progress.
+var showProgress bool
+
+// Intervals between progress log records and between redraws of the progress bar.
+const (
+	progressLogInterval = 30 * time.Second
+	progressBarInterval = 250 * time.Millisecond
+)
+
+// backupProgress is how far a backup run has got, as reported on /status. The totals are those of the
+// files the run set out to archive, so they are 0 until they are known.
+type backupProgress struct {
+	FilesDone  int64   `json:"files_done"`
+	FilesTotal int64   `json:"files_total"`
+	BytesDone  int64   `json:"bytes_done"`
+	BytesTotal int64   `json:"bytes_total"`
+	ETA        float64 `json:"eta_seconds,omitempty"` // Estimated from the rate so far
+}
+
+// progressTracker follows the progress of one backup run. Its methods do nothing on a nil tracker.
+type progressTracker struct {
+	mu      sync.Mutex
+	started time.Time // When the totals were set
+	p       backupProgress
+}
+
+type progressKey struct{}
+
+// ------------------------------------------------------------------------------------------------------------
+// withProgress returns a context for a backup run that tracks its progress.
+func withProgress(ctx context.Context) (context.Context, *progressTracker) {
+	t := &progressTracker{}
+	return context.WithValue(ctx, progressKey{}, t), t
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// progressFrom returns the progress tracker of a backup run, or nil if it has none.
+func progressFrom(ctx context.Context) *progressTracker {
+	t, _ := ctx.Value(progressKey{}).(*progressTracker)
+	return t
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// setTotals records how many files and bytes the run is going to archive.
+func (t *progressTracker) setTotals(files, bytes int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.started, t.p.FilesTotal, t.p.BytesTotal = time.Now(), files, bytes
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// fileDone counts a file of the given size as archived.
+func (t *progressTracker) fileDone(size int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.p.FilesDone++
+	t.p.BytesDone += size
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// snapshot returns the progress so far, with the time remaining estimated from the rate so far.
+func (t *progressTracker) snapshot() backupProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p := t.p
+	if p.BytesDone > 0 && p.BytesTotal > p.BytesDone && !t.started.IsZero() {
+		elapsed := time.Since(t.started).Seconds()
+		p.ETA = float64(int64(elapsed * float64(p.BytesTotal-p.BytesDone) / float64(p.BytesDone)))
+	}
+	return p
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// reportProgress logs the progress of a backup run every progressLogInterval, and with --progress draws a
+// bar on stderr, until the returned function is called.
+func reportProgress(watchFolder string, t *progressTracker) (stop func()) {
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		logTicker := time.NewTicker(progressLogInterval)
+		defer logTicker.Stop()
+		var bar <-chan time.Time
+		if showProgress {
+			barTicker := time.NewTicker(progressBarInterval)
+			defer barTicker.Stop()
+			bar = barTicker.C
+		}
+		for {
+			select {
+			case <-done:
+				if showProgress {
+					fmt.Fprintf(os.Stderr, "\r%s\n", progressBar(t.snapshot()))
+				}
+				return
+			case <-logTicker.C:
+				p := t.snapshot()
+				slog.Info("Backup progress", "event", "backup_progress", "path", watchFolder, "files", p.FilesDone, "files_total", p.FilesTotal,
+					"bytes", p.BytesDone, "bytes_total", p.BytesTotal, "eta", p.ETA)
+			case <-bar:
+				fmt.Fprintf(os.Stderr, "\r%s", progressBar(t.snapshot()))
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// progressBar renders progress as a line such as "[#####-----]  50%  120/240 files  1.2G/2.4G  ETA 3m10s".
+func progressBar(p backupProgress) string {
+	const width = 30
+	fraction := 0.0
+	if p.BytesTotal > 0 {
+		fraction = min(float64(p.BytesDone)/float64(p.BytesTotal), 1)
+	}
+	filled := int(fraction * width)
+	line := fmt.Sprintf("[%s%s] %3.0f%%  %d/%d files  %s/%s", strings.Repeat("#", filled), strings.Repeat("-", width-filled),
+		fraction*100, p.FilesDone, p.FilesTotal, formatSize(p.BytesDone), formatSize(p.BytesTotal))
+	if p.ETA > 0 {
+		line += "  ETA " + (time.Duration(p.ETA) * time.Second).String()
+	}
+	return line + "   " // Clears what is left of a longer previous line
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// countBackupFiles totals the files of the watch folder a backup is going to archive: those not excluded for
+// which include returns true. It is a quick walk that only reads file information.
+func countBackupFiles(watchFolder string, exclude []string, include func(relPath string, info os.FileInfo) bool) (files, bytes int64) {
+	walkFolder(watchFolder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		relPath, err := filepath.Rel(watchFolder, path)
+		if err != nil {
+			return nil
+		}
+		if excluded(exclude, filepath.ToSlash(relPath)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && path != filepath.Join(watchFolder, pauseFileName) && include(filepath.ToSlash(relPath), info) {
+			files++
+			bytes += info.Size()
+		}
+		return nil
+	})
+	return files, bytes
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Ways of protecting finished archives, set by --protect.
+const (
+	protectOff       = ""
+	protectReadOnly  = "readonly"  // Clear the write permission bits, or set the read-only attribute on Windows
+	protectImmutable = "immutable" // Read-only, and the immutable flag where the system has one
+)
+
+var protectMode string
+
+// Files processors write next to an archive, protected along with it.
+var archiveSidecars = []string{".sha256", signatureSuffix}
+
+// ------------------------------------------------------------------------------------------------------------
+// protectArchives protects the finished archives and the files written next to them by processors. Files
+// that cannot be protected are logged, but do not fail the backup, which is already complete.
+func protectArchives(archives []string) {
+	if protectMode == protectOff {
+		return
+	}
+	for _, archive := range archives {
+		paths := []string{archive}
+		for _, suffix := range archiveSidecars {
+			if _, err := os.Stat(archive + suffix); err == nil {
+				paths = append(paths, archive+suffix)
+			}
+		}
+		for _, path := range paths {
+			if err := protectFile(path); err != nil {
+				slog.Warn("ALERT: failed to protect archive", "event", "protect_failed", "path", path, "mode", protectMode, "error", err)
+			}
+		}
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// protectFile makes a file read-only and, in immutable mode, immutable.
+func protectFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, info.Mode().Perm()&^0222); err != nil {
+		return err
+	}
+	if protectMode == protectImmutable {
+		if err := setImmutable(path); err != nil {
+			return fmt.Errorf("setting the immutable flag: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// unreadableReason starts the reason of skipped files that could not be opened.
+const unreadableReason = "unreadable: "
+
+// quarantineFolder receives files that cannot be read, set by --quarantine. If empty they stay in place.
+var quarantineFolder string
+
+// ------------------------------------------------------------------------------------------------------------
+// skipUnreadable lists a file that could not be opened, for lack of permission or because another process
+// locks it, as skipped rather than failing the whole backup, and moves it to the quarantine folder if one
+// is set. It returns false for any other error, which still fails the backup.
+func skipUnreadable(skipped *[]skippedFile, path, relPath string, info os.FileInfo, err error) bool {
+	if !errors.Is(err, fs.ErrPermission) && !isFileLocked(err) {
+		return false
+	}
+	reason := err
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		reason = pathErr.Err
+	}
+	slog.Warn("Skipped unreadable file", "event", "file_skipped", "path", path, "reason", reason.Error())
+	entry := skippedFile{Path: relPath, Size: info.Size(), Reason: unreadableReason + reason.Error()}
+	if quarantineFolder != "" {
+		if dest, err := quarantine(path, relPath); err != nil {
+			slog.Warn("Failed to quarantine file", "path", path, "error", err)
+		} else {
+			log.Printf("Quarantined %s to %s\n", path, dest)
+			entry.Quarantined = dest
+		}
+	}
+	*skipped = append(*skipped, entry)
+	return true
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// quarantine moves a file to the same relative path below the quarantine folder, which must be on the same
+// volume as the watch folder. A file quarantined before under that name is kept by adding a timestamp.
+func quarantine(path, relPath string) (string, error) {
+	dest := filepath.Join(quarantineFolder, filepath.FromSlash(relPath))
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return "", err
+	}
+	if _, err := os.Lstat(dest); err == nil {
+		dest += "." + time.Now().Format(archiveTimeLayout)
+	}
+	return dest, os.Rename(path, dest)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const queueFileName = ".foldermon-queue.json" // Kept in the backup folder while a backup is outstanding
+
+// pendingJob is a backup that was triggered but has not succeeded yet. It is persisted in the backup
+// folder, so a backup interrupted by a crash or reboot, or still waiting for a retry, runs on restart.
+type pendingJob struct {
+	Watch     string    `json:"watch"`
+	Triggered time.Time `json:"triggered"` // First trigger not yet covered by a successful backup
+	Attempts  int       `json:"attempts"`  // Failed or aborted attempts so far
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// loadJob reads the pending job of the backup folder, or returns nil if there is none.
+func loadJob(backupFolder string) (*pendingJob, error) {
+	data, err := os.ReadFile(filepath.Join(backupFolder, queueFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	job := &pendingJob{}
+	if err := json.Unmarshal(data, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// saveJob writes the pending job of the backup folder, replacing it atomically. The file is synced before
+// it is renamed, so the job survives a power loss.
+func saveJob(backupFolder string, job *pendingJob) error {
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(backupFolder, queueFileName)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := syncAndClose(file); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// clearJob removes the pending job of the backup folder once it is done or given up.
+func clearJob(backupFolder string) error {
+	err := os.Remove(filepath.Join(backupFolder, queueFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Formats the repack processor writes. Both need an external tool, which is looked up when the config is
+// loaded.
+const (
+	repackTarXz    = "tar.xz" // xz
+	repackSevenZip = "7z"     // 7z, 7za or 7zz (7-Zip, p7zip)
+)
+
+// repackProcessor writes the contents of a zip archive again as <archive>.tar.xz or <archive>.7z, next to
+// it, with maximum compression, and passes the new file on, so the processors after it copy or upload that
+// one. The zip archive stays, as restore, list and incremental backups read it.
+type repackProcessor struct {
+	format string
+	tool   string // Path of the compressor
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newRepackProcessor creates a repack processor for "format".
+func newRepackProcessor(pc processorConfig) (processor, error) {
+	var candidates []string
+	switch pc.Format {
+	case repackTarXz:
+		candidates = []string{"xz"}
+	case repackSevenZip:
+		candidates = []string{"7z", "7za", "7zz"}
+	default:
+		return nil, fmt.Errorf(`"format" must be %s or %s, got %q`, repackTarXz, repackSevenZip, pc.Format)
+	}
+	for _, name := range candidates {
+		if tool, err := exec.LookPath(name); err == nil {
+			return repackProcessor{format: pc.Format, tool: tool}, nil
+		}
+	}
+	return nil, fmt.Errorf("%s needs %s installed", pc.Format, strings.Join(candidates, " or "))
+}
+
+func (p repackProcessor) process(ctx context.Context, archive string) (string, error) {
+	if !strings.HasSuffix(archive, ".zip") {
+		return "", fmt.Errorf("%s is not a zip archive", archive)
+	}
+	dest := strings.TrimSuffix(archive, ".zip") + "." + p.format
+	var err error
+	if p.format == repackTarXz {
+		err = p.tarXz(ctx, archive, dest+".tmp")
+	} else {
+		err = p.sevenZip(ctx, archive, dest+".tmp")
+	}
+	if err == nil {
+		err = os.Rename(dest+".tmp", dest)
+	}
+	if err != nil {
+		os.Remove(dest + ".tmp")
+		return "", err
+	}
+	return dest, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// tarXz streams the entries of a zip archive as a tar file through xz into target.
+func (p repackProcessor) tarXz(ctx context.Context, archive, target string) error {
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	cmd := exec.CommandContext(ctx, p.tool, "-9", "-T0", "-c")
+	cmd.Stdout = out
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	tw := tar.NewWriter(stdin)
+	err = writeTar(ctx, tw, reader.File)
+	if err == nil {
+		err = tw.Close()
+	}
+	stdin.Close()
+	if waitErr := cmd.Wait(); err == nil && waitErr != nil {
+		err = fmt.Errorf("xz: %v", waitErr)
+	}
+	if err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// writeTar adds the entries of a zip archive to a tar file, keeping names, permissions and modification
+// times.
+func writeTar(ctx context.Context, tw *tar.Writer, files []*zip.File) error {
+	for _, file := range files {
+		header, err := tar.FileInfoHeader(file.FileInfo(), "")
+		if err != nil {
+			return err
+		}
+		header.Name = file.Name
+		if file.Mode()&os.ModeSymlink != 0 {
+			src, err := file.Open()
+			if err != nil {
+				return err
+			}
+			target, err := io.ReadAll(io.LimitReader(src, 4096))
+			src.Close()
+			if err != nil {
+				return err
+			}
+			header.Linkname = string(target)
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		src, err := file.Open()
+		if err != nil {
+			return err
+		}
+		_, err = copyData(tw, contextReader{ctx, src})
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// sevenZip extracts the entries of a zip archive into a temporary folder and packs them with 7-Zip into
+// target.
+func (p repackProcessor) sevenZip(ctx context.Context, archive, target string) error {
+	dir, err := os.MkdirTemp(filepath.Dir(archive), ".repack-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		path, err := restorePath(dir, file.Name)
+		if err == nil && file.FileInfo().IsDir() {
+			err = os.MkdirAll(path, os.ModePerm)
+		} else if err == nil {
+			if err = checkLinkParents(dir, path); err == nil {
+				err = extractFile(ctx, file, path)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	abs, err := filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+	args := []string{"a", "-t7z", "-mx=9", "-bd", "-y", abs}
+	for _, entry := range entries {
+		args = append(args, entry.Name())
+	}
+	cmd := exec.CommandContext(ctx, p.tool, args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v: %s", filepath.Base(p.tool), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Values of --report.
+const (
+	reportOff    = "off"
+	reportDaily  = "daily"  // At midnight
+	reportWeekly = "weekly" // At midnight before Monday
+)
+
+// defaultReportFile is where summary reports are appended unless --report-file says otherwise.
+const defaultReportFile = "foldermon-report.txt"
+
+// reporter collects backup outcomes and writes a summary of them every day or week, so admins get a
+// heartbeat even when nothing fails. Each summary is appended to a file and logged as a backup_report
+// event, which email and webhook notifications pass on.
+type reporter struct {
+	file string
+
+	mu      sync.Mutex
+	since   time.Time
+	watches map[string]*watchSummary
+}
+
+// watchSummary counts the backup outcomes of a watch since the last report.
+type watchSummary struct {
+	backups, failures, skipped int
+	bytes                      int64
+}
+
+// reportHandler is the slog sink feeding a reporter.
+type reportHandler struct {
+	*reporter
+	attrs []slog.Attr
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newReporter starts writing a report to file at the end of every period until ctx ends; call flush before
+// exiting to report the rest.
+func newReporter(ctx context.Context, period, file string) (*reporter, error) {
+	if period != reportDaily && period != reportWeekly {
+		return nil, fmt.Errorf("unknown --report value %q (want %s, %s or %s)", period, reportOff, reportDaily, reportWeekly)
+	}
+	r := &reporter{file: file, since: time.Now(), watches: make(map[string]*watchSummary)}
+	go func() {
+		for {
+			now := time.Now()
+			next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+			for period == reportWeekly && next.Weekday() != time.Monday {
+				next = next.AddDate(0, 0, 1)
+			}
+			select {
+			case <-time.After(next.Sub(now)):
+				r.flush()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return r, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// handler returns the slog sink passing backup outcomes to the reporter.
+func (r *reporter) handler() slog.Handler {
+	return &reportHandler{reporter: r}
+}
+
+func (h *reportHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (h *reportHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *reportHandler) WithGroup(name string) slog.Handler { return h }
+
+// ------------------------------------------------------------------------------------------------------------
+// Handle counts a backup outcome towards the next report.
+func (h *reportHandler) Handle(_ context.Context, rec slog.Record) error {
+	n, ok := noticeFromRecord(rec, h.attrs)
+	if !ok || !n.outcome() || n.Event == "backup_report" {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.watches[n.watch()]
+	if s == nil {
+		s = &watchSummary{}
+		h.watches[n.watch()] = s
+	}
+	if n.failed() {
+		s.failures++
+		return nil
+	}
+	bytes, _ := strconv.ParseInt(n.Attrs["bytes"], 10, 64)
+	skipped, _ := strconv.Atoi(n.Attrs["skipped"])
+	s.backups, s.bytes, s.skipped = s.backups+1, s.bytes+bytes, s.skipped+skipped
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// flush appends the report on the outcomes since the last one to the report file, logs it and starts
+// counting again. A report is written even if no backup ran, as that is worth knowing too.
+func (r *reporter) flush() {
+	r.mu.Lock()
+	since, watches := r.since, r.watches
+	r.since, r.watches = time.Now(), make(map[string]*watchSummary)
+	r.mu.Unlock()
+
+	host, _ := os.Hostname()
+	var total watchSummary
+	var text strings.Builder
+	fmt.Fprintf(&text, "foldermon on %s, %s to %s\n", host, since.Format(time.DateTime), time.Now().Format(time.DateTime))
+	w := tabwriter.NewWriter(&text, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "WATCH\tBACKUPS\tFAILED\tBYTES\tSKIPPED")
+	names := make([]string, 0, len(watches))
+	for name := range watches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := watches[name]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\n", name, s.backups, s.failures, formatSize(s.bytes), s.skipped)
+		total.backups, total.failures = total.backups+s.backups, total.failures+s.failures
+		total.bytes, total.skipped = total.bytes+s.bytes, total.skipped+s.skipped
+	}
+	fmt.Fprintf(w, "total\t%d\t%d\t%s\t%d\n", total.backups, total.failures, formatSize(total.bytes), total.skipped)
+	w.Flush()
+
+	file, err := os.OpenFile(r.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err == nil {
+		_, err = file.WriteString(text.String() + "\n")
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		log.Println("Failed to write report:", err)
+	}
+	slog.Info("Backup report", "event", "backup_report", "since", since.Format(time.RFC3339), "backups", total.backups,
+		"failures", total.failures, "bytes", total.bytes, "skipped", total.skipped, "report", r.file)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// runRestore implements "foldermon restore <archive|--latest <backupFolder>> --to <dir> [--force]
+// [--apply-deletions] [--key <public key>]".
+// It extracts a backup archive, or a snapshot of a dedup repository, into the target directory, keeping
+// the relative paths stored in the backup. With --key, nothing is restored unless the signatures of the
+// archive and of every archive it builds on match.
+func runRestore(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	to := fs.String("to", "", "directory to restore into")
+	latest := fs.Bool("latest", false, "restore the newest archive in the given backup folder")
+	force := fs.Bool("force", false, "overwrite files that already exist in the target directory")
+	applyDeletions := fs.Bool("apply-deletions", false, "remove files recorded as deleted in the restored archives")
+	keyPath := fs.String("key", "", "public keys to check archive signatures with before restoring, separated by commas")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || *to == "" {
+		return usageError("usage: %s restore <archive|--latest <backupFolder>> --to <dir> [--force] [--apply-deletions] [--key <public key>]", os.Args[0])
+	}
+
+	archivePath := positional[0]
+	if *latest && isRepository(positional[0]) {
+		snapshots, err := findSnapshots(positional[0])
+		if err != nil {
+			return err
+		}
+		if len(snapshots) == 0 {
+			return fmt.Errorf("no snapshots found in %s", positional[0])
+		}
+		archivePath = snapshots[len(snapshots)-1]
+	} else if *latest {
+		archivePath, err = latestArchive(positional[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	if *keyPath != "" {
+		if err := checkRestoreSignatures(archivePath, *keyPath); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Restoring %s to %s\n", archivePath, *to)
+	targetDir, err := filepath.Abs(*to) // Lifts MAX_PATH on Windows for deep trees
+	if err != nil {
+		return err
+	}
+	if isSnapshot(archivePath) {
+		return restoreSnapshot(ctx, archivePath, targetDir, *force)
+	}
+	return restoreArchive(ctx, archivePath, targetDir, *force, *applyDeletions)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// restoreArchive extracts every file in the archive below targetDir. Incremental and differential archives
+// are restored on top of the archives they build on, so the result is the complete folder state.
+// Unless force is set, it refuses to run if any file would be overwritten, checking all entries before
+// writing anything. With applyDeletions, files recorded as deleted by an archive are removed after it
+// is applied, reproducing the deletion state at the time of the backup.
+func restoreArchive(ctx context.Context, archivePath, targetDir string, force, applyDeletions bool) error {
+	chain, err := archiveChain(archivePath)
+	if err != nil {
+		return err
+	}
+
+	readers := make([]*zip.ReadCloser, len(chain))
+	for i, path := range chain {
+		readers[i], err = zip.OpenReader(path)
+		if err != nil {
+			return err
+		}
+		defer readers[i].Close()
+	}
+
+	for _, reader := range readers {
+		for _, file := range reader.File {
+			if file.Name == manifestName {
+				continue
+			}
+			target, err := restorePath(targetDir, file.Name)
+			if err != nil {
+				return err
+			}
+
+			if force || file.FileInfo().IsDir() {
+				continue
+			}
+			if _, err := os.Lstat(target); err == nil {
+				return fmt.Errorf("refusing to overwrite %s (use --force)", target)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	for i, reader := range readers {
+		if len(chain) > 1 {
+			fmt.Printf("Applying %s\n", filepath.Base(chain[i]))
+		}
+		attrs := make(map[string]manifestEntry)
+		if m, err := readManifest(&reader.Reader); err == nil && m != nil {
+			for _, entry := range m.Files {
+				if entry.Xattrs != nil || entry.ACL != "" {
+					attrs[entry.Path] = entry
+				}
+			}
+		}
+		for _, file := range reader.File {
+			if file.Name == manifestName {
+				continue
+			}
+			target, _ := restorePath(targetDir, file.Name) // Validated above
+			if file.FileInfo().IsDir() {
+				if err := os.MkdirAll(target, os.ModePerm); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := checkLinkParents(targetDir, target); err != nil {
+				return err
+			}
+			if err := extractFile(ctx, file, target); err != nil {
+				return err
+			}
+			if entry, ok := attrs[file.Name]; ok {
+				if err := applyAttrs(target, entry); err != nil {
+					fmt.Printf("Warning: could not restore extended attributes of %s: %v\n", target, err)
+				}
+			}
+			fmt.Printf("Restored: %s\n", target)
+		}
+
+		if applyDeletions {
+			if err := applyArchiveDeletions(&reader.Reader, targetDir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// applyArchiveDeletions removes the files an archive's manifest records as deleted from targetDir.
+func applyArchiveDeletions(reader *zip.Reader, targetDir string) error {
+	m, err := readManifest(reader)
+	if err != nil || m == nil {
+		return err
+	}
+	for _, t := range m.Deleted {
+		target, err := restorePath(targetDir, t.Path)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		fmt.Printf("Deleted: %s (removed %s)\n", target, t.Time.Local().Format(time.DateTime))
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// restorePath maps an archive entry name to a path below targetDir, rejecting names that would escape it.
+func restorePath(targetDir, name string) (string, error) {
+	name = strings.ReplaceAll(name, `\`, "/") // entries written on Windows by older versions
+	target := filepath.Join(targetDir, filepath.FromSlash(name))
+
+	rel, err := filepath.Rel(targetDir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(name) {
+		return "", fmt.Errorf("archive entry %q escapes the target directory", name)
+	}
+	return target, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// extractFile writes a single archive entry to target, creating parent directories as needed. Symbolic
+// link entries are restored as links.
+func extractFile(ctx context.Context, file *zip.File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if file.Mode()&os.ModeSymlink != 0 {
+		linkTarget, err := io.ReadAll(io.LimitReader(src, 4096))
+		if err != nil {
+			return err
+		}
+		return restoreLink(string(linkTarget), target)
+	}
+
+	mode := file.Mode().Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	dst, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := copyData(dst, contextReader{ctx, src}); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	// Entries written by older versions carry neither Unix attributes (creator 3) nor a modification
+	// time, which reads back as 1979 or 1980
+	mode, modTime := file.Mode(), file.Modified
+	if file.CreatorVersion>>8 != 3 {
+		mode = 0
+	}
+	if modTime.Year() <= 1980 {
+		modTime = time.Time{}
+	}
+	return restoreMetadata(target, mode, modTime, zipOwner(file.Extra))
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// checkRestoreSignatures verifies the signatures of a snapshot, or of an archive and the archives it builds
+// on, against the public keys at keyPaths, separated by commas.
+func checkRestoreSignatures(archivePath, keyPaths string) error {
+	keys, err := loadPublicKeys(keyPaths)
+	if err != nil {
+		return err
+	}
+	chain := []string{archivePath}
+	if !isSnapshot(archivePath) {
+		if chain, err = archiveChain(archivePath); err != nil {
+			return err
+		}
+	}
+	for _, path := range chain {
+		if err := checkSignature(path, keys); err != nil {
+			return fmt.Errorf("refusing to restore %s: %w", path, err)
+		}
+		fmt.Printf("Signature OK: %s\n", filepath.Base(path))
+	}
+	return nil
+}
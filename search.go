@@ -0,0 +1,223 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// searchHit is a file version found in a backup, or the deletion of a file recorded by it.
+type searchHit struct {
+	Archive string    `json:"archive"`
+	Created time.Time `json:"created"`
+	Path    string    `json:"path"`
+	Version int       `json:"version,omitempty"` // Numbers the distinct contents of a file, 0 for deletions
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modified"` // Time of deletion for deletions
+	SHA256  string    `json:"sha256,omitempty"`
+	Deleted bool      `json:"deleted"`
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// runSearch implements "foldermon search <backupFolder> <pattern>". It reports every backup containing a
+// file that matches the pattern, numbering the distinct versions of each file, and every backup recording
+// such a file as deleted. Patterns without a slash match file names, patterns with one match the whole
+// relative path.
+func runSearch(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	fs.StringVar(&catalogFile, "catalog", "", "path of the SQLite backup catalog (default <backupFolder>/"+catalogFileName+")")
+	output := outputFlag(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return usageError("usage: %s search <backupFolder> <pattern> [--output json]", os.Args[0])
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	backupFolder, pattern := positional[0], positional[1]
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+
+	hits, err := searchCatalog(backupFolder, pattern)
+	if err != nil {
+		return err
+	}
+	if hits == nil {
+		if *output == outputText {
+			fmt.Println("No catalog found, scanning archives")
+		}
+		if hits, err = searchArchives(ctx, backupFolder, pattern); err != nil {
+			return err
+		}
+	}
+	if len(hits) == 0 && *output == outputText {
+		fmt.Printf("No backups contain files matching %q\n", pattern)
+		return nil
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Path != hits[j].Path {
+			return hits[i].Path < hits[j].Path
+		}
+		return hits[i].Created.Before(hits[j].Created)
+	})
+
+	version, lastPath, lastSum := 0, "", ""
+	for i, hit := range hits {
+		if hit.Path != lastPath {
+			version, lastSum = 0, ""
+		}
+		if hit.Deleted {
+			lastPath, lastSum = hit.Path, ""
+			continue
+		}
+		if hit.SHA256 != lastSum {
+			version++
+		}
+		lastPath, lastSum = hit.Path, hit.SHA256
+		hits[i].Version = version
+	}
+	if *output == outputJSON {
+		return printJSON(append([]searchHit{}, hits...))
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tVERSION\tSIZE\tMODIFIED\tARCHIVE")
+	for _, hit := range hits {
+		if hit.Deleted {
+			fmt.Fprintf(w, "%s\tdeleted\t-\t%s\t%s\n", hit.Path, hit.ModTime.Local().Format(time.DateTime), hit.Archive)
+			continue
+		}
+		fmt.Fprintf(w, "%s\tv%d\t%d\t%s\t%s\n", hit.Path, hit.Version, hit.Size, hit.ModTime.Local().Format(time.DateTime), hit.Archive)
+	}
+	return w.Flush()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// matchPattern reports whether a slash-separated relative path matches a search pattern. A leading "/"
+// anchors the pattern to the top folder.
+func matchPattern(pattern, relPath string) bool {
+	if anchored, ok := strings.CutPrefix(pattern, "/"); ok {
+		pattern = anchored
+	} else if !strings.Contains(pattern, "/") {
+		relPath = path.Base(relPath)
+	}
+	ok, _ := path.Match(pattern, relPath)
+	return ok
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// searchCatalog finds matching files in the catalog. It returns nil without error if there is no catalog.
+func searchCatalog(backupFolder, pattern string) ([]searchHit, error) {
+	db, err := openExistingCatalog(backupFolder)
+	if err != nil || db == nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT b.archive, b.created, f.path, f.size, f.mtime, f.sha256
+		FROM files f JOIN backups b ON b.id = f.backup_id
+		WHERE b.status = 'success' AND b.destination = ?`, catalogDestination(backupFolder))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := []searchHit{}
+	for rows.Next() {
+		var hit searchHit
+		if err := rows.Scan(&hit.Archive, &hit.Created, &hit.Path, &hit.Size, &hit.ModTime, &hit.SHA256); err != nil {
+			return nil, err
+		}
+		if matchPattern(pattern, hit.Path) {
+			hits = append(hits, hit)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`SELECT b.archive, b.created, d.path, d.deleted
+		FROM deletions d JOIN backups b ON b.id = d.backup_id
+		WHERE b.status = 'success' AND b.destination = ?`, catalogDestination(backupFolder))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		hit := searchHit{Deleted: true}
+		if err := rows.Scan(&hit.Archive, &hit.Created, &hit.Path, &hit.ModTime); err != nil {
+			return nil, err
+		}
+		if matchPattern(pattern, hit.Path) {
+			hits = append(hits, hit)
+		}
+	}
+	return hits, rows.Err()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// searchArchives finds matching files by reading the directory of every archive in the backup folder.
+// Versions are identified by the manifest checksum, or by the zip CRC for archives without a manifest.
+func searchArchives(ctx context.Context, backupFolder, pattern string) ([]searchHit, error) {
+	archives, err := findArchives(backupFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	var hits []searchHit
+	for _, archivePath := range archives {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		reader, err := zip.OpenReader(archivePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", archivePath, err)
+			continue
+		}
+
+		entries := make(map[string]manifestEntry)
+		if m, err := readManifest(&reader.Reader); err == nil && m != nil {
+			for _, entry := range m.Files {
+				entries[entry.Path] = entry
+			}
+			for _, t := range m.Deleted {
+				if matchPattern(pattern, t.Path) {
+					hits = append(hits, searchHit{Archive: filepath.Base(archivePath), Created: archiveTime(archivePath), Path: t.Path, ModTime: t.Time, Deleted: true})
+				}
+			}
+		}
+
+		for _, file := range reader.File {
+			if file.FileInfo().IsDir() || file.Name == manifestName || !matchPattern(pattern, file.Name) {
+				continue
+			}
+			hit := searchHit{
+				Archive: filepath.Base(archivePath),
+				Created: archiveTime(archivePath),
+				Path:    file.Name,
+				Size:    int64(file.UncompressedSize64),
+				ModTime: file.Modified,
+				SHA256:  fmt.Sprintf("crc32:%08x", file.CRC32),
+			}
+			if entry, ok := entries[file.Name]; ok {
+				hit.ModTime, hit.SHA256 = entry.ModTime, entry.SHA256
+			}
+			hits = append(hits, hit)
+		}
+		reader.Close()
+	}
+	return hits, nil
+}
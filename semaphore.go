@@ -0,0 +1,44 @@
+package main
+
+import "context"
+
+// semaphore limits how many operations of one kind run at once across all watches. A nil semaphore
+// imposes no limit.
+type semaphore chan struct{}
+
+var (
+	backupSlots  semaphore // Whole backup runs, hooks and processors included, set by --max-backups
+	archiveSlots semaphore // Archive builds, set by --max-archives
+	walkSlots    semaphore // Directory walks, set by --max-walks
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// newSemaphore returns a semaphore admitting n holders, or nil for n <= 0.
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// acquire blocks until a slot is free or ctx ends.
+func (s semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// release frees a slot taken by acquire.
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
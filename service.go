@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// runService installs, removes, starts or stops foldermon as a system service running the monitor with the
+// arguments given after the service flags, e.g. "foldermon service install -- --config /etc/foldermon.json".
+func runService(ctx context.Context, args []string) error {
+	usage := usageError("usage: %s service install [--name foldermon] [--user] -- <monitor flags and folders>, or %s service uninstall|start|stop [--name foldermon] [--user]", os.Args[0], os.Args[0])
+	if len(args) == 0 {
+		return usage
+	}
+
+	fs := flag.NewFlagSet("service "+args[0], flag.ExitOnError)
+	name := fs.String("name", "foldermon", "service name")
+	user := fs.Bool("user", false, "install for the current user instead of system-wide")
+	if err := applyEnv(fs); err != nil {
+		return err
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "install":
+		if fs.NArg() == 0 {
+			return usage
+		}
+		for _, arg := range fs.Args() {
+			if arg == "--daemon" || arg == "-daemon" {
+				return fmt.Errorf("the service manager runs foldermon in the background, remove --daemon")
+			}
+		}
+		return installService(*name, *user, fs.Args())
+	case "uninstall":
+		return uninstallService(*name, *user)
+	case "start":
+		return startService(*name, *user)
+	case "stop":
+		return stopService(*name, *user)
+	default:
+		return usage
+	}
+}
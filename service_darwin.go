@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// launchdPlist is the job written by "foldermon service install". KeepAlive restarts the monitor when it
+// fails but not when it is stopped, and launchd's own output goes next to foldermon.log.
+var launchdPlist = template.Must(template.New("plist").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{xml .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Args}}
+		<string>{{xml .}}</string>
+{{- end}}
+	</array>
+	<key>WorkingDirectory</key>
+	<string>{{xml .Dir}}</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>ThrottleInterval</key>
+	<integer>10</integer>
+	<key>StandardOutPath</key>
+	<string>{{xml .Log}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .Log}}</string>
+</dict>
+</plist>
+`))
+
+// ------------------------------------------------------------------------------------------------------------
+// installService writes a launchd job running the monitor with args from the current directory and loads
+// it. System jobs go to /Library/LaunchDaemons, --user jobs to ~/Library/LaunchAgents.
+func installService(name string, user bool, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	plistPath, err := plistFilePath(name, user)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(plistPath), 0755); err != nil {
+		return err
+	}
+	plist, err := os.Create(plistPath)
+	if err != nil {
+		return err
+	}
+	err = launchdPlist.Execute(plist, map[string]interface{}{
+		"Label": launchdLabel(name),
+		"Args":  append([]string{exe}, args...),
+		"Dir":   dir,
+		"Log":   filepath.Join(dir, "foldermon.launchd.log"),
+	})
+	if closeErr := plist.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", plistPath)
+
+	return launchctl("bootstrap", launchdDomain(user), plistPath)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// uninstallService unloads the job and removes its plist.
+func uninstallService(name string, user bool) error {
+	plistPath, err := plistFilePath(name, user)
+	if err != nil {
+		return err
+	}
+	if err := launchctl("bootout", launchdDomain(user), plistPath); err != nil {
+		return err
+	}
+	if err := os.Remove(plistPath); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %s\n", plistPath)
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// startService starts a loaded job.
+func startService(name string, user bool) error {
+	return launchctl("kickstart", launchdDomain(user)+"/"+launchdLabel(name))
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// stopService sends SIGTERM to a running job. The monitor exits cleanly, so KeepAlive does not restart it.
+func stopService(name string, user bool) error {
+	return launchctl("kill", "SIGTERM", launchdDomain(user)+"/"+launchdLabel(name))
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// runAsService reports false: launchd runs foldermon as an ordinary process.
+func runAsService(run func(ctx context.Context)) bool {
+	return false
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// launchdLabel returns the launchd label of a service.
+func launchdLabel(name string) string {
+	return "com.github.ranobrega." + name
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// launchdDomain returns the launchctl domain of system or user jobs.
+func launchdDomain(user bool) string {
+	if user {
+		return "gui/" + strconv.Itoa(os.Getuid())
+	}
+	return "system"
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// plistFilePath returns where the plist of a system or user job lives.
+func plistFilePath(name string, user bool) (string, error) {
+	if !user {
+		return filepath.Join("/Library/LaunchDaemons", launchdLabel(name)+".plist"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", launchdLabel(name)+".plist"), nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// launchctl runs launchctl with the given arguments.
+func launchctl(args ...string) error {
+	cmd := exec.Command("launchctl", args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("launchctl %s: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// xmlEscape escapes s for use as XML character data.
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// serviceReady, serviceStopping, serviceWatchdog and servicePing do nothing: launchd has no readiness or
+// watchdog protocol.
+func serviceReady()                  {}
+func serviceStopping()               {}
+func serviceWatchdog() time.Duration { return 0 }
+func servicePing()                   {}
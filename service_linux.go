@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// systemdUnit is the unit written by "foldermon service install". Type=notify makes systemd wait for
+// READY=1 and, with WatchdogSec, restart a monitor that stops pinging.
+var systemdUnit = template.Must(template.New("unit").Parse(`[Unit]
+Description=foldermon folder backup monitor
+After=local-fs.target network-online.target
+
+[Service]
+Type=notify
+NotifyAccess=main
+WorkingDirectory={{.Dir}}
+ExecStart={{.ExecStart}}
+Restart=on-failure
+RestartSec=10
+WatchdogSec=60
+
+[Install]
+WantedBy={{.WantedBy}}
+`))
+
+// ------------------------------------------------------------------------------------------------------------
+// installService writes a systemd unit running the monitor with args from the current directory, then
+// enables and starts it.
+func installService(name string, user bool, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	unitPath, err := unitFilePath(name, user)
+	if err != nil {
+		return err
+	}
+
+	command := []string{systemdQuote(exe)}
+	for _, arg := range args {
+		command = append(command, systemdQuote(arg))
+	}
+	wantedBy := "multi-user.target"
+	if user {
+		wantedBy = "default.target"
+	}
+
+	if err := os.MkdirAll(filepath.Dir(unitPath), 0755); err != nil {
+		return err
+	}
+	unit, err := os.Create(unitPath)
+	if err != nil {
+		return err
+	}
+	err = systemdUnit.Execute(unit, map[string]string{
+		"Dir":       systemdQuote(dir),
+		"ExecStart": strings.Join(command, " "),
+		"WantedBy":  wantedBy,
+	})
+	if closeErr := unit.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", unitPath)
+
+	if err := systemctl(user, "daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl(user, "enable", "--now", name+".service")
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// uninstallService stops and disables the unit and removes its file.
+func uninstallService(name string, user bool) error {
+	unitPath, err := unitFilePath(name, user)
+	if err != nil {
+		return err
+	}
+	if err := systemctl(user, "disable", "--now", name+".service"); err != nil {
+		return err
+	}
+	if err := os.Remove(unitPath); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %s\n", unitPath)
+	return systemctl(user, "daemon-reload")
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// startService starts an installed unit.
+func startService(name string, user bool) error {
+	return systemctl(user, "start", name+".service")
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// stopService stops a running unit.
+func stopService(name string, user bool) error {
+	return systemctl(user, "stop", name+".service")
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// runAsService reports false: systemd runs foldermon as an ordinary process.
+func runAsService(run func(ctx context.Context)) bool {
+	return false
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// unitFilePath returns where the unit of a system or user service lives.
+func unitFilePath(name string, user bool) (string, error) {
+	if !user {
+		return filepath.Join("/etc/systemd/system", name+".service"), nil
+	}
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(config, "systemd", "user", name+".service"), nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// systemctl runs systemctl, for the user manager if user is set.
+func systemctl(user bool, args ...string) error {
+	if user {
+		args = append([]string{"--user"}, args...)
+	}
+	cmd := exec.Command("systemctl", args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("systemctl %s: %w", strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// systemdQuote quotes an argument for ExecStart= if it contains anything systemd would interpret.
+func systemdQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\$%;") {
+		return arg
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `$$`, `%`, `%%`)
+	return `"` + r.Replace(arg) + `"`
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// sdNotify sends a state notification to systemd. It does nothing when foldermon was not started by a
+// Type=notify unit.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	conn, err := net.Dial("unixgram", socket)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte(state))
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// serviceReady tells the service manager the monitor is up.
+func serviceReady() {
+	sdNotify("READY=1")
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// serviceStopping tells the service manager the monitor is shutting down.
+func serviceStopping() {
+	sdNotify("STOPPING=1")
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// serviceWatchdog returns how often the service manager expects a keep-alive from servicePing, or 0 if it
+// expects none. Pinging at half the configured timeout leaves room for a busy loop.
+func serviceWatchdog() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// servicePing keeps the service manager's watchdog from restarting the monitor.
+func servicePing() {
+	sdNotify("WATCHDOG=1")
+}
@@ -0,0 +1,48 @@
+//go:build !linux && !windows && !darwin
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// installService is not supported on this platform.
+func installService(name string, user bool, args []string) error {
+	return fmt.Errorf("service install is not supported on %s", runtime.GOOS)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// uninstallService is not supported on this platform.
+func uninstallService(name string, user bool) error {
+	return fmt.Errorf("service uninstall is not supported on %s", runtime.GOOS)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// startService is not supported on this platform.
+func startService(name string, user bool) error {
+	return fmt.Errorf("service start is not supported on %s", runtime.GOOS)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// stopService is not supported on this platform.
+func stopService(name string, user bool) error {
+	return fmt.Errorf("service stop is not supported on %s", runtime.GOOS)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// runAsService reports false: there is no service manager that needs a handler on this platform.
+func runAsService(run func(ctx context.Context)) bool {
+	return false
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// serviceReady, serviceStopping, serviceWatchdog and servicePing do nothing without a service manager to
+// talk to.
+func serviceReady()                  {}
+func serviceStopping()               {}
+func serviceWatchdog() time.Duration { return 0 }
+func servicePing()                   {}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceHandler runs the monitor under the Windows service control manager.
+type serviceHandler struct {
+	run func(ctx context.Context)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// Execute runs the monitor until the service is stopped or the system shuts down. A monitor that ends
+// on its own reports a failure, so the recovery actions set by installService restart it.
+func (h serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.run(ctx)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for {
+		select {
+		case <-done:
+			return false, 1
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// runAsService runs the monitor as a Windows service if the service control manager started this
+// process, and reports whether it did.
+func runAsService(run func(ctx context.Context)) bool {
+	inService, err := svc.IsWindowsService()
+	if err != nil || !inService {
+		return false
+	}
+	// Services have no console to write to
+	logConsole = io.Discard
+	if err := svc.Run("foldermon", serviceHandler{run}); err != nil {
+		slog.Error("Service failed", "error", err)
+	}
+	return true
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// installService registers a service running the monitor with args from the current directory, restarted
+// automatically on failure, and starts it.
+func installService(name string, user bool, args []string) error {
+	if user {
+		return fmt.Errorf("--user is not supported on Windows")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", name)
+	}
+
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: "foldermon",
+		Description: "Backs up watched folders as they change",
+		StartType:   mgr.StartAutomatic,
+	}, append([]string{"--workdir", dir}, args...)...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	err = s.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 10 * time.Second}}, uint32((24 * time.Hour).Seconds()))
+	if err == nil {
+		err = s.SetRecoveryActionsOnNonCrashFailures(true)
+	}
+	if err != nil {
+		slog.Warn("Failed to set service recovery actions", "error", err)
+	}
+	fmt.Printf("Installed service %s\n", name)
+	return s.Start()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// uninstallService stops the service and removes it.
+func uninstallService(name string, user bool) error {
+	if err := stopService(name, user); err != nil {
+		log.Println(err)
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s: %w", name, err)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	fmt.Printf("Removed service %s\n", name)
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// startService starts an installed service.
+func startService(name string, user bool) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s: %w", name, err)
+	}
+	defer s.Close()
+	return s.Start()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// stopService stops a running service and waits for the monitor to finish its current backup and exit.
+func stopService(name string, user bool) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s: %w", name, err)
+	}
+	defer s.Close()
+
+	status, err := s.Control(svc.Stop)
+	if err != nil {
+		return fmt.Errorf("service %s: %w", name, err)
+	}
+	deadline := time.Now().Add(stopTimeout)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s did not stop within %s", name, stopTimeout)
+		}
+		time.Sleep(200 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// serviceReady, serviceStopping, serviceWatchdog and servicePing do nothing: the service handler reports
+// its state to the service control manager itself.
+func serviceReady()                  {}
+func serviceStopping()               {}
+func serviceWatchdog() time.Duration { return 0 }
+func servicePing()                   {}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const signatureSuffix = ".sig" // Detached signature written next to a signed archive
+
+// errBadSignature reports an archive whose signature is missing or does not match.
+var errBadSignature = errors.New("signature does not match")
+
+// ------------------------------------------------------------------------------------------------------------
+// runKeygen implements "foldermon keygen --out <name>". It creates an Ed25519 key pair for signing archives:
+// <name>.key, the private key for the sign processor, and <name>.pub, the public key for restore and verify.
+func runKeygen(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	out := fs.String("out", "foldermon", "file name of the key pair, without extension")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 0 {
+		return usageError("usage: %s keygen [--out <name>]", os.Args[0])
+	}
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return err
+	}
+	// O_EXCL keeps an existing key, which signed archives may still depend on, from being overwritten
+	file, err := os.OpenFile(*out+".key", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: privateDER})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Private key: %s.key\nPublic key:  %s.pub\n", *out, *out)
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// readPEM returns the DER bytes of the first PEM block of the given type in a key file.
+func readPEM(path, blockType string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s: no %s found", path, blockType)
+	}
+	return block.Bytes, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// loadPrivateKey reads an Ed25519 private key written by keygen.
+func loadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	der, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return private, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// loadPublicKey reads an Ed25519 public key written by keygen.
+func loadPublicKey(path string) (ed25519.PublicKey, error) {
+	der, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+	}
+	return public, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// loadPublicKeys reads the public keys at paths, separated by commas. Listing the keys of earlier key pairs
+// keeps archives signed before a key rotation verifiable.
+func loadPublicKeys(paths string) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for _, path := range strings.Split(paths, ",") {
+		key, err := loadPublicKey(strings.TrimSpace(path))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// archiveDigest returns the SHA-256 digest of an archive, which is what its signature covers.
+func archiveDigest(archive string) ([]byte, error) {
+	sum, err := fileSHA256(archive)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(sum)
+}
+
+// signProcessor writes an Ed25519 signature of the archive's SHA-256 digest to <archive>.sig.
+type signProcessor struct {
+	key ed25519.PrivateKey
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newSignProcessor creates a sign processor with the private key at "key".
+func newSignProcessor(pc processorConfig) (processor, error) {
+	if pc.Key == "" {
+		return nil, fmt.Errorf(`"key" is required`)
+	}
+	key, err := loadPrivateKey(pc.Key)
+	if err != nil {
+		return nil, err
+	}
+	return signProcessor{key: key}, nil
+}
+
+func (p signProcessor) process(ctx context.Context, archive string) (string, error) {
+	digest, err := archiveDigest(archive)
+	if err != nil {
+		return "", err
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(p.key, digest))
+	return archive, os.WriteFile(archive+signatureSuffix, []byte(signature+"\n"), 0644)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// checkSignature verifies the detached signature of an archive, which must match one of the public keys.
+func checkSignature(archive string, keys []ed25519.PublicKey) error {
+	data, err := os.ReadFile(archive + signatureSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s is missing", errBadSignature, archive+signatureSuffix)
+	}
+	if err != nil {
+		return err
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("%s: %w", archive+signatureSuffix, err)
+	}
+	digest, err := archiveDigest(archive)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key, digest, signature) {
+			return nil
+		}
+	}
+	return errBadSignature
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// runRekey implements "foldermon rekey <backupFolder|archive> --key <new private key> --old-key <public
+// keys>", the last step of a key rotation: it signs existing archives and snapshots again with the new key,
+// once their current signature has been checked against the old public keys, so a tampered archive never
+// gets a valid new signature. Unsigned archives and those that fail the check are reported and left alone.
+func runRekey(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("rekey", flag.ExitOnError)
+	keyPath := fs.String("key", "", "private key to sign the archives with")
+	oldKeys := fs.String("old-key", "", "public keys the archives are signed with now, separated by commas")
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || *keyPath == "" || *oldKeys == "" {
+		return usageError("usage: %s rekey <backupFolder|archive> --key <new private key> --old-key <public keys>", os.Args[0])
+	}
+	key, err := loadPrivateKey(*keyPath)
+	if err != nil {
+		return err
+	}
+	old, err := loadPublicKeys(*oldKeys)
+	if err != nil {
+		return err
+	}
+
+	archives := []string{positional[0]}
+	if info, err := os.Stat(positional[0]); err != nil {
+		return err
+	} else if info.IsDir() {
+		if archives, err = findArchives(positional[0]); err != nil {
+			return err
+		}
+		if isRepository(positional[0]) {
+			snapshots, err := findSnapshots(positional[0])
+			if err != nil {
+				return err
+			}
+			archives = append(archives, snapshots...)
+		}
+	}
+
+	signed, failed := 0, 0
+	for _, archive := range archives {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := checkSignature(archive, old)
+		if err == nil {
+			err = resign(archive, key)
+		}
+		if err != nil {
+			fmt.Printf("SKIPPED  %s: %v\n", filepath.Base(archive), err)
+			failed++
+			continue
+		}
+		fmt.Printf("SIGNED   %s\n", filepath.Base(archive))
+		signed++
+	}
+	fmt.Printf("Signed %d of %d archives with %s\n", signed, len(archives), *keyPath)
+	if failed > 0 {
+		return withExitCode(exitVerifyFailed, fmt.Errorf("%d archives were not signed again", failed))
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// resign replaces the signature of an archive with one made with key, keeping the permissions of the old
+// signature file, which --protect may have made read-only.
+func resign(archive string, key ed25519.PrivateKey) error {
+	info, err := os.Stat(archive + signatureSuffix)
+	if err != nil {
+		return err
+	}
+	digest, err := archiveDigest(archive)
+	if err != nil {
+		return err
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, digest))
+	tmp := archive + signatureSuffix + ".tmp"
+	if err := os.WriteFile(tmp, []byte(signature+"\n"), 0644); err != nil {
+		return err
+	}
+	err = os.Chmod(tmp, info.Mode().Perm())
+	if err == nil {
+		err = os.Rename(tmp, archive+signatureSuffix)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
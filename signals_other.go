@@ -0,0 +1,22 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// notifyPauseSignals relays SIGUSR1 (pause) and SIGUSR2 (resume) to the given channels.
+func notifyPauseSignals(pause, resume chan<- os.Signal) {
+	signal.Notify(pause, syscall.SIGUSR1)
+	signal.Notify(resume, syscall.SIGUSR2)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// notifyReloadSignal relays SIGHUP, which asks for the config file to be read again.
+func notifyReloadSignal(reload chan<- os.Signal) {
+	signal.Notify(reload, syscall.SIGHUP)
+}
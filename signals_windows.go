@@ -0,0 +1,11 @@
+package main
+
+import "os"
+
+// ------------------------------------------------------------------------------------------------------------
+// notifyPauseSignals does nothing: Windows has no user signals to pause and resume archiving with.
+func notifyPauseSignals(pause, resume chan<- os.Signal) {}
+
+// ------------------------------------------------------------------------------------------------------------
+// notifyReloadSignal does nothing: Windows has no SIGHUP.
+func notifyReloadSignal(reload chan<- os.Signal) {}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// zipAndMoveSplit writes one archive per immediate subdirectory of the watch folder, named
+// backup_<timestamp>_<subdir>.zip, plus backup_<timestamp>.zip for the files directly inside it.
+// Entries keep their paths relative to the watch folder, so restoring any of the archives recreates its
+// subdirectory. It returns the paths of the archives written.
+func zipAndMoveSplit(ctx context.Context, watchFolder, backupFolder string, exclude []string) ([]string, error) {
+	if err := archiveSlots.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer archiveSlots.release()
+
+	entries, err := os.ReadDir(watchFolder)
+	if err != nil {
+		return nil, annotate(stageWalk, watchFolder, err)
+	}
+
+	now := time.Now()
+	folder, err := archiveFolder(backupFolder, now)
+	if err != nil {
+		return nil, annotate(stagePrepare, folder, err)
+	}
+	stem := archiveStem(watchFolder, now)
+	var previous map[string]fileState
+	if anomalyFrom(ctx) != nil {
+		previous = previousSplitFiles(backupFolder)
+	}
+	var archives, looseFiles []string
+	for _, entry := range entries {
+		path := filepath.Join(watchFolder, entry.Name())
+		switch {
+		case excluded(exclude, entry.Name()):
+		case entry.IsDir():
+			zipFilePath, err := zipSubset(ctx, watchFolder, []string{path}, filepath.Join(folder, fmt.Sprintf("%s_%s.zip", stem, entry.Name())), backupFolder, exclude, previous)
+			if err != nil {
+				return archives, err
+			}
+			archives = append(archives, zipFilePath)
+		case entry.Name() != pauseFileName:
+			looseFiles = append(looseFiles, path)
+		}
+	}
+
+	if len(looseFiles) == 0 {
+		return archives, nil
+	}
+	zipFilePath, err := zipSubset(ctx, watchFolder, looseFiles, filepath.Join(folder, stem+".zip"), backupFolder, exclude, previous)
+	if err != nil {
+		return archives, err
+	}
+	return append(archives, zipFilePath), nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// zipSubset archives the given files and folders of the watch folder, walking folders recursively, into a
+// full archive at base, or the free name createArchive or publishArchive picks instead, leaving out excluded
+// files, and returns its path. Like zipAndMove, it writes to a temporary name first, which is removed again
+// if anything fails, and catalogs it in the catalog of backupFolder. Only files not in previous as they are
+// now are scanned for anomalies.
+func zipSubset(ctx context.Context, watchFolder string, roots []string, base, backupFolder string, exclude []string, previous map[string]fileState) (_ string, err error) {
+	destPath, zipFile, err := createArchive(base)
+	zipFilePath := destPath + ".tmp"
+	if err != nil {
+		slog.Error("Failed to create zip", "error", err)
+		return "", annotate(stagePrepare, zipFilePath, err)
+	}
+	ctx, span := tracer.Start(ctx, "archive", trace.WithAttributes(attribute.String("foldermon.archive", destPath)))
+	defer func() { endSpan(span, err) }()
+
+	zipWriter := zip.NewWriter(zipFile)
+	m := &manifest{Created: time.Now(), Type: archiveFull}
+
+	if err = walkSlots.acquire(ctx); err != nil {
+		zipFile.Close()
+		os.Remove(zipFilePath)
+		return "", err
+	}
+	_, walkSpan := tracer.Start(ctx, "walk")
+	deferral := newFileDeferral(ctx, watchFolder)
+	archive := newArchiveWriter(ctx, zipWriter, m)
+	archive.previous = previous
+	for _, root := range roots {
+		err = walkFolder(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return annotate(stageWalk, path, err)
+			}
+			if err := ctx.Err(); err != nil {
+				return annotate(stageCompress, zipFilePath, err)
+			}
+			relPath, err := filepath.Rel(watchFolder, path)
+			if err != nil {
+				return annotate(stageWalk, path, err)
+			}
+			if excluded(exclude, filepath.ToSlash(relPath)) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() || deferral.skip(path, info) || skipOversized(&m.Skipped, path, filepath.ToSlash(relPath), info) {
+				return nil
+			}
+			return archive.add(path, filepath.ToSlash(relPath), info)
+		})
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = archive.flush()
+	} else {
+		archive.discard()
+	}
+	walkSlots.release()
+	walkSpan.SetAttributes(attribute.Int("foldermon.files", len(m.Files)))
+	endSpan(walkSpan, err)
+
+	_, compressSpan := tracer.Start(ctx, "compress")
+	if err == nil {
+		err = annotate(stageManifest, zipFilePath, writeManifest(zipWriter, m))
+	}
+	if err == nil {
+		err = annotate(stageCompress, zipFilePath, zipWriter.Close())
+	}
+	if err == nil {
+		err = annotate(stageCompress, zipFilePath, syncAndClose(zipFile))
+	}
+	zipFile.Close()
+	compressSpan.End()
+	if err == nil {
+		err = annotate(stageVerify, zipFilePath, checkWritten(ctx, zipFilePath))
+	}
+	if err == nil {
+		destPath, err = publishArchive(zipFilePath, destPath, base)
+		err = annotate(stageMove, destPath, err)
+	}
+	if err != nil {
+		os.Remove(zipFilePath)
+		slog.Error("Error creating zip archive", "error", err)
+		return "", err
+	}
+
+	archiveFinished(watchFolder, destPath, m, fileSize(destPath))
+	_, catalogSpan := tracer.Start(ctx, "catalog")
+	catalogErr := catalogArchive(backupFolder, destPath, m)
+	endSpan(catalogSpan, catalogErr)
+	if catalogErr != nil {
+		slog.Warn("Failed to update catalog", "error", catalogErr)
+	}
+	return destPath, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// previousSplitFiles returns the files of the previous split run, read from the manifests of the newest
+// archives in the backup folder, which share its timestamp. Split runs keep no backup state, so this is
+// what tells changed files from unchanged ones.
+func previousSplitFiles(backupFolder string) map[string]fileState {
+	archives, err := findArchives(backupFolder)
+	if err != nil || len(archives) == 0 {
+		return nil
+	}
+	latest := archiveTime(archives[len(archives)-1])
+	files := make(map[string]fileState)
+	for i := len(archives) - 1; i >= 0 && archiveTime(archives[i]).Equal(latest); i-- {
+		reader, err := zip.OpenReader(archives[i])
+		if err != nil {
+			continue
+		}
+		m, err := readManifest(&reader.Reader)
+		reader.Close()
+		if err != nil || m == nil {
+			continue
+		}
+		for _, entry := range m.Files {
+			files[entry.Path] = fileState{Size: entry.Size, ModTime: entry.ModTime}
+		}
+	}
+	return files
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const stateFileName = ".foldermon-state.json" // Kept in the backup folder
+
+// backupState is persisted in the backup folder. It describes the outcome of the most recent backup run
+// and the files captured by the last successful and the last full backup, which incremental and
+// differential backups compare against. File maps are keyed by slash-separated relative path.
+type backupState struct {
+	LastRun      time.Time            `json:"last_run"`
+	LastStatus   string               `json:"last_status"` // "success", "failed" or "aborted"
+	LastError    string               `json:"last_error,omitempty"`
+	LastFailure  *backupError         `json:"last_failure,omitempty"`
+	LastArchive  string               `json:"last_archive,omitempty"`
+	Files        map[string]fileState `json:"files,omitempty"`
+	LastFull     string               `json:"last_full,omitempty"`
+	LastFullTime time.Time            `json:"last_full_time,omitempty"`
+	FullFiles    map[string]fileState `json:"full_files,omitempty"`
+	Suspect      string               `json:"suspect,omitempty"` // Why the last archive may hold files damaged by ransomware
+
+	// Deletions observed since the last successful backup, written into the next archive's manifest
+	PendingDeletions []tombstone `json:"pending_deletions,omitempty"`
+}
+
+// fileState identifies the version of a file seen by a backup.
+type fileState struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// changedSince reports whether a file differs from the version recorded in files.
+func changedSince(files map[string]fileState, relPath string, info os.FileInfo) bool {
+	prev, ok := files[relPath]
+	return !ok || prev.Size != info.Size() || !prev.ModTime.Equal(info.ModTime())
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// loadState reads the state file of the backup folder. A missing file yields an empty state.
+func loadState(backupFolder string) (*backupState, error) {
+	state := &backupState{}
+	data, err := os.ReadFile(filepath.Join(backupFolder, stateFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// saveState writes the state file of the backup folder, replacing it atomically.
+func saveState(backupFolder string, state *backupState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(backupFolder, stateFileName)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// recordRun stores the outcome of a backup run in the state file. Failures to write the state are logged
+// but never fail the backup itself.
+func recordRun(backupFolder string, runErr error) {
+	state, err := loadState(backupFolder)
+	if err != nil {
+		slog.Warn("Failed to read backup state", "error", err)
+		state = &backupState{}
+	}
+
+	state.LastRun = time.Now()
+	state.LastStatus, state.LastError, state.LastFailure = "success", "", nil
+	if runErr != nil {
+		state.LastStatus, state.LastError = "failed", runErr.Error()
+		errors.As(runErr, &state.LastFailure)
+		if errors.Is(runErr, context.DeadlineExceeded) {
+			state.LastStatus = "aborted"
+		}
+	}
+
+	if err := saveState(backupFolder, state); err != nil {
+		slog.Warn("Failed to write backup state", "error", err)
+	}
+
+	// Successful runs are cataloged together with their files by the backup itself
+	if runErr != nil {
+		if err := catalogFailure(backupFolder, state.LastStatus, runErr); err != nil {
+			slog.Warn("Failed to update catalog", "error", err)
+		}
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// recordBackup stores a successful backup and the files present in the watch folder when it was taken. A
+// full backup that --detect-anomalies finds suspect, for the given reason, is kept but does not become the
+// base of differential backups, nor restart the --full-every period.
+func recordBackup(backupFolder, archiveName string, m *manifest, files map[string]fileState, suspect string) error {
+	state, err := loadState(backupFolder)
+	if err != nil {
+		return err
+	}
+	state.LastArchive, state.Files, state.PendingDeletions, state.Suspect = archiveName, files, nil, suspect
+	if m.Type == archiveFull && suspect == "" {
+		state.LastFull, state.LastFullTime, state.FullFiles = archiveName, m.Created, files
+	}
+	return saveState(backupFolder, state)
+}
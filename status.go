@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var startTime = time.Now()
+
+// running holds the monitors currently watching, for the /status endpoint.
+var running = struct {
+	sync.Mutex
+	monitors map[*monitor]bool
+}{monitors: make(map[*monitor]bool)}
+
+// watchStatus is the live state of a monitor, as reported on /status.
+type watchStatus struct {
+	Watch           string          `json:"watch"`
+	Backup          string          `json:"backup"`
+	BackingUp       bool            `json:"backing_up"`
+	Queued          bool            `json:"queued"` // A backup is deferred by a pause, --min-interval, a blackout window or a retry
+	Paused          bool            `json:"paused"`
+	LastEvent       *time.Time      `json:"last_event,omitempty"`
+	LastBackup      *time.Time      `json:"last_backup,omitempty"` // When the last backup run ended
+	LastResult      string          `json:"last_result,omitempty"` // success, failed, aborted or canceled
+	LastError       string          `json:"last_error,omitempty"`
+	Watcher         string          `json:"watcher"`                    // native or poll
+	WatcherFallback string          `json:"watcher_fallback,omitempty"` // Why a native watch is polled instead
+	FreeBytes       *int64          `json:"free_bytes,omitempty"`       // Free space in the backup folder after the last backup
+	Progress        *backupProgress `json:"progress,omitempty"`         // Of the backup running, if any
+	Successes       int             `json:"successes"`                  // Successful runs since foldermon started
+	Failures        int             `json:"failures"`                   // Failed or aborted runs since foldermon started
+	BytesToday      int64           `json:"bytes_today"`                // Written to archives since midnight
+}
+
+// archivedToday counts the bytes written to archives per watch folder since midnight.
+var archivedToday = struct {
+	sync.Mutex
+	day   time.Time
+	bytes map[string]int64
+}{bytes: make(map[string]int64)}
+
+// statusReport is the JSON document served on /status.
+type statusReport struct {
+	PID        int           `json:"pid"`
+	Started    time.Time     `json:"started"`
+	Uptime     float64       `json:"uptime_seconds"`
+	QueueDepth int           `json:"queue_depth"` // Watches with a backup waiting to run
+	LastError  string        `json:"last_error,omitempty"`
+	Watches    []watchStatus `json:"watches"`
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// updateStatus applies change to the monitor's status.
+func (mon *monitor) updateStatus(change func(s *watchStatus)) {
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+	change(&mon.status)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// finishStatus records the outcome of a backup run in the monitor's status.
+func (mon *monitor) finishStatus(err error) {
+	mon.updateStatus(func(s *watchStatus) {
+		now := time.Now()
+		s.BackingUp, s.LastBackup, s.LastResult, s.LastError = false, &now, runResult(err), ""
+		mon.progress = nil
+		switch {
+		case err == nil:
+			s.Successes++
+		case !errors.Is(err, context.Canceled):
+			s.Failures++
+		}
+		if err != nil {
+			s.LastError = err.Error()
+		}
+		if free, err := freeSpace(s.Backup); err == nil {
+			s.FreeBytes = &free
+		}
+	})
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// countArchived adds the size of a finished archive to the bytes archived today for its watch folder.
+func countArchived(watchFolder string, bytes int64) {
+	archivedToday.Lock()
+	defer archivedToday.Unlock()
+	resetArchivedToday()
+	archivedToday.bytes[watchFolder] += bytes
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// resetArchivedToday starts counting from zero once a new day begins. archivedToday must be locked.
+func resetArchivedToday() {
+	year, month, day := time.Now().Date()
+	if today := time.Date(year, month, day, 0, 0, 0, 0, time.Local); !today.Equal(archivedToday.day) {
+		archivedToday.day, archivedToday.bytes = today, make(map[string]int64)
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// runResult names the outcome of a backup run: success, failed, aborted (by --max-duration) or canceled.
+func runResult(err error) string {
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "aborted"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "failed"
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// currentStatus returns the status of every running monitor, ordered by watch folder.
+func currentStatus() statusReport {
+	report := statusReport{
+		PID:     os.Getpid(),
+		Started: startTime,
+		Uptime:  time.Since(startTime).Round(time.Second).Seconds(),
+		Watches: []watchStatus{},
+	}
+	var lastFailure time.Time
+	archivedToday.Lock()
+	resetArchivedToday()
+	today := maps.Clone(archivedToday.bytes)
+	archivedToday.Unlock()
+	running.Lock()
+	for mon := range running.monitors {
+		mon.mu.Lock()
+		s := mon.status
+		if mon.progress != nil {
+			p := mon.progress.snapshot()
+			s.Progress = &p
+		}
+		mon.mu.Unlock()
+		s.BytesToday = today[s.Watch]
+
+		report.Watches = append(report.Watches, s)
+		if s.Queued {
+			report.QueueDepth++
+		}
+		if s.LastError != "" && s.LastBackup.After(lastFailure) {
+			report.LastError, lastFailure = s.LastError, *s.LastBackup
+		}
+	}
+	running.Unlock()
+	sort.Slice(report.Watches, func(i, j int) bool { return report.Watches[i].Watch < report.Watches[j].Watch })
+	return report
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// handleStatus serves the status of the running monitors as JSON.
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(currentStatus())
+}
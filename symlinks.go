@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Symbolic link policies selectable with --symlinks.
+const (
+	symlinksFollow = "follow" // Archive what links point to, descending into linked folders unless that loops
+	symlinksStore  = "store"  // Archive the links themselves, restored as links
+	symlinksSkip   = "skip"   // Leave links out
+)
+
+// symlinkPolicy is how backups treat symbolic links in the watch folder, set by --symlinks.
+var symlinkPolicy = symlinksFollow
+
+// ------------------------------------------------------------------------------------------------------------
+// walkFolder walks root like filepath.Walk, treating symbolic links according to symlinkPolicy. With
+// symlinksStore, fn sees links with their own file info. With symlinksFollow, it sees the file a link
+// points to under the link's path, and walks linked folders as if they were in place. root itself is
+// always walked, even if it is a link.
+func walkFolder(root string, fn filepath.WalkFunc) error {
+	rootReal, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	return walkLinked(root, rootReal, []string{rootReal}, fn)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// walkLinked walks dir, presenting the paths below it as below path. ancestors are the real paths of the
+// folders being walked, used to detect links that lead back into them.
+func walkLinked(path, dir string, ancestors []string, fn filepath.WalkFunc) error {
+	return filepath.Walk(dir, func(fsPath string, info os.FileInfo, err error) error {
+		shown := fsPath
+		if dir != path {
+			rel, _ := filepath.Rel(dir, fsPath)
+			shown = filepath.Join(path, rel)
+		}
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			return fn(shown, info, err)
+		}
+
+		switch symlinkPolicy {
+		case symlinksSkip:
+			slog.Debug("Skipped symbolic link", "path", shown)
+			return nil
+		case symlinksStore:
+			return fn(shown, info, nil)
+		}
+
+		target, err := os.Stat(fsPath)
+		if err != nil {
+			slog.Warn("Skipped broken symbolic link", "path", shown, "error", err)
+			return nil
+		}
+		if !target.IsDir() {
+			return fn(shown, target, nil)
+		}
+		real, err := filepath.EvalSymlinks(fsPath)
+		if err != nil {
+			return fn(shown, nil, err)
+		}
+		parent, err := filepath.EvalSymlinks(filepath.Dir(fsPath))
+		if err != nil {
+			return fn(shown, nil, err)
+		}
+		for _, a := range append(ancestors, parent) {
+			if pathWithin(real, a) {
+				slog.Warn("Skipped symbolic link that loops back into its parent folders", "path", shown, "target", real)
+				return nil
+			}
+		}
+		return walkLinked(shown, real, append(ancestors[:len(ancestors):len(ancestors)], real), fn)
+	})
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// pathWithin reports whether path is dir or lies below it.
+func pathWithin(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// checkLinkParents returns an error if a folder between targetDir and target is a symbolic link, so a
+// restored link cannot redirect later files outside the target directory.
+func checkLinkParents(targetDir, target string) error {
+	rel, err := filepath.Rel(targetDir, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return err
+	}
+	dir := targetDir
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		if info, err := os.Lstat(dir); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to restore %s through symbolic link %s", target, dir)
+		}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// restoreLink creates a symbolic link at target, replacing whatever is there.
+func restoreLink(linkTarget, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Symlink(linkTarget, target)
+}
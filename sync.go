@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// copySync mirrors the watch folder to --sync-to as it changes: each changed path is copied, or removed
+// with --sync-delete, about a second after its event, and every backup run compares the whole folder.
+const copySync = "sync"
+
+var (
+	syncTo     string // Folder --copy sync mirrors the watch folder to, e.g. a mounted remote share
+	syncDelete bool   // Remove files from syncTo once they are removed from the watch folder
+)
+
+const syncDelay = time.Second // How long changes are collected before they are synced
+
+// ------------------------------------------------------------------------------------------------------------
+// syncChanges brings the given paths of the watch folder, relative and slash-separated, up to date in
+// syncTo. A path is looked at as it is now rather than by the events seen for it: a file is copied, a folder
+// created or moved in is copied with everything in it, and a path that is gone is removed with
+// --sync-delete. The first error stops the sync.
+func syncChanges(ctx context.Context, watchFolder string, exclude []string, paths map[string]bool) error {
+	for relPath := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		path := filepath.Join(watchFolder, filepath.FromSlash(relPath))
+		target := filepath.Join(syncTo, filepath.FromSlash(relPath))
+		info, err := os.Lstat(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			if !syncDelete {
+				continue
+			}
+			if _, err := os.Lstat(target); err != nil {
+				continue
+			}
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			log.Printf("Removed from %s: %s\n", syncTo, relPath)
+		case err != nil:
+			return annotate(stageRead, path, err)
+		case info.IsDir():
+			if err := syncFolder(ctx, watchFolder, path, exclude); err != nil {
+				return err
+			}
+		default:
+			if err := syncFile(ctx, path, relPath, target, info); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// syncFolder copies the files of a folder in the watch folder that syncTo is missing or has a different
+// copy of.
+func syncFolder(ctx context.Context, watchFolder, folder string, exclude []string) error {
+	return walkFolder(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return annotate(stageWalk, path, err)
+		}
+		relPath, err := filepath.Rel(watchFolder, path)
+		if err != nil {
+			return annotate(stageWalk, path, err)
+		}
+		relPath = filepath.ToSlash(relPath)
+		if excluded(exclude, relPath) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || path == filepath.Join(watchFolder, pauseFileName) {
+			return nil
+		}
+		return syncFile(ctx, path, relPath, filepath.Join(syncTo, filepath.FromSlash(relPath)), info)
+	})
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// syncFile copies a file to target unless the copy there already has its size and modification time.
+func syncFile(ctx context.Context, path, relPath, target string, info os.FileInfo) error {
+	if copied, err := os.Lstat(target); err == nil && copied.Size() == info.Size() && copied.ModTime().Equal(info.ModTime()) {
+		return nil
+	}
+	m := &manifest{}
+	if err := copyFile(ctx, m, path, relPath, target, info); err != nil {
+		return err
+	}
+	if len(m.Skipped) > 0 {
+		slog.Warn("Cannot sync file", "path", path, "reason", m.Skipped[0].Reason)
+		return nil
+	}
+	slog.Info("Synced file", "event", "file_synced", "path", path, "destination", target)
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslogFacilities maps the facility names accepted by --syslog-facility to their RFC 5424 codes.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogHandler sends log records to a syslog server as RFC 5424 messages, over UDP (one message per
+// datagram), TCP (octet-counted framing, RFC 6587) or the local syslog socket.
+type syslogHandler struct {
+	network  string
+	address  string
+	facility int
+	level    slog.Level
+	hostname string
+	attrs    []slog.Attr
+	mu       *sync.Mutex
+	conn     *net.Conn
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newSyslogHandler connects to the syslog destination given with --syslog: "local", "udp://host[:514]" or
+// "tcp://host[:601]".
+func newSyslogHandler(destination, facility string, level slog.Level) (*syslogHandler, error) {
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	h := &syslogHandler{facility: code, level: level, mu: &sync.Mutex{}, conn: new(net.Conn)}
+	h.hostname, _ = os.Hostname()
+
+	if destination == "local" {
+		h.network, h.address = "unixgram", localSyslogSocket()
+		if h.address == "" {
+			return nil, fmt.Errorf("no local syslog on %s, give udp:// or tcp:// instead", runtime.GOOS)
+		}
+	} else {
+		u, err := url.Parse(destination)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return nil, fmt.Errorf("bad syslog destination %q (want local, udp://host:port or tcp://host:port)", destination)
+		}
+		h.network, h.address = u.Scheme, u.Host
+		if u.Port() == "" {
+			port := "514"
+			if u.Scheme == "tcp" {
+				port = "601"
+			}
+			h.address = net.JoinHostPort(u.Host, port)
+		}
+	}
+
+	if err := h.connect(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// localSyslogSocket returns the path of the local syslog socket, or "" if there is none.
+func localSyslogSocket() string {
+	for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// connect (re)opens the connection to the syslog destination. Callers hold h.mu, except newSyslogHandler.
+func (h *syslogHandler) connect() error {
+	if *h.conn != nil {
+		(*h.conn).Close()
+	}
+	conn, err := net.DialTimeout(h.network, h.address, 5*time.Second)
+	if err != nil {
+		*h.conn = nil
+		return fmt.Errorf("syslog: %w", err)
+	}
+	*h.conn = conn
+	return nil
+}
+
+func (h *syslogHandler) Enabled(_ context.Context, level slog.Level) bool { return level >= h.level }
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler { return h }
+
+// ------------------------------------------------------------------------------------------------------------
+// Handle sends a record, reconnecting once if the connection was lost.
+func (h *syslogHandler) Handle(_ context.Context, r slog.Record) error {
+	// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d - - %s", h.facility*8+syslogSeverity(r.Level), r.Time.Format(time.RFC3339Nano),
+		nilValue(h.hostname), "foldermon", os.Getpid(), formatMessage(r, h.attrs))
+	if h.network == "tcp" {
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if *h.conn != nil {
+		if _, err := (*h.conn).Write([]byte(msg)); err == nil {
+			return nil
+		}
+	}
+	if err := h.connect(); err != nil {
+		return err
+	}
+	_, err := (*h.conn).Write([]byte(msg))
+	return err
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// syslogSeverity maps a log level to a syslog severity.
+func syslogSeverity(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3 // err
+	case level >= slog.LevelWarn:
+		return 4 // warning
+	case level >= slog.LevelInfo:
+		return 6 // info
+	default:
+		return 7 // debug
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// nilValue returns s with spaces removed, or the RFC 5424 NILVALUE "-" if it is empty.
+func nilValue(s string) string {
+	s = strings.ReplaceAll(s, " ", "")
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	uploadLimit int64        // Bytes per second copy processors may transfer, set by --upload-limit; 0 is unlimited
+	uploadHours *dailyWindow // When uploadLimit applies, set by --upload-limit-hours; nil is always
+)
+
+// dailyWindow is a time of day range such as 08:00-18:00. A range ending before it starts wraps past
+// midnight.
+type dailyWindow struct {
+	from, to time.Duration // Since midnight
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// parseDailyWindow parses a time of day range such as "08:00-18:00".
+func parseDailyWindow(s string) (*dailyWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if ok {
+		start, err1 := time.Parse("15:04", strings.TrimSpace(from))
+		end, err2 := time.Parse("15:04", strings.TrimSpace(to))
+		if err1 == nil && err2 == nil {
+			return &dailyWindow{from: sinceMidnight(start), to: sinceMidnight(end)}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid time range %q, want e.g. 08:00-18:00", s)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// contains reports whether t falls within the window on its day.
+func (w *dailyWindow) contains(t time.Time) bool {
+	now := sinceMidnight(t)
+	if w.from <= w.to {
+		return now >= w.from && now < w.to
+	}
+	return now >= w.from || now < w.to
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// sinceMidnight returns the time of day of t.
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// parseRate parses a transfer rate such as "5MB/s", using the units of parseSize.
+func parseRate(s string) (int64, error) {
+	rate, err := parseSize(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "/s"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q, want e.g. 5MB/s", s)
+	}
+	return rate, nil
+}
+
+// throttledReader reads no faster than uploadLimit while it applies, and stops when ctx ends.
+type throttledReader struct {
+	ctx   context.Context
+	r     io.Reader
+	start time.Time // Start of the current limited stretch
+	n     int64     // Bytes read since start
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// Read reads in slices of about a tenth of a second's worth, sleeping after each as long as needed to stay
+// at the limit, so the link is shared smoothly rather than in bursts.
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if err := t.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if uploadLimit <= 0 || (uploadHours != nil && !uploadHours.contains(time.Now())) {
+		t.start, t.n = time.Time{}, 0
+		return t.r.Read(p)
+	}
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	if slice := max(uploadLimit/10, 1); int64(len(p)) > slice {
+		p = p[:slice]
+	}
+	n, err := t.r.Read(p)
+	t.n += int64(n)
+	if wait := time.Duration(float64(t.n)/float64(uploadLimit)*float64(time.Second)) - time.Since(t.start); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-t.ctx.Done():
+			return n, t.ctx.Err()
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"time"
+	_ "time/tzdata" // Zone names work on systems without a zone database, e.g. Windows
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// setTimezone makes name, an IANA zone such as Europe/Lisbon, UTC or Local, the zone of archive names,
+// manifests, log lines and schedules, set by --timezone or --timestamp-utc. It must run before any
+// goroutine reads the time.
+func setTimezone(name string, utc bool) error {
+	if utc {
+		if name != "" && name != "UTC" {
+			return fmt.Errorf("--timestamp-utc conflicts with --timezone %s", name)
+		}
+		name = "UTC"
+	}
+	if name == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("invalid --timezone: %w", err)
+	}
+	time.Local = loc
+	return nil
+}
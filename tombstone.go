@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const tombstoneLogName = "tombstones.log" // Deletion history kept in the backup folder, one JSON object per line
+
+// tombstone records that a file disappeared from the watch folder.
+type tombstone struct {
+	Path string    `json:"path"` // Slash-separated, relative to the watch folder
+	Time time.Time `json:"time"`
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// recordDeletion appends a removed file to the tombstone log and queues it for the manifest of the next
+// archive.
+func recordDeletion(watchFolder, backupFolder, path string) {
+	relPath, err := filepath.Rel(watchFolder, path)
+	if err != nil {
+		return
+	}
+	t := tombstone{Path: filepath.ToSlash(relPath), Time: time.Now()}
+	slog.Info("Detected deletion", "event", "file_deleted", "path", path)
+
+	if err := appendTombstone(backupFolder, t); err != nil {
+		slog.Warn("Failed to write tombstone log", "error", err)
+	}
+
+	state, err := loadState(backupFolder)
+	if err == nil {
+		state.PendingDeletions = append(state.PendingDeletions, t)
+		err = saveState(backupFolder, state)
+	}
+	if err != nil {
+		slog.Warn("Failed to record deletion", "error", err)
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// appendTombstone adds an entry to the tombstone log.
+func appendTombstone(backupFolder string, t tombstone) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(backupFolder, tombstoneLogName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// deletionsSince combines the deletions observed by the watcher with files that were present in the
+// previous backup but are missing now, which also catches deletions made while foldermon was not running.
+func deletionsSince(pending []tombstone, previous, current map[string]fileState) []tombstone {
+	deleted := make([]tombstone, 0, len(pending))
+	seen := make(map[string]bool)
+	for _, t := range pending {
+		if _, exists := current[t.Path]; !exists && !seen[t.Path] {
+			deleted = append(deleted, t)
+			seen[t.Path] = true
+		}
+	}
+
+	now := time.Now()
+	for path := range previous {
+		if _, exists := current[path]; !exists && !seen[path] {
+			deleted = append(deleted, tombstone{Path: path, Time: now})
+			seen[path] = true
+		}
+	}
+	return deleted
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// verifyAfterWrite reads every archive back after writing it and fails the backup unless it verifies,
+// set by --verify-after-write.
+var verifyAfterWrite bool
+
+// errVerifyFailed fails a backup whose archive did not verify after writing.
+var errVerifyFailed = errors.New("archive failed verification")
+
+// ------------------------------------------------------------------------------------------------------------
+// runVerify implements "foldermon verify <archive|--all <backupFolder>> [--key <public key>] [--output json]".
+// Every entry is read back to validate its CRC and, when the archive carries a manifest, compared against
+// the recorded SHA-256 sum. With --key, the archive's signature is checked as well.
+func runVerify(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	all := fs.Bool("all", false, "verify every archive in the given backup folder")
+	keyPath := fs.String("key", "", "public keys to check archive signatures with, separated by commas")
+	output := outputFlag(fs)
+	positional, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("usage: %s verify <archive|--all <backupFolder>> [--key <public key>] [--output json]", os.Args[0])
+	}
+	if err := checkOutput(*output); err != nil {
+		return err
+	}
+	var keys []ed25519.PublicKey
+	if *keyPath != "" {
+		if keys, err = loadPublicKeys(*keyPath); err != nil {
+			return err
+		}
+	}
+
+	archives := []string{positional[0]}
+	if *all {
+		archives, err = findArchives(positional[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	results := []verifyResult{}
+	for _, archivePath := range archives {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		problems := verifyArchive(ctx, archivePath)
+		if keys != nil {
+			if err := checkSignature(archivePath, keys); err != nil {
+				problems = append(problems, fmt.Sprintf("signature: %v", err))
+			}
+		}
+		results = append(results, verifyResult{Archive: archivePath, OK: len(problems) == 0, Problems: append([]string{}, problems...)})
+		if len(problems) > 0 {
+			failed++
+		}
+		switch {
+		case *output == outputJSON:
+			continue
+		case len(problems) == 0:
+			fmt.Printf("OK      %s\n", filepath.Base(archivePath))
+			continue
+		}
+		fmt.Printf("FAILED  %s\n", filepath.Base(archivePath))
+		for _, problem := range problems {
+			fmt.Printf("        %s\n", problem)
+		}
+	}
+	if *output == outputJSON {
+		if err := printJSON(results); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return withExitCode(exitVerifyFailed, fmt.Errorf("%d of %d archives failed verification", failed, len(archives)))
+	}
+	return nil
+}
+
+// verifyResult is the outcome for one archive, as printed by "foldermon verify --output json".
+type verifyResult struct {
+	Archive  string   `json:"archive"`
+	OK       bool     `json:"ok"`
+	Problems []string `json:"problems"`
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// verifyArchive checks a single archive and returns a description of every problem found.
+func verifyArchive(ctx context.Context, archivePath string) []string {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	defer reader.Close()
+
+	var problems []string
+	m, err := readManifest(&reader.Reader)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("%s: %v", manifestName, err))
+	}
+
+	sums := make(map[string]string)
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() || file.Name == manifestName {
+			continue
+		}
+		sum, err := hashEntry(ctx, file)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", file.Name, err))
+		}
+		sums[file.Name] = sum
+	}
+
+	if m == nil {
+		return problems
+	}
+	for _, entry := range m.Files {
+		sum, ok := sums[entry.Path]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s: listed in manifest but missing from archive", entry.Path))
+		case sum != "" && sum != entry.SHA256:
+			problems = append(problems, fmt.Sprintf("%s: SHA-256 mismatch", entry.Path))
+		}
+	}
+	return problems
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// checkWritten verifies a closed archive before it is given its final name, with --verify-after-write.
+func checkWritten(ctx context.Context, archivePath string) error {
+	if !verifyAfterWrite {
+		return nil
+	}
+	problems := verifyArchive(ctx, archivePath)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", errVerifyFailed, strings.Join(problems, "; "))
+	}
+	slog.Debug("Archive verified", "path", archivePath)
+	return nil
+}
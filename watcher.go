@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher delivers filesystem events for watched folders. Backends translate their platform's
+// notifications into fsnotify events so the monitor loop never deals with platform quirks directly.
+type Watcher interface {
+	Add(path string) error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Close() error
+}
+
+// Watcher backends selectable with --watcher.
+const (
+	watcherNative = "native" // inotify (Linux), kqueue (BSD, macOS), ReadDirectoryChangesW (Windows)
+	watcherPoll   = "poll"   // periodic directory scans, works everywhere
+)
+
+// watchLimitHint tells users how to lift the limits watchLimitReached detects.
+const watchLimitHint = "raise fs.inotify.max_user_watches and fs.inotify.max_user_instances with sysctl on Linux, or the open file limit (ulimit -n) elsewhere"
+
+// ------------------------------------------------------------------------------------------------------------
+// watchLimitReached reports whether a native watcher failed because the system ran out of notification
+// resources: inotify watches (ENOSPC) or instances (EMFILE) on Linux, file descriptors for kqueue.
+func watchLimitReached(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EMFILE)
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// openWatcher creates a watcher backend and adds a folder to it.
+func openWatcher(backend string, pollInterval time.Duration, folder string) (Watcher, error) {
+	watcher, err := newWatcher(backend, pollInterval)
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(folder); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	return watcher, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newWatcher creates the watcher backend with the given name.
+func newWatcher(backend string, pollInterval time.Duration) (Watcher, error) {
+	switch backend {
+	case watcherNative:
+		return newNativeWatcher()
+	case watcherPoll:
+		return newPollWatcher(pollInterval), nil
+	default:
+		return nil, fmt.Errorf("unknown watcher backend %q (want %s or %s)", backend, watcherNative, watcherPoll)
+	}
+}
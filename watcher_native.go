@@ -0,0 +1,23 @@
+package main
+
+import "github.com/fsnotify/fsnotify"
+
+// nativeWatcher uses the operating system's change notification API through fsnotify.
+type nativeWatcher struct {
+	watcher *fsnotify.Watcher
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newNativeWatcher creates a watcher backed by fsnotify.
+func newNativeWatcher() (*nativeWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &nativeWatcher{watcher: watcher}, nil
+}
+
+func (w *nativeWatcher) Add(path string) error         { return w.watcher.Add(path) }
+func (w *nativeWatcher) Events() <-chan fsnotify.Event { return w.watcher.Events }
+func (w *nativeWatcher) Errors() <-chan error          { return w.watcher.Errors }
+func (w *nativeWatcher) Close() error                  { return w.watcher.Close() }
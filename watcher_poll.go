@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pollWatcher detects changes by listing watched folders at a fixed interval and comparing each listing
+// with the previous one. It is slower than native notifications but does not depend on kernel support.
+type pollWatcher struct {
+	interval time.Duration
+	events   chan fsnotify.Event
+	errors   chan error
+	done     chan struct{}
+	once     sync.Once
+
+	mu        sync.Mutex
+	snapshots map[string]map[string]os.FileInfo // Watched folder -> entries seen in the last scan
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newPollWatcher creates a polling watcher and starts its scan loop.
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	w := &pollWatcher{
+		interval:  interval,
+		events:    make(chan fsnotify.Event),
+		errors:    make(chan error),
+		done:      make(chan struct{}),
+		snapshots: make(map[string]map[string]os.FileInfo),
+	}
+	go w.run()
+	return w
+}
+
+func (w *pollWatcher) Events() <-chan fsnotify.Event { return w.events }
+func (w *pollWatcher) Errors() <-chan error          { return w.errors }
+
+// ------------------------------------------------------------------------------------------------------------
+// Add starts watching a folder. Existing entries are recorded without generating events.
+func (w *pollWatcher) Add(path string) error {
+	snapshot, err := scanFolder(path)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.snapshots[path] = snapshot
+	w.mu.Unlock()
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// Close stops the scan loop.
+func (w *pollWatcher) Close() error {
+	w.once.Do(func() { close(w.done) })
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// run scans all watched folders on every tick until the watcher is closed.
+func (w *pollWatcher) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		folders := make([]string, 0, len(w.snapshots))
+		for folder := range w.snapshots {
+			folders = append(folders, folder)
+		}
+		w.mu.Unlock()
+
+		for _, folder := range folders {
+			if !w.poll(folder) {
+				return
+			}
+		}
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// poll rescans one folder and emits an event for every entry that appeared, changed or disappeared.
+// It returns false if the watcher was closed while delivering events.
+func (w *pollWatcher) poll(folder string) bool {
+	current, err := scanFolder(folder)
+	if err != nil {
+		return w.send(nil, err)
+	}
+
+	w.mu.Lock()
+	previous := w.snapshots[folder]
+	w.snapshots[folder] = current
+	w.mu.Unlock()
+
+	for name, info := range current {
+		old, ok := previous[name]
+		switch {
+		case !ok:
+			if !w.send(&fsnotify.Event{Name: filepath.Join(folder, name), Op: fsnotify.Create}, nil) {
+				return false
+			}
+		case old.Size() != info.Size() || !old.ModTime().Equal(info.ModTime()):
+			if !w.send(&fsnotify.Event{Name: filepath.Join(folder, name), Op: fsnotify.Write}, nil) {
+				return false
+			}
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			if !w.send(&fsnotify.Event{Name: filepath.Join(folder, name), Op: fsnotify.Remove}, nil) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// send delivers an event or an error, giving up if the watcher is closed.
+func (w *pollWatcher) send(event *fsnotify.Event, err error) bool {
+	if event != nil {
+		select {
+		case w.events <- *event:
+			return true
+		case <-w.done:
+			return false
+		}
+	}
+	select {
+	case w.errors <- err:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// scanFolder returns the file info of every entry directly inside folder, keyed by name.
+func scanFolder(folder string) (map[string]os.FileInfo, error) {
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := make(map[string]os.FileInfo, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue // Removed between listing and stat, picked up on the next scan
+		}
+		snapshot[entry.Name()] = info
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// webhookSecretEnv holds the key webhook payloads are signed with.
+const webhookSecretEnv = "FOLDERMON_WEBHOOK_SECRET"
+
+const (
+	webhookAttempts = 5                // Deliveries are tried this often before giving up
+	webhookTimeout  = 10 * time.Second // Per attempt
+)
+
+// webhookPayload is the JSON document posted for each backup event.
+type webhookPayload struct {
+	Event    string    `json:"event"` // backup_started, backup_finished, backup_failed, backup_aborted or backup_report
+	Time     time.Time `json:"time"`
+	Host     string    `json:"host"`
+	Watch    string    `json:"watch"`
+	Backup   string    `json:"backup,omitempty"`  // backup_started only
+	Archive  string    `json:"archive,omitempty"` // backup_finished only, like the fields below
+	Type     string    `json:"type,omitempty"`
+	Files    int       `json:"files,omitempty"`
+	Size     int64     `json:"size,omitempty"` // Archive size, or new data stored for dedup snapshots
+	Duration float64   `json:"duration_seconds,omitempty"`
+	SHA256   string    `json:"sha256,omitempty"` // Of the archive or snapshot file
+	Error    string    `json:"error,omitempty"`  // backup_failed and backup_aborted only
+	Stage    string    `json:"stage,omitempty"`
+	RetryIn  string    `json:"retry_in,omitempty"` // If the backup will be retried, e.g. "2m0s"
+	Backups  int       `json:"backups,omitempty"`  // backup_report only, like the fields below; Size is their total
+	Failures int       `json:"failures,omitempty"`
+	Skipped  int       `json:"skipped,omitempty"`
+}
+
+// webhook posts backup events to a URL, one at a time and in order.
+type webhook struct {
+	url    string
+	secret []byte
+	queue  chan backupNotice
+	client *http.Client
+	encode func(n backupNotice) (any, error) // Document posted for an event, webhookPayload by default
+}
+
+// webhookHandler is the slog sink feeding a webhook.
+type webhookHandler struct {
+	*webhook
+	attrs []slog.Attr
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newWebhook starts delivering backup events to rawURL. Payloads are signed with secret unless it is empty.
+func newWebhook(rawURL, secret string) (*webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("must be an http or https URL, got %q", rawURL)
+	}
+	hook := &webhook{
+		url:    rawURL,
+		secret: []byte(secret),
+		queue:  make(chan backupNotice, 100),
+		client: &http.Client{Timeout: webhookTimeout},
+		encode: func(n backupNotice) (any, error) { return newWebhookPayload(n), nil },
+	}
+	go func() {
+		for n := range hook.queue {
+			if err := hook.deliver(n); err != nil {
+				log.Println("Failed to call webhook:", err)
+			}
+			pendingNotifications.Done()
+		}
+	}()
+	return hook, nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// handler returns the slog sink passing backup events to the webhook.
+func (hook *webhook) handler() slog.Handler {
+	return &webhookHandler{webhook: hook}
+}
+
+func (h *webhookHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (h *webhookHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &clone
+}
+
+func (h *webhookHandler) WithGroup(name string) slog.Handler { return h }
+
+// ------------------------------------------------------------------------------------------------------------
+// Handle queues backup events for delivery.
+func (h *webhookHandler) Handle(_ context.Context, r slog.Record) error {
+	if n, ok := noticeFromRecord(r, h.attrs); ok {
+		h.enqueue(n)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// enqueue queues a backup event for delivery. Events are dropped if the queue is full because the URL has
+// been unreachable for a long time.
+func (hook *webhook) enqueue(n backupNotice) {
+	pendingNotifications.Add(1)
+	select {
+	case hook.queue <- n:
+	default:
+		pendingNotifications.Done()
+		log.Println("Webhook queue full, dropped", n.Event, "event")
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// deliver posts a backup event, retrying with exponential backoff on network errors and server errors.
+func (hook *webhook) deliver(n backupNotice) error {
+	payload, err := hook.encode(n)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err = hook.post(n.Event, body)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		var permanent *webhookRejected
+		if errors.As(err, &permanent) {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// webhookRejected is a response that retrying will not change, such as 400 or 404.
+type webhookRejected struct {
+	status string
+}
+
+func (e *webhookRejected) Error() string { return "webhook rejected the event: " + e.status }
+
+// ------------------------------------------------------------------------------------------------------------
+// post sends one delivery attempt. With a secret, the X-Foldermon-Signature header carries
+// "sha256=<hex HMAC-SHA256 of the body>".
+func (hook *webhook) post(event string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, hook.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "foldermon")
+	req.Header.Set("X-Foldermon-Event", event)
+	if len(hook.secret) > 0 {
+		mac := hmac.New(sha256.New, hook.secret)
+		mac.Write(body)
+		req.Header.Set("X-Foldermon-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := hook.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	default:
+		return &webhookRejected{status: resp.Status}
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// newWebhookPayload converts a backup event into its webhook payload, checksumming the archive of
+// finished backups.
+func newWebhookPayload(n backupNotice) webhookPayload {
+	host, _ := os.Hostname()
+	p := webhookPayload{
+		Event:   n.Event,
+		Time:    n.Time,
+		Host:    host,
+		Watch:   n.watch(),
+		Backup:  n.Attrs["backup"],
+		Archive: n.Attrs["archive"],
+		Type:    n.Attrs["type"],
+		Error:   n.Attrs["error"],
+		Stage:   n.Attrs["stage"],
+		RetryIn: n.Attrs["retry_in"],
+	}
+	p.Files, _ = strconv.Atoi(n.Attrs["files"])
+	p.Size, _ = strconv.ParseInt(n.Attrs["bytes"], 10, 64)
+	p.Duration, _ = strconv.ParseFloat(n.Attrs["duration"], 64)
+	p.Backups, _ = strconv.Atoi(n.Attrs["backups"])
+	p.Failures, _ = strconv.Atoi(n.Attrs["failures"])
+	p.Skipped, _ = strconv.Atoi(n.Attrs["skipped"])
+	if p.Archive != "" {
+		if sum, err := fileSHA256(p.Archive); err == nil {
+			p.SHA256 = sum
+		}
+	}
+	return p
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// fileSHA256 returns the hex SHA-256 sum of a file.
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := copyData(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
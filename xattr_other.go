@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+// ------------------------------------------------------------------------------------------------------------
+// captureAttrs records nothing, as extended attributes are not supported on this platform.
+func captureAttrs(path string, entry *manifestEntry) error {
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// applyAttrs does nothing on this platform.
+func applyAttrs(path string, entry manifestEntry) error {
+	return nil
+}
@@ -0,0 +1,69 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"bytes"
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// ------------------------------------------------------------------------------------------------------------
+// captureAttrs records the extended attributes of a file in its manifest entry. POSIX ACLs are stored as
+// the system.posix_acl_access and system.posix_acl_default attributes on Linux, so they are included.
+func captureAttrs(path string, entry *manifestEntry) error {
+	names, err := xattrRead(func(dest []byte) (int, error) { return unix.Listxattr(path, dest) })
+	if err != nil || len(names) == 0 {
+		return ignoreUnsupported(err)
+	}
+	for _, name := range bytes.Split(bytes.TrimRight(names, "\x00"), []byte{0}) {
+		value, err := xattrRead(func(dest []byte) (int, error) { return unix.Getxattr(path, string(name), dest) })
+		if err != nil {
+			return err
+		}
+		if entry.Xattrs == nil {
+			entry.Xattrs = make(map[string][]byte)
+		}
+		entry.Xattrs[string(name)] = value
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// applyAttrs sets the extended attributes recorded for a file on its restored copy.
+func applyAttrs(path string, entry manifestEntry) error {
+	for name, value := range entry.Xattrs {
+		if err := unix.Setxattr(path, name, value, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// xattrRead calls a list or get function first to learn the size of the result, then to read it, retrying
+// if the attributes change in between.
+func xattrRead(read func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := read(nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := read(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		return buf[:n], err
+	}
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// ignoreUnsupported hides the error of filesystems without extended attributes.
+func ignoreUnsupported(err error) error {
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,38 @@
+package main
+
+import "golang.org/x/sys/windows"
+
+// ------------------------------------------------------------------------------------------------------------
+// captureAttrs records the NTFS access control list of a file in its manifest entry, in SDDL form.
+func captureAttrs(path string, entry *manifestEntry) error {
+	sd, err := windows.GetNamedSecurityInfo(longPath(path), windows.SE_FILE_OBJECT, windows.DACL_SECURITY_INFORMATION)
+	if err != nil {
+		return err
+	}
+	entry.ACL = sd.String()
+	return nil
+}
+
+// ------------------------------------------------------------------------------------------------------------
+// applyAttrs sets the access control list recorded for a file on its restored copy, keeping it protected
+// from inheritance if it was.
+func applyAttrs(path string, entry manifestEntry) error {
+	if entry.ACL == "" {
+		return nil
+	}
+	sd, err := windows.SecurityDescriptorFromString(entry.ACL)
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	info := windows.SECURITY_INFORMATION(windows.DACL_SECURITY_INFORMATION)
+	if control, _, err := sd.Control(); err == nil && control&windows.SE_DACL_PROTECTED != 0 {
+		info |= windows.PROTECTED_DACL_SECURITY_INFORMATION
+	} else {
+		info |= windows.UNPROTECTED_DACL_SECURITY_INFORMATION
+	}
+	return windows.SetNamedSecurityInfo(longPath(path), windows.SE_FILE_OBJECT, info, nil, nil, dacl, nil)
+}